@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stationWindow holds the largest intensity message of a station in the current window.
+type stationWindow struct {
+	start time.Time
+	key   string
+	msg   []byte
+	mmi   int32
+
+	// when the window was last updated, by the wall clock
+	seen time.Time
+}
+
+// maxSink sends only the largest intensity message of each station, over all its streams, in each window of
+// message time. A window is sent once a message for a later window arrives, or once the station has had no
+// messages for a window's length, other messages, e.g. all-clear or gaps, are passed on after any pending window.
+type maxSink struct {
+	msimpact.Sink
+	window time.Duration
+
+	pending map[string]*stationWindow
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	sync.Mutex
+}
+
+func newMaxSink(s msimpact.Sink, window time.Duration) *maxSink {
+	m := maxSink{
+		Sink:    s,
+		window:  window,
+		pending: make(map[string]*stationWindow),
+		done:    make(chan struct{}),
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.idle(); err != nil {
+					slog.Error("unable to send windowed message", "error", err)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+
+	return &m
+}
+
+// idle sends the windows of stations that have gone quiet.
+func (m *maxSink) idle() error {
+	m.Lock()
+	defer m.Unlock()
+
+	for station, w := range m.pending {
+		if clock.Now().Sub(w.seen) < m.window {
+			continue
+		}
+		if err := m.flush(station); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush sends any pending window of a station.
+func (m *maxSink) flush(station string) error {
+	w, ok := m.pending[station]
+	if !ok {
+		return nil
+	}
+	delete(m.pending, station)
+	return m.Sink.Send(w.key, w.msg)
+}
+
+func (m *maxSink) Send(key string, msg []byte) error {
+	var v struct {
+		Time      time.Time
+		MMI       int32
+		Type      string
+		Heartbeat bool
+	}
+	if err := json.Unmarshal(msg, &v); err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	station := stationKey(key)
+	if v.Type != "" || v.Heartbeat {
+		if err := m.flush(station); err != nil {
+			return err
+		}
+		return m.Sink.Send(key, msg)
+	}
+
+	start := v.Time.Truncate(m.window)
+	if w, ok := m.pending[station]; ok && !w.start.Equal(start) {
+		if err := m.flush(station); err != nil {
+			return err
+		}
+	}
+
+	w, ok := m.pending[station]
+	switch {
+	case !ok:
+		m.pending[station] = &stationWindow{start: start, key: key, msg: append([]byte{}, msg...), mmi: v.MMI, seen: clock.Now()}
+	case v.MMI > w.mmi:
+		w.key, w.msg, w.mmi, w.seen = key, append([]byte{}, msg...), v.MMI, clock.Now()
+	default:
+		w.seen = clock.Now()
+	}
+	return nil
+}
+
+// Close sends every pending window before closing the wrapped sink.
+func (m *maxSink) Close() error {
+	close(m.done)
+	m.wg.Wait()
+
+	m.Lock()
+	var stations []string
+	for s := range m.pending {
+		stations = append(stations, s)
+	}
+	sort.Strings(stations)
+	for _, s := range stations {
+		if err := m.flush(s); err != nil {
+			slog.Error("unable to send windowed message", "station", s, "error", err)
+		}
+	}
+	m.Unlock()
+
+	return m.Sink.Close()
+}
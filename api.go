@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ozym/msimpact/msimpact"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// streamView is the state of a single stream as reported by the stream api.
+type streamView struct {
+	Stream     string
+	LastRecord time.Time
+	MMI        int32
+	Noisy      bool
+	Probation  string                `json:",omitempty"`
+	LastSent   *msimpact.StreamState `json:",omitempty"`
+	Messages   int
+}
+
+// streamAPI serves the current state of each stream as JSON, /streams lists every stream that has
+// processed a record, /streams/NN_SSS_LL_CCC returns a single stream, and / is a status page.
+func streamAPI(processor *msimpact.StreamProcessor, results *streamSummaries) *http.ServeMux {
+	view := func(name string, s msimpact.StreamStatus) streamView {
+		r, _ := results.Get(name)
+		v := streamView{
+			Stream:     name,
+			LastRecord: s.Record,
+			MMI:        s.MMI,
+			Noisy:      s.Noisy,
+			LastSent:   s.Sent,
+			Messages:   r.Messages,
+		}
+		if s.Probation > 0 {
+			v.Probation = s.Probation.Truncate(time.Second).String()
+		}
+		return v
+	}
+	reply := func(w http.ResponseWriter, code int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", dashboard())
+	mux.HandleFunc("/streams", func(w http.ResponseWriter, r *http.Request) {
+		status := processor.Status()
+		views := make([]streamView, 0, len(status))
+		for k, s := range status {
+			views = append(views, view(k, s))
+		}
+		sort.Slice(views, func(i, j int) bool {
+			return views[i].Stream < views[j].Stream
+		})
+		reply(w, http.StatusOK, views)
+	})
+	mux.HandleFunc("/streams/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/streams/")
+		s, ok := processor.Status()[name]
+		if !ok {
+			reply(w, http.StatusNotFound, map[string]string{"Error": "no records processed for stream " + name})
+			return
+		}
+		reply(w, http.StatusOK, view(name, s))
+	})
+	return mux
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"os"
+	"regexp"
+	"time"
+)
+
+// how long before they expire that temporary credentials are renewed
+const credentialsWindow = 5 * time.Minute
+
+// renewEarly sets temporary credentials to be renewed before they expire, rather than on first failure.
+func renewEarly(o *aws.CredentialsCacheOptions) {
+	o.ExpiryWindow = credentialsWindow
+	o.ExpiryWindowJitterFrac = 0.5
+}
+
+// loadAWS builds the amazon client settings using the standard credential chain (environment, shared
+// credentials and config files including sso profiles, then container or instance roles), an access key
+// given on the command line takes precedence. An empty region leaves it to the environment or profile.
+func loadAWS(ctx context.Context, region, key, secret string) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRetryMode(aws.RetryModeAdaptive),
+		config.WithCredentialsCacheOptions(renewEarly),
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	if key != "" {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(key, secret, "")))
+	}
+	return config.LoadDefaultConfig(ctx, opts...)
+}
+
+// session names may only contain a limited set of characters
+var sessionInvalid = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// assumeRole switches the client settings to use temporary credentials for a role, possibly in another account,
+// the credentials are cached and renewed as they expire. An optional external id is passed on if the role requires it.
+func assumeRole(cfg aws.Config, roleARN, externalID string) aws.Config {
+	host, _ := os.Hostname()
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		if o.RoleSessionName = sessionInvalid.ReplaceAllString("msimpact-"+host, "-"); len(o.RoleSessionName) > 64 {
+			o.RoleSessionName = o.RoleSessionName[:64]
+		}
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+
+	assumed := cfg.Copy()
+	assumed.Credentials = aws.NewCredentialsCache(provider, renewEarly)
+
+	return assumed
+}
+
+// isExpired checks whether a request was refused because the credentials used have expired.
+func isExpired(err error) bool {
+	var api smithy.APIError
+	if !errors.As(err, &api) {
+		return false
+	}
+	switch api.ErrorCode() {
+	case "ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired":
+		return true
+	default:
+		return false
+	}
+}
+
+// refreshSink discards any cached credentials once a send fails because they have expired,
+// so that fresh credentials are used when the send is retried.
+type refreshSink struct {
+	msimpact.Sink
+	credentials aws.CredentialsProvider
+}
+
+func newRefreshSink(s msimpact.Sink, credentials aws.CredentialsProvider) *refreshSink {
+	return &refreshSink{
+		Sink:        s,
+		credentials: credentials,
+	}
+}
+
+func (r *refreshSink) Send(key string, msg []byte) error {
+	err := r.Sink.Send(key, msg)
+	if err != nil && isExpired(err) {
+		if c, ok := r.credentials.(interface {
+			Invalidate()
+		}); ok {
+			slog.Warn("credentials have expired, refreshing", "error", err)
+			c.Invalidate()
+		}
+	}
+	return err
+}
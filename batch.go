@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Batch is the envelope used to send several messages in one, the messages are
+// newline delimited JSON which has then been gzip compressed and base64 encoded.
+type Batch struct {
+	Type     string
+	Encoding string
+	Count    int
+	Messages string
+}
+
+// compressedBatch is the envelope type and encoding.
+const (
+	BatchType     = "batch"
+	BatchEncoding = "gzip+base64"
+)
+
+// batchSink collects messages into batches before passing them on,
+// each batch is sent with the key of its first message.
+type batchSink struct {
+	msimpact.Sink
+	size     int
+	limit    int
+	interval time.Duration
+	encode   func([][]byte) ([]byte, error)
+
+	key  string
+	msgs [][]byte
+
+	// sends a partial batch once its first message is interval old, batches counts those already sent
+	timer   *time.Timer
+	batches int
+
+	// optionally told of each message held back, and later of how many of the oldest were sent, or failed
+	hold func()
+	done func(n int, err error)
+
+	sync.Mutex
+}
+
+// newBatchSink wraps a sink so that up to size messages are sent at once, a partial batch is sent after
+// interval, if set, and batches are split as needed to keep the envelopes below the limit in bytes.
+func newBatchSink(s msimpact.Sink, size, limit int, interval time.Duration) *batchSink {
+	return &batchSink{
+		Sink:     s,
+		size:     size,
+		limit:    limit,
+		interval: interval,
+		encode:   encodeBatch,
+	}
+}
+
+// newArraySink is a batch sink that sends plain JSON arrays of messages rather than compressed envelopes.
+func newArraySink(s msimpact.Sink, size, limit int, interval time.Duration) *batchSink {
+	return &batchSink{
+		Sink:     s,
+		size:     size,
+		limit:    limit,
+		interval: interval,
+		encode:   encodeArray,
+	}
+}
+
+func (b *batchSink) Send(key string, msg []byte) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if len(b.msgs) == 0 {
+		b.key = key
+		if b.interval > 0 {
+			n := b.batches
+			b.timer = time.AfterFunc(b.interval, func() { b.expire(n) })
+		}
+	}
+	b.msgs = append(b.msgs, append([]byte{}, msg...))
+	if b.hold != nil {
+		b.hold()
+	}
+	if len(b.msgs) < b.size {
+		return nil
+	}
+	return b.flush()
+}
+
+// expire sends a partial batch, unless it has already gone.
+func (b *batchSink) expire(n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if n != b.batches {
+		return
+	}
+	if err := b.flush(); err != nil && b.done == nil {
+		slog.Error("unable to send partial batch", "stream", b.key, "error", err)
+	}
+}
+
+// Holding asks to be told of each message held back by the batch, and of the outcome of sending the
+// oldest n held messages, so each message can be counted, or dead lettered, once its batch has gone.
+func (b *batchSink) Holding(hold func(), done func(n int, err error)) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.hold, b.done = hold, done
+}
+
+// report passes on the outcome of sending some of the held messages.
+func (b *batchSink) report(n int, err error) {
+	if b.done != nil {
+		b.done(n, err)
+	}
+}
+
+func (b *batchSink) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	msgs := b.msgs
+	b.msgs = nil
+	b.batches++
+	return b.send(msgs)
+}
+
+func (b *batchSink) send(msgs [][]byte) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	env, err := b.encode(msgs)
+	if err != nil {
+		b.report(len(msgs), err)
+		return err
+	}
+
+	// too big, try again as two smaller batches, the second is still sent if the first fails
+	if b.limit > 0 && len(env) > b.limit && len(msgs) > 1 {
+		err := b.send(msgs[:len(msgs)/2])
+		if e := b.send(msgs[len(msgs)/2:]); err == nil {
+			err = e
+		}
+		return err
+	}
+
+	err = b.Sink.Send(b.key, env)
+	b.report(len(msgs), err)
+	return err
+}
+
+func (b *batchSink) Close() error {
+	b.Lock()
+	defer b.Unlock()
+
+	err := b.flush()
+	if e := b.Sink.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// encodeBatch builds the batch envelope for a set of messages.
+func encodeBatch(msgs [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	for _, m := range msgs {
+		if _, err := gz.Write(append(m, '\n')); err != nil {
+			return nil, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Batch{
+		Type:     BatchType,
+		Encoding: BatchEncoding,
+		Count:    len(msgs),
+		Messages: base64.StdEncoding.EncodeToString(buf.Bytes()),
+	})
+}
+
+// encodeArray joins a set of messages into a JSON array.
+func encodeArray(msgs [][]byte) ([]byte, error) {
+	return append(append([]byte{'['}, bytes.Join(msgs, []byte{','})...), ']'), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"testing"
+	"time"
+)
+
+func TestBatchInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		interval time.Duration
+		sent     []string
+	}{
+		{"full", 2, time.Hour, []string{`[{"MMI":1},{"MMI":2}]`}},
+		{"partial", 10, 20 * time.Millisecond, []string{`[{"MMI":1},{"MMI":2}]`}},
+		{"waiting", 10, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &msimpacttest.Sink{}
+			b := newArraySink(sink, tt.size, 0, tt.interval)
+			for _, m := range []string{`{"MMI":1}`, `{"MMI":2}`} {
+				if err := b.Send("NZ_WEL_20_HNZ", []byte(m)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			time.Sleep(100 * time.Millisecond)
+
+			sent := sink.Sent()
+			if len(sent) != len(tt.sent) {
+				t.Fatalf("expected %d batches, got %d", len(tt.sent), len(sent))
+			}
+			for i, s := range sent {
+				if string(s.Message) != tt.sent[i] || s.Key != "NZ_WEL_20_HNZ" {
+					t.Errorf("unexpected batch %s: %s", s.Key, s.Message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// bench collects the -bench timings, it is nil unless benchmarking.
+var bench *benchmark
+
+// benchmark collects the throughput, the time spent in each stage, and the peak memory use of a run,
+// it is safe for concurrent use and a nil benchmark is ignored.
+type benchmark struct {
+	started time.Time
+
+	records, samples, messages atomic.Int64
+
+	// nanoseconds spent decoding, processing, and marshalling
+	decode, process, marshal atomic.Int64
+
+	// the most heap memory in use, as sampled
+	peak atomic.Uint64
+}
+
+func newBenchmark() *benchmark {
+	return &benchmark{started: time.Now()}
+}
+
+// Decoded notes the time taken to decode a record, and its samples.
+func (b *benchmark) Decoded(elapsed time.Duration, samples int64) {
+	if b == nil {
+		return
+	}
+	b.records.Add(1)
+	b.samples.Add(samples)
+	b.decode.Add(int64(elapsed))
+}
+
+// Observe notes the time taken by a pipeline stage.
+func (b *benchmark) Observe(stage string, elapsed time.Duration) {
+	if b == nil {
+		return
+	}
+	switch stage {
+	case "process":
+		b.process.Add(int64(elapsed))
+	case "marshal":
+		b.messages.Add(1)
+		b.marshal.Add(int64(elapsed))
+	}
+}
+
+// memory samples the heap in use, keeping the largest seen.
+func (b *benchmark) memory() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	for {
+		peak := b.peak.Load()
+		if stats.HeapInuse <= peak || b.peak.CompareAndSwap(peak, stats.HeapInuse) {
+			return
+		}
+	}
+}
+
+// Run samples the memory in use until stopped.
+func (b *benchmark) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		b.memory()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// benchmarkResult is the outcome of a -bench run, the stage times are totals in seconds.
+type benchmarkResult struct {
+	Elapsed       float64
+	Records       int64
+	Samples       int64
+	Messages      int64
+	RecordsPerSec float64
+	SamplesPerSec float64
+	Decode        float64
+	Process       float64
+	Marshal       float64
+	PeakHeapBytes uint64
+	SystemBytes   uint64
+	GCCycles      uint32
+	Procs         int
+}
+
+// Result summarises the benchmark so far.
+func (b *benchmark) Result() *benchmarkResult {
+	if b == nil {
+		return nil
+	}
+	b.memory()
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	r := benchmarkResult{
+		Elapsed:       time.Since(b.started).Seconds(),
+		Records:       b.records.Load(),
+		Samples:       b.samples.Load(),
+		Messages:      b.messages.Load(),
+		Decode:        time.Duration(b.decode.Load()).Seconds(),
+		Process:       time.Duration(b.process.Load()).Seconds(),
+		Marshal:       time.Duration(b.marshal.Load()).Seconds(),
+		PeakHeapBytes: b.peak.Load(),
+		SystemBytes:   stats.Sys,
+		GCCycles:      stats.NumGC,
+		Procs:         runtime.GOMAXPROCS(0),
+	}
+	if r.Elapsed > 0 {
+		r.RecordsPerSec, r.SamplesPerSec = float64(r.Records)/r.Elapsed, float64(r.Samples)/r.Elapsed
+	}
+	return &r
+}
+
+// Print writes a human readable version of the benchmark, with the average time per record, or message, of each stage.
+func (b *benchmarkResult) Print(w io.Writer) {
+	per := func(total float64, n int64) time.Duration {
+		if n == 0 {
+			return 0
+		}
+		return time.Duration(total * float64(time.Second) / float64(n))
+	}
+	seconds := func(s float64) time.Duration {
+		d := time.Duration(s * float64(time.Second))
+		if d >= time.Millisecond {
+			d = d.Round(time.Microsecond)
+		}
+		return d
+	}
+	fmt.Fprintf(w, "benchmark: %d records, %d samples and %d messages in %s\n", b.Records, b.Samples, b.Messages, seconds(b.Elapsed))
+	fmt.Fprintf(w, "  %.0f records/sec, %.0f samples/sec\n", b.RecordsPerSec, b.SamplesPerSec)
+	fmt.Fprintf(w, "  decode  %s, %s per record\n", seconds(b.Decode), per(b.Decode, b.Records))
+	fmt.Fprintf(w, "  process %s, %s per record\n", seconds(b.Process), per(b.Process, b.Records))
+	fmt.Fprintf(w, "  marshal %s, %s per message\n", seconds(b.Marshal), per(b.Marshal, b.Messages))
+	fmt.Fprintf(w, "  peak heap %.1f MiB, %.1f MiB from the system, %d gc cycles, %d procs\n",
+		float64(b.PeakHeapBytes)/(1<<20), float64(b.SystemBytes)/(1<<20), b.GCCycles, b.Procs)
+}
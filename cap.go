@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"time"
+)
+
+// the common alerting protocol version produced
+const capNamespace = "urn:oasis:names:tc:emergency:cap:1.2"
+
+// capOptions controls which messages are sent as CAP alerts and how they are described.
+type capOptions struct {
+	Sender string
+	MMI    int32
+	Radius float64
+}
+
+type capAlert struct {
+	XMLName    xml.Name `xml:"urn:oasis:names:tc:emergency:cap:1.2 alert"`
+	Identifier string   `xml:"identifier"`
+	Sender     string   `xml:"sender"`
+	Sent       string   `xml:"sent"`
+	Status     string   `xml:"status"`
+	MsgType    string   `xml:"msgType"`
+	Scope      string   `xml:"scope"`
+	Info       capInfo  `xml:"info"`
+}
+
+type capInfo struct {
+	Category    string         `xml:"category"`
+	Event       string         `xml:"event"`
+	Urgency     string         `xml:"urgency"`
+	Severity    string         `xml:"severity"`
+	Certainty   string         `xml:"certainty"`
+	Onset       string         `xml:"onset"`
+	Headline    string         `xml:"headline"`
+	Description string         `xml:"description,omitempty"`
+	Parameters  []capParameter `xml:"parameter"`
+	Area        capArea        `xml:"area"`
+}
+
+type capParameter struct {
+	Name  string `xml:"valueName"`
+	Value string `xml:"value"`
+}
+
+type capArea struct {
+	Description string `xml:"areaDesc"`
+	Circle      string `xml:"circle"`
+}
+
+// capSeverity maps an intensity onto the CAP severity scale.
+func capSeverity(mmi int32) string {
+	switch {
+	case mmi >= 8:
+		return "Extreme"
+	case mmi >= 6:
+		return "Severe"
+	case mmi >= 4:
+		return "Moderate"
+	default:
+		return "Minor"
+	}
+}
+
+// capTime formats a time as required by CAP, which does not allow fractional seconds or a Z suffix.
+func capTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05-07:00")
+}
+
+// encodeCAP converts a JSON message into a CAP alert, the returned flag is false for messages that are not
+// alerts, i.e. those below the threshold, placeholders without a position, or reports such as gaps.
+func encodeCAP(key string, msg []byte, opts capOptions) ([]byte, bool, error) {
+	var m msimpact.Message
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, false, err
+	}
+	if m.Type != "" || m.Heartbeat || m.MMI < opts.MMI || (m.Latitude == 0.0 && m.Longitude == 0.0) {
+		return nil, false, nil
+	}
+	if m.Source == "" {
+		m.Source = key
+	}
+
+	// unique per sender, allowing for several channels of a station within a second
+	id := key
+	if id == "" {
+		id = m.Source
+	}
+
+	alert := capAlert{
+		Identifier: fmt.Sprintf("%s-%d", id, m.Time.UnixNano()),
+		Sender:     opts.Sender,
+		Sent:       capTime(time.Now()),
+		Status:     "Actual",
+		MsgType:    "Alert",
+		Scope:      "Public",
+		Info: capInfo{
+			Category:    "Geo",
+			Event:       "Strong ground shaking",
+			Urgency:     "Immediate",
+			Severity:    capSeverity(m.MMI),
+			Certainty:   "Observed",
+			Onset:       capTime(m.Time),
+			Headline:    fmt.Sprintf("MMI %d shaking recorded at %s", m.MMI, m.Source),
+			Description: m.Comment,
+			Parameters: []capParameter{
+				{Name: "MMI", Value: fmt.Sprintf("%d", m.MMI)},
+				{Name: "Quality", Value: m.Quality},
+			},
+			Area: capArea{
+				Description: m.Source,
+				Circle:      fmt.Sprintf("%g,%g %g", m.Latitude, m.Longitude, opts.Radius),
+			},
+		},
+	}
+
+	b, err := xml.Marshal(alert)
+	if err != nil {
+		return nil, false, err
+	}
+	return append([]byte(xml.Header[:len(xml.Header)-1]), b...), true, nil
+}
+
+// capSink sends messages at or above the threshold intensity as CAP alerts, other messages are dropped.
+type capSink struct {
+	msimpact.Sink
+	opts capOptions
+}
+
+func (c *capSink) Send(key string, msg []byte) error {
+	b, ok, err := encodeCAP(key, msg, c.opts)
+	if err != nil || !ok {
+		return err
+	}
+	return c.Sink.Send(key, b)
+}
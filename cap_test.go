@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestCAPIdentifier(t *testing.T) {
+	opts := capOptions{Sender: "test@example.com", MMI: 4, Radius: 10}
+
+	tests := []struct {
+		key string
+		msg string
+		id  string
+	}{
+		{"NZ_WEL_20_HNZ", `{"Source":"NZ.WEL","Latitude":-41.3,"Longitude":174.8,"Time":"2016-11-13T11:02:56.5Z","MMI":5}`, "NZ_WEL_20_HNZ-1479034976500000000"},
+		{"NZ_WEL_20_HNN", `{"Source":"NZ.WEL","Latitude":-41.3,"Longitude":174.8,"Time":"2016-11-13T11:02:56.5Z","MMI":5}`, "NZ_WEL_20_HNN-1479034976500000000"},
+		{"NZ_WEL_20_HNZ", `{"Source":"NZ.WEL","Latitude":-41.3,"Longitude":174.8,"Time":"2016-11-13T11:02:56.75Z","MMI":5}`, "NZ_WEL_20_HNZ-1479034976750000000"},
+		{"", `{"Source":"NZ.WEL","Latitude":-41.3,"Longitude":174.8,"Time":"2016-11-13T11:02:56Z","MMI":5}`, "NZ.WEL-1479034976000000000"},
+	}
+
+	for _, tt := range tests {
+		b, ok, err := encodeCAP(tt.key, []byte(tt.msg), opts)
+		if err != nil || !ok {
+			t.Fatalf("unable to encode %s: %v", tt.key, err)
+		}
+		var alert capAlert
+		if err := xml.Unmarshal(b, &alert); err != nil {
+			t.Fatal(err)
+		}
+		if alert.Identifier != tt.id {
+			t.Errorf("expected identifier %s, got %s", tt.id, alert.Identifier)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/ozym/msimpact/msimpact"
+
+// clock is the current time used for replay shifts, pacing, and failing back, and by the stream processor,
+// it may be replaced by a fake, e.g. an msimpacttest.Clock.
+var clock msimpact.Clock = msimpact.SystemClock
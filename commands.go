@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// flagGroups are the sets of related flags, commands are built from these.
+var flagGroups = map[string][]string{
+	"general":    {"verbose", "log-format", "log-level", "version", "selftest"},
+	"config":     {"config", "config-region", "region", "key", "secret", "role-arn", "external-id"},
+	"processing": {"config-refresh", "probation", "level", "warn-level", "initial-mmi", "all-clear", "baseline", "scales", "flush", "min-mmi", "max-window", "heartbeat", "gap-messages", "duplicates", "match", "reject"},
+	"files":      {"reclen", "sort", "workers", "since", "checkpoint", "starttime", "endtime", "max-records-per-file", "max-errors", "progress", "dump-headers", "dump-format", "ordered", "ordered-memory", "ordered-dir", "fdsn", "start", "end", "fdsn-timeout"},
+	"replay":     {"replay", "replay-shift", "speed"},
+	"realtime":   {"seedlink", "seedlink-timeout", "datalink", "datalink-timeout", "follow", "follow-interval", "follow-from-start", "stream-queue", "reorder", "max-latency"},
+	"outputs": {"dry-run", "bench", "list-sinks", "queue", "queue-owner", "sqs-endpoint", "fifo-dedup", "create-queue", "queue-attributes", "batch", "batch-interval", "failover-queue", "failover-region",
+		"failover-attempts", "failover-threshold", "failback-interval", "retry-attempts", "retry-elapsed", "retry-delay",
+		"spool", "spool-max-bytes", "spool-max-age", "spool-interval", "roundtrip-test", "roundtrip-timeout", "sns", "kinesis",
+		"kafka", "kafka-topic", "kafka-key", "kafka-acks", "nats", "nats-subject", "nats-jetstream", "mqtt", "mqtt-topic", "mqtt-qos",
+		"mqtt-ca", "mqtt-cert", "mqtt-key", "webhook", "webhook-header", "webhook-timeout", "webhook-batch", "out", "out-max-bytes",
+		"out-daily", "unix-socket", "unix-listen", "serve-ws", "serve-grpc", "sink-mmi", "sink-streams", "format", "sink-format", "cap-sender", "cap-mmi",
+		"cap-radius", "max-message-size", "rate-limit", "rate-burst", "stream-rate-limit", "stream-rate-burst", "sign-key-file", "dead-letter"},
+	"monitoring": {"http-addr", "api-addr", "debug-addr", "statsd", "statsd-prefix", "statsd-tags", "health-max-age", "summary-json"},
+	"run":        {"max-runtime", "shutdown-timeout", "state-file", "state-interval", "state-max-age"},
+}
+
+// command is a mode of running msimpact, limited to the flags that make sense for it.
+type command struct {
+	Name        string
+	Args        string
+	Description string
+	Groups      []string
+}
+
+// the available commands, running without a command is the same as send
+var commands = []command{
+	{
+		Name:        "send",
+		Args:        "[files ...]",
+		Description: "process miniseed files, or an fdsn request, and send the messages",
+		Groups:      []string{"general", "config", "processing", "files", "outputs", "monitoring", "run"},
+	},
+	{
+		Name:        "replay",
+		Args:        "files ...",
+		Description: "process miniseed files as if happening now, with the current time or -replay-shift, optionally paced by -speed",
+		Groups:      []string{"general", "config", "processing", "files", "replay", "outputs", "monitoring", "run"},
+	},
+	{
+		Name:        "serve",
+		Args:        "[files ...]",
+		Description: "continuously process real-time records from seedlink, datalink, or followed files",
+		Groups:      []string{"general", "config", "processing", "realtime", "outputs", "monitoring", "run"},
+	},
+	{
+		Name:        "resend",
+		Args:        "files ...",
+		Description: "send the messages in dead letter files again, each to the output that failed to deliver it",
+		Groups:      []string{"general", "config", "outputs", "monitoring"},
+	},
+	{
+		Name:        "check-config",
+		Description: "load and check the stream config, then exit",
+		Groups:      []string{"general", "config"},
+	},
+}
+
+// defaultCommand is run when the first argument is not a command.
+const defaultCommand = "send"
+
+// findCommand looks up a command by name.
+func findCommand(name string) (*command, bool) {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i], true
+		}
+	}
+	return nil, false
+}
+
+// flagSet builds the flags of the command from those defined, the values are shared so parsing
+// the command flags sets the defined variables, an unknown flag name is an error.
+func (c *command) flagSet(defined *flag.FlagSet) (*flag.FlagSet, error) {
+	names := make(map[string]bool)
+	for _, g := range c.Groups {
+		for _, n := range flagGroups[g] {
+			if defined.Lookup(n) == nil {
+				return nil, fmt.Errorf("command %s refers to an unknown flag: %s", c.Name, n)
+			}
+			names[n] = true
+		}
+	}
+	flags := flag.NewFlagSet(c.Name, flag.ExitOnError)
+	flags.SetOutput(defined.Output())
+	defined.VisitAll(func(f *flag.Flag) {
+		if names[f.Name] {
+			flags.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	return flags, nil
+}
+
+// usage writes the flags of the command.
+func (c *command) usage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: %s\n\n%s\n\n", strings.TrimSpace(os.Args[0]+" "+c.Name+" [flags] "+c.Args), c.Description)
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.DefValue != "" {
+			fmt.Fprintf(w, "  -%s\t%s (default %q)\n", f.Name, f.Usage, f.DefValue)
+		} else {
+			fmt.Fprintf(w, "  -%s\t%s\n", f.Name, f.Usage)
+		}
+	})
+}
+
+// parseCommand parses the command line flags after any leading command, each command has its own flag set
+// built from the defined flags, holding only the flags it uses, without a command the arguments are those of send.
+func parseCommand(defined *flag.FlagSet, args []string) (*command, *flag.FlagSet) {
+	cmd, named := (*command)(nil), false
+	if len(args) > 0 {
+		cmd, named = findCommand(args[0])
+	}
+	if named {
+		args = args[1:]
+	} else {
+		cmd, _ = findCommand(defaultCommand)
+	}
+
+	flags, err := cmd.flagSet(defined)
+	if err != nil {
+		fmt.Fprintln(defined.Output(), err)
+		os.Exit(2)
+	}
+	flags.Usage = func() {
+		if !named {
+			fmt.Fprintf(flags.Output(), "usage: %s [command] [flags] [files ...]\n\ncommands:\n", os.Args[0])
+			fmt.Fprintf(flags.Output(), "  genconfig\tbuild a stream config from an fdsn station service\n")
+			for _, c := range commands {
+				fmt.Fprintf(flags.Output(), "  %s\t%s\n", c.Name, c.Description)
+			}
+			fmt.Fprintf(flags.Output(), "\nwithout a command the %s command is run\n\n", defaultCommand)
+		}
+		cmd.usage(flags.Output(), flags)
+	}
+	flags.Parse(args)
+
+	return cmd, flags
+}
+
+// isFlagSet checks whether a flag was given on the command line.
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	var found bool
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestCommandFlagSet(t *testing.T) {
+	defined := flag.NewFlagSet("msimpact", flag.ContinueOnError)
+	for _, names := range flagGroups {
+		for _, n := range names {
+			defined.String(n, "", n)
+		}
+	}
+
+	tests := []struct {
+		command string
+		args    []string
+		err     bool
+	}{
+		{"send", []string{"-queue", "impact", "a.mseed"}, false},
+		{"send", []string{"-seedlink", "localhost:18000"}, true},
+		{"serve", []string{"-seedlink", "localhost:18000"}, false},
+		{"replay", []string{"-speed", "2", "a.mseed"}, false},
+		{"check-config", []string{"-queue", "impact"}, true},
+		{"resend", []string{"-dead-letter", "dead.jsonl"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command+" "+tt.args[0], func(t *testing.T) {
+			cmd, ok := findCommand(tt.command)
+			if !ok {
+				t.Fatalf("unknown command %s", tt.command)
+			}
+			flags, err := cmd.flagSet(defined)
+			if err != nil {
+				t.Fatal(err)
+			}
+			flags.Init(cmd.Name, flag.ContinueOnError)
+			flags.Usage = func() {}
+
+			err = flags.Parse(tt.args)
+			switch {
+			case tt.err && err == nil:
+				t.Fatalf("expected %s to reject %s", tt.command, tt.args[0])
+			case !tt.err && err != nil:
+				t.Fatal(err)
+			case !tt.err:
+				// the command flags share their values with the defined flags
+				if v := defined.Lookup(tt.args[0][1:]).Value.String(); v != tt.args[1] {
+					t.Errorf("expected %s of %s, got %s", tt.args[0], tt.args[1], v)
+				}
+			}
+		})
+	}
+}
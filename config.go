@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ozym/impact"
+	"github.com/ozym/msimpact/msimpact"
+	"gopkg.in/yaml.v2"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// decodeConfig converts a stream config to JSON, YAML (.yaml or .yml) and TOML (.toml)
+// configs are recognised by the name extension.
+func decodeConfig(name string, b []byte) ([]byte, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		return json.Marshal(jsonValue(v))
+	case ".toml":
+		var v map[string]interface{}
+		if _, err := toml.Decode(string(b), &v); err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	default:
+		return b, nil
+	}
+}
+
+// jsonValue converts the generic maps decoded from YAML into maps that can be encoded as JSON.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, v := range x {
+			m[fmt.Sprint(k)] = jsonValue(v)
+		}
+		return m
+	case []interface{}:
+		for i := range x {
+			x[i] = jsonValue(x[i])
+		}
+		return x
+	default:
+		return v
+	}
+}
+
+// parseConfig decodes a stream config, the name is used to find the format.
+func parseConfig(name string, raw []byte) (*msimpact.Config, error) {
+	b, err := decodeConfig(name, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	set := msimpact.Config{
+		Streams:  make(map[string]*impact.Stream),
+		Settings: make(map[string]msimpact.StreamConfig),
+		Entries:  make(map[string][]byte),
+	}
+
+	if err := json.Unmarshal(b, &set.Streams); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &set.Settings); err != nil {
+		return nil, err
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	for k, v := range entries {
+		var b bytes.Buffer
+		if err := json.Compact(&b, v); err != nil {
+			return nil, err
+		}
+		set.Entries[k] = b.Bytes()
+	}
+
+	return &set, nil
+}
+
+// configSource fetches a stream config from a local file, an http(s) url, or an s3://bucket/key url,
+// remembering enough to tell whether it has since changed.
+type configSource struct {
+	location string
+
+	// amazon access for s3 configs
+	s3 *s3.Client
+
+	client http.Client
+
+	etag     string
+	modified time.Time
+}
+
+func newConfigSource(location string, client *s3.Client) *configSource {
+	return &configSource{
+		location: location,
+		s3:       client,
+		client:   http.Client{Timeout: time.Minute},
+	}
+}
+
+// isRemoteConfig checks whether a config location is a url rather than a file.
+func isRemoteConfig(location string) bool {
+	for _, p := range []string{"s3://", "http://", "https://"} {
+		if strings.HasPrefix(location, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the location path, for recognising the config format.
+func (c *configSource) Name() string {
+	if u, err := url.Parse(c.location); err == nil && isRemoteConfig(c.location) {
+		return u.Path
+	}
+	return c.location
+}
+
+// Fetch returns the config contents, or nil if unchanged since the last fetch unless forced.
+func (c *configSource) Fetch(force bool) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(c.location, "s3://"):
+		return c.fetchS3(force)
+	case isRemoteConfig(c.location):
+		return c.fetchHTTP(force)
+	default:
+		return c.fetchFile(force)
+	}
+}
+
+func (c *configSource) fetchFile(force bool) ([]byte, error) {
+	info, err := os.Stat(c.location)
+	if err != nil {
+		return nil, err
+	}
+	if !force && info.ModTime().Equal(c.modified) {
+		return nil, nil
+	}
+	b, err := os.ReadFile(c.location)
+	if err != nil {
+		return nil, err
+	}
+	c.modified = info.ModTime()
+	return b, nil
+}
+
+// readResponse handles a possibly conditional config request.
+func (c *configSource) readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unable to fetch config %s: %s", c.location, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.etag = resp.Header.Get("ETag")
+
+	return b, nil
+}
+
+func (c *configSource) fetchHTTP(force bool) ([]byte, error) {
+	req, err := http.NewRequest("GET", c.location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !force && c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.readResponse(resp)
+}
+
+func (c *configSource) fetchS3(force bool) ([]byte, error) {
+	u, err := url.Parse(c.location)
+	if err != nil {
+		return nil, err
+	}
+	input := s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	}
+	if !force && c.etag != "" {
+		input.IfNoneMatch = aws.String(c.etag)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.client.Timeout)
+	defer cancel()
+
+	resp, err := c.s3.GetObject(ctx, &input)
+	if err != nil {
+		var e *awshttp.ResponseError
+		if errors.As(err, &e) && e.HTTPStatusCode() == http.StatusNotModified {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.etag = aws.ToString(resp.ETag)
+
+	return b, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// datalink packets start with a fixed preheader and a header length
+const datalinkPreheader = 3
+
+// datalinkClient streams matching miniseed packets from a ringserver using the datalink protocol,
+// reconnecting on failure and resuming after the last packet received.
+type datalinkClient struct {
+	addr    string
+	timeout time.Duration
+	match   string
+
+	// last received packet id
+	last int64
+}
+
+// newDatalinkClient builds the stream match expression from a list of stream names in the form NN_SSS_LL_CCC,
+// which may include wildcards.
+func newDatalinkClient(addr string, timeout time.Duration, streams []string) *datalinkClient {
+	var ids []string
+	for _, s := range streams {
+		ids = append(ids, msimpact.WildcardRegexp(s))
+	}
+	sort.Strings(ids)
+
+	return &datalinkClient{
+		addr:    addr,
+		timeout: timeout,
+		match:   "^(" + strings.Join(ids, "|") + ")/MSEED$",
+		last:    -1,
+	}
+}
+
+// Run receives records until the stop channel is closed.
+func (c *datalinkClient) Run(msr *mseed.MSRecord, stop <-chan struct{}, handler func(msimpact.Record) error) error {
+	return reconnect("datalink", stop, func() error {
+		return c.session(msr, stop, handler)
+	})
+}
+
+func (c *datalinkClient) session(msr *mseed.MSRecord, stop <-chan struct{}, handler func(msimpact.Record) error) error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// unblock any reads once stopped
+	defer closeOnStop(stop, conn)()
+
+	in := bufio.NewReader(conn)
+
+	send := func(header string, data []byte) error {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+		pkt := append([]byte{'D', 'L', byte(len(header))}, header...)
+		_, err := conn.Write(append(pkt, data...))
+		return err
+	}
+	receive := func() ([]string, []byte, error) {
+		conn.SetReadDeadline(time.Now().Add(c.timeout))
+		pre := make([]byte, datalinkPreheader)
+		if _, err := io.ReadFull(in, pre); err != nil {
+			return nil, nil, err
+		}
+		if string(pre[0:2]) != "DL" {
+			return nil, nil, fmt.Errorf("invalid datalink preheader: %q", pre)
+		}
+		header := make([]byte, int(pre[2]))
+		if _, err := io.ReadFull(in, header); err != nil {
+			return nil, nil, err
+		}
+		fields := strings.Fields(string(header))
+		if len(fields) == 0 {
+			return nil, nil, fmt.Errorf("empty datalink header")
+		}
+
+		// which responses carry a data payload
+		var size string
+		switch {
+		case fields[0] == "PACKET" && len(fields) > 6:
+			size = fields[6]
+		case (fields[0] == "OK" || fields[0] == "ERROR") && len(fields) > 2:
+			size = fields[2]
+		}
+
+		var data []byte
+		if size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil {
+				return nil, nil, err
+			}
+			data = make([]byte, n)
+			if _, err := io.ReadFull(in, data); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return fields, data, nil
+	}
+	command := func(header string, data []byte) error {
+		if err := send(header, data); err != nil {
+			return err
+		}
+		fields, msg, err := receive()
+		if err != nil {
+			return err
+		}
+		if fields[0] == "ERROR" {
+			return fmt.Errorf("datalink command %q failed: %s", header, strings.TrimSpace(string(msg)))
+		}
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	if err := command(fmt.Sprintf("ID msimpact::%s:%d:%s", host, os.Getpid(), runtime.GOARCH), nil); err != nil {
+		return err
+	}
+	if err := command(fmt.Sprintf("MATCH %d", len(c.match)), []byte(c.match)); err != nil {
+		return err
+	}
+	if c.last >= 0 {
+		if err := command(fmt.Sprintf("POSITION SET %d", c.last), nil); err != nil {
+			return err
+		}
+	}
+	if err := send("STREAM", nil); err != nil {
+		return err
+	}
+
+	for {
+		fields, data, err := receive()
+		if err != nil {
+			return err
+		}
+		switch fields[0] {
+		case "PACKET":
+		case "ERROR":
+			return fmt.Errorf("datalink stream error: %s", strings.TrimSpace(string(data)))
+		default:
+			continue
+		}
+
+		if id, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+			c.last = id
+		}
+
+		// decode mseed block, real-time streams carry on regardless of the number of bad records
+		if err := unpack(msr, data); err != nil {
+			undecodable.Skipped(c.addr, 0, err)
+			continue
+		}
+
+		if err := handler(msr); err != nil {
+			return err
+		}
+	}
+}
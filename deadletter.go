@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadLetter appends messages that could not be delivered, along with the reason, to a file.
+type deadLetter struct {
+	file *os.File
+	sync.Mutex
+}
+
+// deadLetterEntry is a single line in a dead letter file, the output is only set for messages that
+// an output failed to deliver, others were not sent to any output.
+type deadLetterEntry struct {
+	Time    time.Time
+	Reason  string
+	Output  string `json:",omitempty"`
+	Stream  string `json:",omitempty"`
+	Message json.RawMessage
+}
+
+func openDeadLetter(path string) (*deadLetter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetter{file: file}, nil
+}
+
+// Write adds a message from a stream to the file, a nil dead letter silently discards it.
+func (d *deadLetter) Write(key string, msg []byte, reason string) error {
+	return d.write(deadLetterEntry{Reason: reason, Stream: key, Message: json.RawMessage(msg)})
+}
+
+// Failed adds a message an output was unable to deliver.
+func (d *deadLetter) Failed(output, key string, msg []byte, err error) error {
+	return d.write(deadLetterEntry{Reason: err.Error(), Output: output, Stream: key, Message: json.RawMessage(msg)})
+}
+
+func (d *deadLetter) write(entry deadLetterEntry) error {
+	if d == nil {
+		return nil
+	}
+
+	entry.Time = time.Now().UTC()
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, err := d.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	metricDeadLetters.Inc()
+	stats.Count("dead_letters", 1)
+
+	return nil
+}
+
+func (d *deadLetter) Close() error {
+	if d == nil {
+		return nil
+	}
+	return d.file.Close()
+}
+
+// readDeadLetters passes each entry of a dead letter file to the handler in turn.
+func readDeadLetters(path string, handler func(deadLetterEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rd := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		b, err := rd.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(b) > 0 {
+			var entry deadLetterEntry
+			if err := json.Unmarshal(b, &entry); err != nil {
+				return fmt.Errorf("%s:%d: %s", path, line, err)
+			}
+			if err := handler(entry); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
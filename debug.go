@@ -0,0 +1,18 @@
+package main
+
+import (
+	_ "expvar"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+)
+
+// serveDebug runs the pprof (/debug/pprof/) and expvar (/debug/vars) handlers in the background,
+// these are kept separate from the monitoring endpoints so they need not be exposed as widely.
+func serveDebug(addr string) {
+	go func() {
+		if err := http.ListenAndServe(addr, http.DefaultServeMux); err != nil {
+			slog.Error("unable to serve debug endpoints", "addr", addr, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// recordHeader holds the decoded fixed header fields of a miniseed record.
+type recordHeader struct {
+	Network     string
+	Station     string
+	Location    string
+	Channel     string
+	Starttime   time.Time
+	SampleRate  float64
+	SampleCount int64
+	Encoding    int8
+	ByteOrder   int8
+}
+
+func newRecordHeader(msr msimpact.Record) recordHeader {
+	trim := func(s string) string {
+		return strings.TrimRight(s, "\u0000 ")
+	}
+	return recordHeader{
+		Network:     trim(msr.Network()),
+		Station:     trim(msr.Station()),
+		Location:    trim(msr.Location()),
+		Channel:     trim(msr.Channel()),
+		Starttime:   msr.Starttime().UTC(),
+		SampleRate:  msr.Samprate(),
+		SampleCount: msr.Samplecnt(),
+		Encoding:    msr.Encoding(),
+		ByteOrder:   msr.Byteorder(),
+	}
+}
+
+// headerDumper prints record headers either as an aligned table or as NDJSON.
+type headerDumper struct {
+	table *tabwriter.Writer
+	enc   *json.Encoder
+}
+
+func newHeaderDumper(w io.Writer, format string) (*headerDumper, error) {
+	switch format {
+	case "table":
+		t := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+		fmt.Fprintln(t, "NET\tSTA\tLOC\tCHA\tSTARTTIME\tRATE\tSAMPLES\tENCODING\tORDER")
+		return &headerDumper{table: t}, nil
+	case "json":
+		return &headerDumper{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown header dump format: %s", format)
+	}
+}
+
+func (d *headerDumper) Dump(msr msimpact.Record) error {
+	h := newRecordHeader(msr)
+	if d.enc != nil {
+		return d.enc.Encode(h)
+	}
+	_, err := fmt.Fprintf(d.table, "%s\t%s\t%s\t%s\t%s\t%g\t%d\t%d\t%d\n",
+		h.Network, h.Station, h.Location, h.Channel, h.Starttime.Format(time.RFC3339Nano), h.SampleRate, h.SampleCount, h.Encoding, h.ByteOrder)
+	return err
+}
+
+func (d *headerDumper) Flush() error {
+	if d.table != nil {
+		return d.table.Flush()
+	}
+	return nil
+}
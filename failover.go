@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// failoverSink sends to a primary output, switching to a secondary after a run of failures, while on the
+// secondary the primary is tried again at each interval and used again once it works. A message the
+// primary fails to deliver is always passed on to the secondary.
+type failoverSink struct {
+	primary, secondary msimpact.Sink
+
+	threshold int
+	interval  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	failed   bool
+	checked  time.Time
+}
+
+func newFailoverSink(primary, secondary msimpact.Sink, threshold int, interval time.Duration) *failoverSink {
+	metricFailover.WithLabelValues("primary").Set(1)
+	metricFailover.WithLabelValues("secondary").Set(0)
+	return &failoverSink{
+		primary:   primary,
+		secondary: secondary,
+		threshold: threshold,
+		interval:  interval,
+	}
+}
+
+// usePrimary checks whether the primary should be tried for the next message.
+func (f *failoverSink) usePrimary() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.failed {
+		return true
+	}
+	if clock.Now().Sub(f.checked) < f.interval {
+		return false
+	}
+	f.checked = clock.Now()
+	return true
+}
+
+// result notes whether the primary worked, switching over, or back, as required.
+func (f *failoverSink) result(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case err == nil && f.failed:
+		slog.Info("primary output has recovered, failing back")
+		f.failed, f.failures = false, 0
+		metricFailovers.WithLabelValues("primary").Inc()
+		metricFailover.WithLabelValues("primary").Set(1)
+		metricFailover.WithLabelValues("secondary").Set(0)
+	case err == nil:
+		f.failures = 0
+	case !f.failed:
+		if f.failures++; f.failures >= f.threshold {
+			slog.Warn("primary output is failing, failing over to the secondary", "failures", f.failures, "error", err)
+			f.failed, f.checked = true, clock.Now()
+			metricFailovers.WithLabelValues("secondary").Inc()
+			metricFailover.WithLabelValues("primary").Set(0)
+			metricFailover.WithLabelValues("secondary").Set(1)
+		}
+	}
+}
+
+func (f *failoverSink) Send(key string, msg []byte) error {
+	if f.usePrimary() {
+		err := f.primary.Send(key, msg)
+		f.result(err)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("primary output failed, sending to the secondary", "stream", key, "error", err)
+	}
+	return f.secondary.Send(key, msg)
+}
+
+func (f *failoverSink) Close() error {
+	err := f.primary.Close()
+	if e := f.secondary.Close(); e != nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how many messages may be waiting for a slow output before any more for it are dropped
+const outputQueue = 1024
+
+// errOutputFull is recorded for messages dropped because an output is not keeping up.
+var errOutputFull = fmt.Errorf("output queue full, %d messages waiting", outputQueue)
+
+// sinkOptions collects repeated "name=value" flags keyed by output name.
+type sinkOptions map[string]string
+
+func (o sinkOptions) String() string {
+	var opts []string
+	for k, v := range o {
+		opts = append(opts, k+"="+v)
+	}
+	return strings.Join(opts, " ")
+}
+
+func (o sinkOptions) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected an output name=value setting, got %q", value)
+	}
+	for _, s := range sinkRegistry {
+		if s.Name == parts[0] {
+			o[parts[0]] = parts[1]
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output: %s", parts[0])
+}
+
+// output is a single destination with its own message filtering and delivery.
+type output struct {
+	name    string
+	sink    msimpact.Sink
+	minMMI  int32
+	streams []string
+
+	// streams which have had a message pass the threshold, and so will need an all-clear
+	elevated map[string]bool
+
+	queue  chan delivery
+	sent   int
+	failed int
+
+	// messages held back by a batch, with the delivery being sent and whether it was held
+	held    []delivery
+	current delivery
+	holding bool
+}
+
+type delivery struct {
+	key string
+	msg []byte
+}
+
+// accepts checks whether a message should be passed to the output, all-clear messages
+// only follow earlier messages that passed the threshold.
+func (o *output) accepts(key string, msg []byte) bool {
+	if len(o.streams) > 0 {
+		if _, ok := msimpact.MatchWildcard(o.streams, key); !ok {
+			return false
+		}
+	}
+	if o.minMMI <= 0 {
+		return true
+	}
+
+	var m struct {
+		MMI  int32
+		Type string
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return true
+	}
+
+	switch {
+	case m.Type == msimpact.AllClear:
+		ok := o.elevated[key]
+		delete(o.elevated, key)
+		return ok
+	case m.MMI >= o.minMMI:
+		o.elevated[key] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// fanout delivers messages concurrently to each output, a failing output is logged
+// but does not stop delivery to the others.
+type fanout struct {
+	outputs []*output
+	wg      sync.WaitGroup
+
+	// where undelivered messages are kept, if anywhere
+	dead *deadLetter
+
+	// wait for a slow output rather than dropping its messages, for inputs such as files that can be held up
+	wait bool
+
+	sync.Mutex
+}
+
+// Add starts delivery to an output, an optional MMI threshold and comma separated stream patterns
+// are given as strings as found on the command line.
+func (f *fanout) Add(name string, s msimpact.Sink, minMMI, streams string) error {
+	o := output{
+		name:     name,
+		sink:     s,
+		elevated: make(map[string]bool),
+		queue:    make(chan delivery, outputQueue),
+	}
+	if minMMI != "" {
+		v, err := strconv.Atoi(minMMI)
+		if err != nil {
+			return fmt.Errorf("invalid MMI threshold for %s output: %s", name, err)
+		}
+		o.minMMI = int32(v)
+	}
+	if streams != "" {
+		o.streams = strings.Split(streams, ",")
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		for d := range o.queue {
+			f.Lock()
+			o.current, o.holding = d, false
+			f.Unlock()
+
+			start := time.Now()
+			err := o.sink.Send(d.key, d.msg)
+			metricLatency.WithLabelValues(o.name).Observe(time.Since(start).Seconds())
+			stats.Timing("send."+o.name, time.Since(start))
+
+			// a held message is only counted once its batch has gone
+			f.Lock()
+			held := o.holding
+			f.Unlock()
+			if held {
+				continue
+			}
+
+			status.Output(o.name, err == nil)
+			if err != nil {
+				f.failed(&o, d, err)
+				continue
+			}
+			f.delivered(&o)
+		}
+	}()
+
+	f.outputs = append(f.outputs, &o)
+
+	return nil
+}
+
+// Holding tracks the messages held back by an output, such as a batch, counting each as sent, or failed,
+// only once the output has reported on it.
+func (f *fanout) Holding(name string, h holdingSink) error {
+	for _, o := range f.outputs {
+		if o.name != name {
+			continue
+		}
+		h.Holding(func() {
+			f.Lock()
+			defer f.Unlock()
+
+			o.held, o.holding = append(o.held, o.current), true
+		}, func(n int, err error) {
+			f.release(o, n, err)
+		})
+		return nil
+	}
+	return fmt.Errorf("output not configured: %s", name)
+}
+
+// holdingSink is implemented by outputs that hold messages back to send later.
+type holdingSink interface {
+	Holding(hold func(), done func(n int, err error))
+}
+
+// release counts the oldest n held messages of an output as sent, or as failed with an error.
+func (f *fanout) release(o *output, n int, err error) {
+	f.Lock()
+	if n > len(o.held) {
+		n = len(o.held)
+	}
+	held := o.held[:n:n]
+	o.held = o.held[n:]
+	f.Unlock()
+
+	status.Output(o.name, err == nil)
+	for _, d := range held {
+		if err != nil {
+			f.failed(o, d, err)
+		} else {
+			f.delivered(o)
+		}
+	}
+}
+
+// delivered notes a message an output has sent.
+func (f *fanout) delivered(o *output) {
+	metricSent.WithLabelValues(o.name).Inc()
+	stats.Count("sent."+o.name, 1)
+	f.Lock()
+	o.sent++
+	f.Unlock()
+}
+
+// failed notes a message an output was unable to deliver.
+func (f *fanout) failed(o *output, d delivery, err error) {
+	slog.Error("output problem", "output", o.name, "stream", d.key, "error", err)
+	metricFailed.WithLabelValues(o.name).Inc()
+	stats.Count("failed."+o.name, 1)
+	f.Lock()
+	o.failed++
+	f.Unlock()
+	if err := f.dead.Failed(o.name, d.key, d.msg, err); err != nil {
+		slog.Error("unable to write dead letter", "output", o.name, "stream", d.key, "error", err)
+	}
+}
+
+// enqueue passes a message to an output, unless its queue is full, so a hung output does not hold up the others.
+func (f *fanout) enqueue(o *output, d delivery) {
+	if f.wait {
+		o.queue <- d
+		return
+	}
+	select {
+	case o.queue <- d:
+	default:
+		status.Output(o.name, false)
+		f.failed(o, d, errOutputFull)
+	}
+}
+
+func (f *fanout) Send(key string, msg []byte) error {
+	for _, o := range f.outputs {
+		if o.accepts(key, msg) {
+			f.enqueue(o, delivery{key: key, msg: msg})
+		}
+	}
+	return nil
+}
+
+// SendTo passes a message to a single output, if it has been added.
+func (f *fanout) SendTo(name, key string, msg []byte) error {
+	for _, o := range f.outputs {
+		if o.name == name {
+			if o.accepts(key, msg) {
+				f.enqueue(o, delivery{key: key, msg: msg})
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("output not configured: %s", name)
+}
+
+// Close waits for any queued messages to be delivered before closing each output.
+func (f *fanout) Close() error {
+	for _, o := range f.outputs {
+		close(o.queue)
+	}
+	f.wg.Wait()
+
+	var last error
+	for _, o := range f.outputs {
+		if err := o.sink.Close(); err != nil {
+			slog.Error("unable to close output", "output", o.name, "error", err)
+			last = err
+		}
+	}
+	return last
+}
+
+// Sent returns the number of messages each output delivered.
+func (f *fanout) Sent() map[string]int {
+	f.Lock()
+	defer f.Unlock()
+
+	sent := make(map[string]int)
+	for _, o := range f.outputs {
+		sent[o.name] += o.sent
+	}
+	return sent
+}
+
+// Failed returns the number of messages each output was unable to deliver.
+func (f *fanout) Failed() map[string]int {
+	f.Lock()
+	defer f.Unlock()
+
+	failed := make(map[string]int)
+	for _, o := range f.outputs {
+		if o.failed > 0 {
+			failed[o.name] += o.failed
+		}
+	}
+	return failed
+}
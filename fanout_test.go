@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockedSink holds up every send until released.
+type blockedSink struct {
+	release chan struct{}
+}
+
+func (b *blockedSink) Send(key string, msg []byte) error {
+	<-b.release
+	return nil
+}
+
+func (b *blockedSink) Close() error {
+	return nil
+}
+
+func TestFanoutSlowOutput(t *testing.T) {
+	slow, fast := &blockedSink{release: make(chan struct{})}, &blockedSink{release: make(chan struct{})}
+	close(fast.release)
+
+	var f fanout
+	if err := f.Add("slow", slow, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("fast", fast, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hung output must not hold up sending
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*outputQueue; i++ {
+			f.Send("NZ_WEL_20_HNZ", []byte(`{"MMI":3}`))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending was held up by a slow output")
+	}
+
+	close(slow.release)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, failed := f.Sent(), f.Failed()
+	if sent["fast"] < outputQueue || sent["fast"]+failed["fast"] != 2*outputQueue {
+		t.Errorf("expected the fast output to keep sending, sent %d and failed %d", sent["fast"], failed["fast"])
+	}
+	if sent["slow"] > outputQueue+1 || sent["slow"]+failed["slow"] != 2*outputQueue {
+		t.Errorf("expected the slow output to drop messages, sent %d and failed %d", sent["slow"], failed["slow"])
+	}
+}
+
+func TestFanoutBatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		sent   int
+		failed int
+		dead   int
+	}{
+		{"sent", nil, 3, 0, 0},
+		{"failed", errors.New("unavailable"), 0, 3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dead.jsonl")
+			dead, err := openDeadLetter(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f := fanout{dead: dead, wait: true}
+			batch := newBatchSink(&msimpacttest.Sink{Err: tt.err}, 2, 0, time.Hour)
+			if err := f.Add("sqs", batch, "", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Holding("sqs", batch); err != nil {
+				t.Fatal(err)
+			}
+
+			// a full batch, and a partial batch only sent on closing
+			for i := 1; i <= 3; i++ {
+				if err := f.Send("NZ_WEL_20_HNZ", []byte(fmt.Sprintf(`{"MMI":%d}`, i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f.Close()
+			if err := dead.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := f.Sent()["sqs"]; n != tt.sent {
+				t.Errorf("expected %d messages sent, got %d", tt.sent, n)
+			}
+			if n := f.Failed()["sqs"]; n != tt.failed {
+				t.Errorf("expected %d messages failed, got %d", tt.failed, n)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := bytes.Count(data, []byte("\n")); n != tt.dead {
+				t.Errorf("expected %d dead letters, got %d", tt.dead, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the time format used in fdsn web service requests
+const fdsnTime = "2006-01-02T15:04:05.000000"
+
+// dataselectRequest builds an fdsn dataselect POST body for streams named in the form NN_SSS_LL_CCC.
+func dataselectRequest(streams []string, start, end time.Time) string {
+	var lines []string
+	for _, s := range streams {
+		parts := strings.Split(s, "_")
+		if len(parts) != 4 {
+			continue
+		}
+		loc := parts[2]
+		if loc == "" {
+			loc = "--"
+		}
+		lines = append(lines, strings.Join([]string{parts[0], parts[1], loc, parts[3], start.UTC().Format(fdsnTime), end.UTC().Format(fdsnTime)}, " "))
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// fetchDataselect requests miniseed for the given streams and time window from an fdsn dataselect query
+// endpoint, a nil reader is returned if there is no matching data.
+func fetchDataselect(service string, streams []string, start, end time.Time, timeout time.Duration) (io.ReadCloser, error) {
+	client := http.Client{Timeout: timeout}
+
+	resp, err := client.Post(service, "text/plain", strings.NewReader(dataselectRequest(streams, start, end)))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNoContent, http.StatusNotFound:
+		resp.Body.Close()
+		return nil, nil
+	default:
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("dataselect request failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
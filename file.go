@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileSink appends each message as a JSON line to a local file, optionally rotating it
+// once it grows past a size or at the start of each day, rotated files have the time they
+// were started added before their extension.
+type fileSink struct {
+	path     string
+	maxBytes int64
+	daily    bool
+
+	file    *os.File
+	size    int64
+	started time.Time
+
+	sync.Mutex
+}
+
+func newFileSink(path string, maxBytes int64, daily bool) (*fileSink, error) {
+	f := fileSink{
+		path:     path,
+		maxBytes: maxBytes,
+		daily:    daily,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (f *fileSink) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size, f.started = file, info.Size(), time.Now().UTC()
+	if info.Size() > 0 {
+		f.started = info.ModTime().UTC()
+	}
+
+	return nil
+}
+
+// rotated is the name a file started at a given time is moved to, with a counter
+// added if an earlier file was started in the same second.
+func (f *fileSink) rotated() string {
+	ext := filepath.Ext(f.path)
+	name := strings.TrimSuffix(f.path, ext) + "-" + f.started.Format("20060102T150405")
+	for n := 1; ; n++ {
+		if _, err := os.Stat(name + ext); os.IsNotExist(err) {
+			return name + ext
+		}
+		name = strings.TrimSuffix(f.path, ext) + "-" + f.started.Format("20060102T150405") + "-" + strconv.Itoa(n)
+	}
+}
+
+func (f *fileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.rotated()); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// due checks whether adding n bytes should first start a new file.
+func (f *fileSink) due(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxBytes > 0 && f.size+int64(n) > f.maxBytes {
+		return true
+	}
+	if f.daily && !f.started.Truncate(24*time.Hour).Equal(time.Now().UTC().Truncate(24*time.Hour)) {
+		return true
+	}
+	return false
+}
+
+func (f *fileSink) Send(key string, msg []byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	line := append(append([]byte{}, msg...), '\n')
+	if f.due(len(line)) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+
+	return err
+}
+
+func (f *fileSink) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.file.Close()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+)
+
+// message encodings available for each output
+const (
+	formatJSON     = "json"
+	formatProtobuf = "protobuf"
+	formatGeoJSON  = "geojson"
+	formatCAP      = "cap"
+)
+
+// encodeSink wraps an output sink to use the given message format, CAP alerts are described by alerts.
+func encodeSink(name, format string, s msimpact.Sink, alerts capOptions) (msimpact.Sink, error) {
+	switch format {
+	case "", formatJSON:
+		return s, nil
+	case formatGeoJSON:
+		return &geoJSONSink{Sink: s}, nil
+	case formatProtobuf, formatCAP:
+	default:
+		return nil, fmt.Errorf("unknown message format %q, expected %s, %s, %s or %s", format, formatJSON, formatProtobuf, formatGeoJSON, formatCAP)
+	}
+
+	for _, info := range sinkRegistry {
+		if info.Name != name {
+			continue
+		}
+		if !info.Protobuf {
+			return nil, fmt.Errorf("the %s output does not support %s messages", name, format)
+		}
+		if format == formatCAP {
+			return &capSink{Sink: s, opts: alerts}, nil
+		}
+		return &protobufSink{Sink: s, text: !info.Binary}, nil
+	}
+	return nil, fmt.Errorf("unknown output: %s", name)
+}
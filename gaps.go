@@ -0,0 +1,17 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ozym/msimpact/msimpact"
+	"time"
+)
+
+// gapMessage encodes a discontinuity for sending alongside the intensity messages.
+func gapMessage(d msimpact.Discontinuity) ([]byte, error) {
+	return json.Marshal(struct {
+		Type     string
+		Stream   string
+		Time     time.Time
+		Duration float64
+	}{d.Type(), d.Stream, d.Expected, d.Offset.Seconds()})
+}
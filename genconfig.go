@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEntry is the stream config written for each channel.
+type configEntry struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	Q         float64
+	Rate      float64
+	Gain      float64
+}
+
+// genconfig builds a stream config from an fdsn station web service.
+func genconfig(args []string) {
+	flags := flag.NewFlagSet("genconfig", flag.ExitOnError)
+
+	var service string
+	flags.StringVar(&service, "service", "https://service.geonet.org.nz/fdsnws/station/1/query", "fdsn station query url")
+	var network string
+	flags.StringVar(&network, "network", "NZ", "network selection")
+	var station string
+	flags.StringVar(&station, "station", "*", "station selection")
+	var location string
+	flags.StringVar(&location, "location", "*", "location selection")
+	var channel string
+	flags.StringVar(&channel, "channel", "HN?", "channel selection")
+	var current bool
+	flags.BoolVar(&current, "current", true, "only include currently operating channels")
+	var output string
+	flags.StringVar(&output, "output", "-", "config file to write, use - for stdout")
+	var timeout time.Duration
+	flags.DurationVar(&timeout, "timeout", 5*time.Minute, "fdsn request timeout")
+
+	flags.Parse(args)
+
+	query := url.Values{
+		"network":  {network},
+		"station":  {station},
+		"location": {location},
+		"channel":  {channel},
+		"format":   {"text"},
+	}
+	if current {
+		query.Set("endafter", time.Now().UTC().Format(fdsnTime))
+	}
+
+	client := http.Client{Timeout: timeout}
+
+	// station names are only given at the station level
+	query.Set("level", "station")
+	stations, err := fdsnText(&client, service, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names := make(map[string]string)
+	for _, s := range stations {
+		if len(s) > 5 {
+			names[s[0]+"_"+s[1]] = s[5]
+		}
+	}
+
+	query.Set("level", "channel")
+	channels, err := fdsnText(&client, service, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config := make(map[string]configEntry)
+	for _, c := range channels {
+		if len(c) < 15 {
+			continue
+		}
+		parse := func(s string) float64 {
+			v, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			return v
+		}
+		config[strings.Join(c[0:4], "_")] = configEntry{
+			Name:      names[c[0]+"_"+c[1]],
+			Latitude:  parse(c[4]),
+			Longitude: parse(c[5]),
+			Gain:      parse(c[11]),
+			Rate:      parse(c[14]),
+		}
+	}
+
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b = append(b, '\n')
+
+	if output == "-" {
+		if _, err := os.Stdout.Write(b); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := os.WriteFile(output, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fdsnText requests an fdsn text format response and splits it into fields.
+func fdsnText(client *http.Client, service string, query url.Values) ([][]string, error) {
+	resp, err := client.Get(service + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("station request failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var lines [][]string
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		lines = append(lines, fields)
+	}
+
+	return lines, scanner.Err()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ozym/msimpact/msimpact"
+)
+
+// geoJSONFeature is a message as a GeoJSON point feature, the remaining message fields are kept as properties.
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   *geoJSONPoint          `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// encodeGeoJSON converts a JSON message into a GeoJSON feature located at the station, messages
+// without a position, e.g. gap reports, are given a null geometry.
+func encodeGeoJSON(key string, msg []byte) ([]byte, error) {
+	var props map[string]interface{}
+	if err := json.Unmarshal(msg, &props); err != nil {
+		return nil, err
+	}
+
+	f := geoJSONFeature{
+		Type:       "Feature",
+		ID:         key,
+		Properties: props,
+	}
+
+	lat, okLat := props["Latitude"].(float64)
+	lon, okLon := props["Longitude"].(float64)
+	if okLat && okLon && (lat != 0.0 || lon != 0.0) {
+		f.Geometry = &geoJSONPoint{Type: "Point", Coordinates: []float64{lon, lat}}
+		if elev, ok := props["Elevation"].(float64); ok {
+			f.Geometry.Coordinates = append(f.Geometry.Coordinates, elev)
+		}
+	}
+	delete(props, "Latitude")
+	delete(props, "Longitude")
+	delete(props, "Elevation")
+
+	return json.Marshal(f)
+}
+
+// geoJSONSink re-encodes each message as a GeoJSON feature before passing it on.
+type geoJSONSink struct {
+	msimpact.Sink
+}
+
+func (g *geoJSONSink) Send(key string, msg []byte) error {
+	b, err := encodeGeoJSON(key, msg)
+	if err != nil {
+		return err
+	}
+	return g.Sink.Send(key, b)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"log/slog"
+	"net"
+	"path"
+	"sync"
+)
+
+// messages waiting for each grpc subscriber before it is considered too slow and dropped
+const grpcQueue = 256
+
+// protoFrame is an already encoded protocol buffer message, sent or received as is.
+type protoFrame []byte
+
+// protoCodec passes encoded messages straight through, standing in for the usual proto codec as the
+// messages are encoded directly, the wire format is the same so generated clients can be used.
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	switch f := v.(type) {
+	case protoFrame:
+		return f, nil
+	case *protoFrame:
+		return *f, nil
+	default:
+		return nil, fmt.Errorf("unable to marshal %T", v)
+	}
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*protoFrame)
+	if !ok {
+		return fmt.Errorf("unable to unmarshal into %T", v)
+	}
+	*f = append((*f)[:0], data...)
+	return nil
+}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+// subscription is a single Subscribe call, see SubscribeRequest in msimpact.proto.
+type subscription struct {
+	network, station string
+	minMMI           int32
+
+	queue chan protoFrame
+
+	// streams which have had a message pass the threshold, and so will need an all-clear
+	elevated map[string]bool
+}
+
+// decodeSubscription reads the fields of a SubscribeRequest.
+func decodeSubscription(b []byte) (*subscription, error) {
+	s := subscription{
+		queue:    make(chan protoFrame, grpcQueue),
+		elevated: make(map[string]bool),
+	}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			s.network, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			s.station, n = protowire.ConsumeString(b)
+		case num == 3 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			s.minMMI = int32(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	for _, p := range []string{s.network, s.station} {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", p, err)
+		}
+	}
+	return &s, nil
+}
+
+// accepts checks whether a message matches the subscription, all-clear messages only
+// follow earlier messages that passed the threshold.
+func (s *subscription) accepts(key string, mmi int32, kind string) bool {
+	network, station, _, _ := streamParts(key)
+	if ok, _ := path.Match(s.network, network); s.network != "" && !ok {
+		return false
+	}
+	if ok, _ := path.Match(s.station, station); s.station != "" && !ok {
+		return false
+	}
+
+	switch {
+	case s.minMMI <= 0:
+		return true
+	case kind == msimpact.AllClear:
+		ok := s.elevated[key]
+		delete(s.elevated, key)
+		return ok
+	case kind == "" && mmi >= s.minMMI:
+		s.elevated[key] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// grpcSink serves the msimpact.Impacts service, each Subscribe call is streamed the matching
+// messages as they are sent, subscribers that can't keep up are dropped.
+type grpcSink struct {
+	server        *grpc.Server
+	subscriptions map[*subscription]bool
+
+	sync.Mutex
+}
+
+func newGrpcSink(addr string) (*grpcSink, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	g := grpcSink{
+		server:        grpc.NewServer(grpc.ForceServerCodec(protoCodec{})),
+		subscriptions: make(map[*subscription]bool),
+	}
+	g.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "msimpact.Impacts",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Subscribe",
+			ServerStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				return g.subscribe(stream)
+			},
+		}},
+		Metadata: "msimpact.proto",
+	}, nil)
+
+	go func() {
+		if err := g.server.Serve(l); err != nil {
+			slog.Error("grpc server stopped", "addr", addr, "error", err)
+		}
+	}()
+
+	return &g, nil
+}
+
+// subscribe streams messages to a single subscriber until it goes away, is too slow, or the server stops.
+func (g *grpcSink) subscribe(stream grpc.ServerStream) error {
+	var req protoFrame
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	s, err := decodeSubscription(req)
+	if err != nil {
+		return grpcstatus.Errorf(codes.InvalidArgument, "invalid subscription: %s", err)
+	}
+
+	g.Lock()
+	g.subscriptions[s] = true
+	g.Unlock()
+	defer func() {
+		g.Lock()
+		delete(g.subscriptions, s)
+		g.Unlock()
+	}()
+	slog.Debug("grpc subscriber connected", "network", s.network, "station", s.station, "mmi", s.minMMI)
+
+	for {
+		select {
+		case msg, ok := <-s.queue:
+			if !ok {
+				return grpcstatus.Errorf(codes.Unavailable, "subscriber too slow, or server stopping")
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (g *grpcSink) Send(key string, msg []byte) error {
+	var m struct {
+		MMI  int32
+		Type string
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return err
+	}
+	b, err := encodeProtobuf(key, msg)
+	if err != nil {
+		return err
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	for s := range g.subscriptions {
+		if !s.accepts(key, m.MMI, m.Type) {
+			continue
+		}
+		select {
+		case s.queue <- protoFrame(b):
+		default:
+			slog.Warn("grpc subscriber too slow, disconnecting", "network", s.network, "station", s.station)
+			delete(g.subscriptions, s)
+			close(s.queue)
+		}
+	}
+	return nil
+}
+
+func (g *grpcSink) Close() error {
+	g.Lock()
+	for s := range g.subscriptions {
+		delete(g.subscriptions, s)
+		close(s.queue)
+	}
+	g.Unlock()
+
+	g.server.GracefulStop()
+	return nil
+}
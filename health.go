@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// health tracks the state of the inputs and outputs, and when a record was last processed,
+// for the liveness and readiness endpoints.
+type health struct {
+	started time.Time
+	last    time.Time
+
+	// inputs and outputs currently connected, or working, by name
+	inputs  map[string]bool
+	outputs map[string]bool
+
+	sync.Mutex
+}
+
+var status = newHealth()
+
+func newHealth() *health {
+	return &health{
+		started: time.Now(),
+		inputs:  make(map[string]bool),
+		outputs: make(map[string]bool),
+	}
+}
+
+// Processed notes a record has been read from an input, which must therefore be connected.
+func (h *health) Processed(input string) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.last = time.Now()
+	if input != "" {
+		h.inputs[input] = true
+	}
+}
+
+// Input notes whether an input is connected.
+func (h *health) Input(name string, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.inputs[name] = ok
+}
+
+// Output notes whether the last delivery to an output worked.
+func (h *health) Output(name string, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.outputs[name] = ok
+}
+
+// healthReport is the body of the health endpoints.
+type healthReport struct {
+	Status     string
+	LastRecord *time.Time `json:",omitempty"`
+	Age        string
+	Inputs     map[string]bool `json:",omitempty"`
+	Outputs    map[string]bool `json:",omitempty"`
+	Problems   []string        `json:",omitempty"`
+}
+
+// check builds a report, a live process has processed a record within the maximum age, zero for no limit,
+// a ready process also has all its inputs connected and outputs working.
+func (h *health) check(maxAge time.Duration, ready bool) (healthReport, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	since := h.started
+	r := healthReport{
+		Inputs:  make(map[string]bool),
+		Outputs: make(map[string]bool),
+	}
+	if !h.last.IsZero() {
+		last := h.last
+		since, r.LastRecord = last, &last
+	}
+	r.Age = time.Since(since).Truncate(time.Second).String()
+
+	if maxAge > 0 && time.Since(since) > maxAge {
+		r.Problems = append(r.Problems, "no records processed for "+r.Age)
+	}
+	for k, v := range h.inputs {
+		if r.Inputs[k] = v; !v && ready {
+			r.Problems = append(r.Problems, "input "+k+" is not connected")
+		}
+	}
+	for k, v := range h.outputs {
+		if r.Outputs[k] = v; !v && ready {
+			r.Problems = append(r.Problems, "output "+k+" is failing")
+		}
+	}
+	sort.Strings(r.Problems)
+
+	if r.Status = "ok"; len(r.Problems) > 0 {
+		r.Status = "failing"
+	}
+
+	return r, len(r.Problems) == 0
+}
+
+// handler serves either the liveness or readiness report as JSON, with a 503 status if failing.
+func (h *health) handler(maxAge time.Duration, ready bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ok := h.check(maxAge, ready)
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileInputs are the files given on the command line and how their records are read, followed files
+// are instead found as they appear.
+type fileInputs struct {
+	files []string
+
+	// a fixed record length, zero to detect the length of each record
+	size int
+
+	// only files modified after this time are read, and only the records overlapping the window
+	after  time.Time
+	window timeWindow
+}
+
+func newFileInputs(s *settings, args []string, started time.Time) (*fileInputs, error) {
+	var in fileInputs
+
+	if s.reclen != "auto" {
+		n, err := strconv.Atoi(s.reclen)
+		if err != nil || n < minReclen || n > maxReclen {
+			return nil, fmt.Errorf("invalid record length: %s", s.reclen)
+		}
+		in.size = n
+	}
+
+	if !s.follow {
+		files, err := expandInputs(args, s.sortOrder)
+		if err != nil {
+			return nil, err
+		}
+		in.files = files
+	}
+
+	switch {
+	case s.since != "":
+		t, err := parseSince(s.since, started)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode since time %s: %s", s.since, err)
+		}
+		in.after = t
+	case s.checkpoint != "":
+		t, err := readCheckpoint(s.checkpoint)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read checkpoint file %s: %s", s.checkpoint, err)
+		}
+		in.after = t
+	}
+
+	start, err := parseWindowTime(s.startTime)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode start time %q: %s", s.startTime, err)
+	}
+	end, err := parseWindowTime(s.endTime)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode end time %q: %s", s.endTime, err)
+	}
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return nil, fmt.Errorf("the end time %s must be after the start time %s", s.endTime, s.startTime)
+	}
+	in.window = timeWindow{start: start, end: end}
+
+	return &in, nil
+}
+
+// modified returns the files changed since any previous run, counting those skipped.
+func (in *fileInputs) modified(report *summary) ([]string, error) {
+	var files []string
+	for _, input := range in.files {
+		if !in.after.IsZero() && input != stdinName {
+			ok, err := modifiedSince(input, in.after)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				slog.Debug("skipping unmodified miniseed file", "file", input)
+				report.SkippedFiles++
+				continue
+			}
+		}
+		files = append(files, input)
+	}
+	return files, nil
+}
+
+// expandInputs turns the command line arguments into a list of files, directories are walked and
+// glob patterns, including "**" to match any depth, are expanded. The files found for each argument
+// are sorted either by "name", by the "time" of the first record, or not at all with "none".
+func expandInputs(args []string, order string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		files, err := expandInput(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 1 {
+			if err := sortInputs(files, order); err != nil {
+				return nil, err
+			}
+		}
+		inputs = append(inputs, files...)
+	}
+	return inputs, nil
+}
+
+func expandInput(arg string) ([]string, error) {
+	if arg == stdinName {
+		return []string{arg}, nil
+	}
+
+	if !strings.ContainsAny(arg, "*?[") {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return []string{arg}, nil
+		}
+		return walkInputs(arg, nil)
+	}
+
+	if !strings.Contains(arg, "**") {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, m := range matches {
+			f, err := expandInput(m)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f...)
+		}
+		return files, nil
+	}
+
+	// walk from the fixed part of the pattern
+	root := arg[:strings.Index(arg, "**")]
+	if i := strings.LastIndexAny(root, "*?["); i >= 0 {
+		root = root[:i]
+	}
+	root = filepath.Dir(root + "x")
+
+	re, err := globRegexp(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return walkInputs(root, re)
+}
+
+// walkInputs finds all regular files below a directory, optionally matching a pattern.
+func walkInputs(root string, re *regexp.Regexp) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if re != nil && !re.MatchString(filepath.ToSlash(path)) {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	return files, err
+}
+
+// globRegexp converts a glob pattern to a regular expression, "**" matches across directories, as with filepath.Match
+// a backslash escapes the following character.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case c == '[':
+			j := i + 1
+			for ; j < len(pattern) && pattern[j] != ']'; j++ {
+				if pattern[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(pattern) {
+				return nil, fmt.Errorf("invalid glob pattern: %s", pattern)
+			}
+			class := pattern[i+1 : j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i = j
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	return regexp.Compile(re.String())
+}
+
+func sortInputs(files []string, order string) error {
+	switch order {
+	case "none":
+	case "name":
+		sort.Strings(files)
+	case "time":
+		starts := make(map[string]time.Time)
+		for _, f := range files {
+			t, err := fileStart(f)
+			if err != nil {
+				return err
+			}
+			starts[f] = t
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			return starts[files[i]].Before(starts[files[j]])
+		})
+	default:
+		return fmt.Errorf("unknown input sort order: %s", order)
+	}
+	return nil
+}
+
+// fileStart finds the start time of the first record in a file.
+func fileStart(path string) (time.Time, error) {
+	msr := mseed.NewMSRecord()
+	defer mseed.FreeMSRecord(msr)
+
+	var start time.Time
+	err := readRecords(path, 0, msr, func(msr msimpact.Record) error {
+		start = msr.Starttime()
+		return errStop
+	})
+
+	return start, err
+}
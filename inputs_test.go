@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		reject  []string
+		err     bool
+	}{
+		{pattern: "*.mseed", match: []string{"a.mseed", ".mseed"}, reject: []string{"dir/a.mseed", "a.mseed.gz"}},
+		{pattern: "data/**/*.mseed", match: []string{"data/a.mseed", "data/2016/318/a.mseed"}, reject: []string{"data/a.txt", "other/a.mseed", "data2016/a.mseed"}},
+		{pattern: "data/**", match: []string{"data/a", "data/2016/318/a.mseed"}, reject: []string{"other/a"}},
+		{pattern: "data/**/?.mseed", match: []string{"data/a.mseed", "data/x/1.mseed"}, reject: []string{"data/ab.mseed", "data/.mseed", "data/x/a/.mseed"}},
+		{pattern: "**/[ab].mseed", match: []string{"d/a.mseed", "b.mseed"}, reject: []string{"d/c.mseed", "d/ab.mseed"}},
+		{pattern: "**/[a-c]x", match: []string{"d/bx"}, reject: []string{"d/dx"}},
+		{pattern: "**/[!ab].mseed", match: []string{"d/c.mseed"}, reject: []string{"d/a.mseed", "d/b.mseed"}},
+		{pattern: "**/[^ab].mseed", match: []string{"d/c.mseed"}, reject: []string{"d/a.mseed"}},
+		{pattern: "**/1.0+(x)|{2}$.mseed", match: []string{"d/1.0+(x)|{2}$.mseed"}, reject: []string{"d/1a0+(x)|{2}$.mseed", "d/100x2.mseed"}},
+		{pattern: "**/data^", match: []string{"d/data^"}, reject: []string{"d/data"}},
+		{pattern: `**/\*.mseed`, match: []string{"d/*.mseed"}, reject: []string{"d/a.mseed"}},
+		{pattern: `**/\[a\].mseed`, match: []string{"d/[a].mseed"}, reject: []string{"d/a.mseed"}},
+		{pattern: `**/[\]x].mseed`, match: []string{"d/].mseed", "d/x.mseed"}, reject: []string{"d/y.mseed"}},
+		{pattern: `**/a\?`, match: []string{"d/a?"}, reject: []string{"d/ab"}},
+		{pattern: "**/[ab", err: true},
+		{pattern: "**/[]", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re, err := globRegexp(tt.pattern)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", re)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.match {
+				if !re.MatchString(name) {
+					t.Errorf("expected %s to match %s (%s)", name, tt.pattern, re)
+				}
+			}
+			for _, name := range tt.reject {
+				if re.MatchString(name) {
+					t.Errorf("expected %s not to match %s (%s)", name, tt.pattern, re)
+				}
+			}
+		})
+	}
+}
+
+func TestNewFileInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"b.mseed", "a.mseed", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	started := time.Date(2016, time.November, 13, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		args  []string
+		files int
+		size  int
+		after time.Time
+		err   bool
+	}{
+		{name: "detected", args: []string{filepath.Join(dir, "*.mseed")}, files: 2},
+		{name: "fixed", args: []string{"-reclen", "4096", filepath.Join(dir, "*.mseed")}, files: 2, size: 4096},
+		{name: "directory", args: []string{dir}, files: 3},
+		{name: "followed", args: []string{"-follow", filepath.Join(dir, "missing", "*.mseed")}},
+		{name: "since", args: []string{"-since", "1h", dir}, files: 3, after: started.Add(-time.Hour)},
+		{name: "window", args: []string{"-starttime", "2016-11-13", "-endtime", "2016-11-14T00:00:00Z", dir}, files: 3},
+		{name: "small", args: []string{"-reclen", "64", dir}, err: true},
+		{name: "length", args: []string{"-reclen", "big", dir}, err: true},
+		{name: "missing", args: []string{filepath.Join(dir, "missing.mseed")}, err: true},
+		{name: "bad since", args: []string{"-since", "yesterday", dir}, err: true},
+		{name: "bad window", args: []string{"-starttime", "2016-11-14", "-endtime", "2016-11-13", dir}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s settings
+			fs := flag.NewFlagSet("msimpact", flag.ContinueOnError)
+			s.register(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			in, err := newFileInputs(&s, fs.Args(), started)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(in.files) != tt.files {
+				t.Errorf("expected %d files, got %v", tt.files, in.files)
+			}
+			if in.size != tt.size {
+				t.Errorf("expected record length %d, got %d", tt.size, in.size)
+			}
+			if !in.after.Equal(tt.after) {
+				t.Errorf("expected files after %s, got %s", tt.after, in.after)
+			}
+		})
+	}
+}
+
+func TestFileInputsModified(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	var files []string
+	for i, age := range []time.Duration{48 * time.Hour, time.Minute, 24 * time.Hour} {
+		f := filepath.Join(dir, string(rune('a'+i))+".mseed")
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+
+	var report summary
+	in := fileInputs{files: append(files, stdinName), after: now.Add(-time.Hour)}
+	modified, err := in.modified(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 2 || modified[0] != files[1] || modified[1] != stdinName {
+		t.Errorf("expected only the recent file and stdin, got %v", modified)
+	}
+	if report.SkippedFiles != 2 {
+		t.Errorf("expected 2 skipped files, got %d", report.SkippedFiles)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"strings"
+)
+
+// kafkaSink produces each message onto a kafka topic.
+type kafkaSink struct {
+	producer sarama.SyncProducer
+	topic    string
+	key      string
+}
+
+// newKafkaSink connects to a comma separated list of brokers, messages are keyed by "station"
+// (NN.SSS), "stream" (NN_SSS_LL_CCC), or "none", and acknowledged by "all", "local", or "none".
+func newKafkaSink(brokers, topic, key, acks string) (*kafkaSink, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "msimpact"
+	config.Producer.Return.Successes = true
+
+	switch acks {
+	case "all":
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	case "local":
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none":
+		config.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("unknown kafka acks setting: %s", acks)
+	}
+
+	switch key {
+	case "station", "stream", "none":
+	default:
+		return nil, fmt.Errorf("unknown kafka key setting: %s", key)
+	}
+
+	producer, err := sarama.NewSyncProducer(strings.Split(brokers, ","), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaSink{
+		producer: producer,
+		topic:    topic,
+		key:      key,
+	}, nil
+}
+
+func (k *kafkaSink) Send(key string, msg []byte) error {
+	m := sarama.ProducerMessage{
+		Topic: k.topic,
+		Value: sarama.ByteEncoder(msg),
+	}
+	switch k.key {
+	case "station":
+		m.Key = sarama.StringEncoder(stationKey(key))
+	case "stream":
+		m.Key = sarama.StringEncoder(key)
+	}
+	_, _, err := k.producer.SendMessage(&m)
+	return err
+}
+
+func (k *kafkaSink) Close() error {
+	return k.producer.Close()
+}
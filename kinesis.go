@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+)
+
+// kinesisSink puts each message onto a kinesis data stream, partitioned by the stream name,
+// the previous sequence number for each partition is used to keep the records in order.
+type kinesisSink struct {
+	ctx    context.Context
+	client *kinesis.Client
+	stream string
+
+	last map[string]string
+}
+
+func newKinesisSink(ctx context.Context, client *kinesis.Client, stream string) *kinesisSink {
+	return &kinesisSink{
+		ctx:    ctx,
+		client: client,
+		stream: stream,
+		last:   make(map[string]string),
+	}
+}
+
+func (k *kinesisSink) Send(key string, msg []byte) error {
+	input := kinesis.PutRecordInput{
+		StreamName:   aws.String(k.stream),
+		PartitionKey: aws.String(key),
+		Data:         msg,
+	}
+	if last, ok := k.last[key]; ok {
+		input.SequenceNumberForOrdering = aws.String(last)
+	}
+	resp, err := k.client.PutRecord(k.ctx, &input)
+	if err != nil {
+		return err
+	}
+	k.last[key] = aws.ToString(resp.SequenceNumber)
+	return nil
+}
+
+func (k *kinesisSink) Close() error {
+	return nil
+}
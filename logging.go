@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// levelWriter passes lines written by the standard logger, i.e. fatal startup errors, on to a structured logger.
+type levelWriter struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+func (w levelWriter) Write(b []byte) (int, error) {
+	w.logger.Log(context.Background(), w.level, strings.TrimSpace(string(b)))
+	return len(b), nil
+}
+
+// setupLogging replaces the default logger with a structured logger, either "console" or "json" formatted,
+// writing only messages at or above the given level of debug, info, warn or error.
+func setupLogging(w io.Writer, format, level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+
+	opts := slog.HandlerOptions{Level: l}
+
+	var h slog.Handler
+	switch format {
+	case "console":
+		h = slog.NewTextHandler(w, &opts)
+	case "json":
+		h = slog.NewJSONHandler(w, &opts)
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+
+	slog.SetDefault(slog.New(h))
+
+	log.SetFlags(0)
+	log.SetOutput(levelWriter{logger: slog.Default(), level: slog.LevelError})
+
+	return nil
+}
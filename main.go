@@ -1,186 +1,448 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/crowdmob/goamz/aws"
-	"github.com/crowdmob/goamz/sqs"
-	"github.com/ozym/impact"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/ozym/mseed"
-	"io"
+	"github.com/ozym/msimpact/msimpact"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// exit codes used when the maximum runtime has been reached, or the run was interrupted
+const (
+	exitTimedOut    = 3
+	exitInterrupted = 4
+)
+
 func main() {
-	var Q *sqs.Queue
-
-	// runtime settings
-	var verbose bool
-	flag.BoolVar(&verbose, "verbose", false, "make noise")
-	var dryrun bool
-	flag.BoolVar(&dryrun, "dry-run", false, "don't actually send the messages")
-	var replay bool
-	flag.BoolVar(&replay, "replay", false, "send current time rather than recorded time")
-
-	// streaming channel information
-	var config string
-	flag.StringVar(&config, "config", "impact.json", "provide a streams config file")
-
-	// amazon queue details
-	var region string
-	flag.StringVar(&region, "region", "", "provide AWS region")
-	var queue string
-	flag.StringVar(&queue, "queue", "", "send messages to the SQS queue")
-	var key string
-	flag.StringVar(&key, "key", "", "AWS access key id, overrides env and credentials file (default profile)")
-	var secret string
-	flag.StringVar(&secret, "secret", "", "AWS secret key id, overrides env and credentials file (default profile)")
-
-	// noisy channel detection
-	var probation time.Duration
-	flag.DurationVar(&probation, "probation", 10.0*time.Minute, "noise probation window")
-	var level int
-	flag.IntVar(&level, "level", 2, "noise threshold level")
-
-	flag.Parse()
-	if region == "" {
-		region = os.Getenv("AWS_IMPACT_REGION")
-		if region == "" {
-			log.Fatalf("unable to find region in environment or command line [AWS_IMPACT_REGION]")
-		}
-	}
-
-	if queue == "" {
-		queue = os.Getenv("AWS_IMPACT_QUEUE")
-		if queue == "" {
-			log.Fatalf("unable to find queue in environment or command line [AWS_IMPACT_QUEUE]")
+	// build a config rather than process data
+	if len(os.Args) > 1 && os.Args[1] == "genconfig" {
+		genconfig(os.Args[2:])
+		return
+	}
+
+	var s settings
+	s.register(flag.CommandLine)
+
+	// each command only accepts the flags that make sense for it, the flags above are shared between them
+	cmd, flags := parseCommand(flag.CommandLine, os.Args[1:])
+	checkConfig := cmd.Name == "check-config"
+
+	// the dry run summary is only for -dry-run itself, not the commands and modes that imply it
+	dryRunOnly := s.dryrun && !s.benchmarking
+	switch {
+	case checkConfig, s.benchmarking:
+		s.dryrun = true
+	case cmd.Name == "replay":
+		if !isFlagSet(flags, "replay") && s.replayShift == "" {
+			s.replay = true
+		}
+	case cmd.Name == "serve":
+		if s.seedlink == "" && s.datalink == "" && !s.follow {
+			log.Fatalf("the serve command needs a real-time input, e.g. -seedlink, -datalink or -follow")
 		}
 	}
 
-	// configure amazon ...
-	if !dryrun {
-		R := aws.GetRegion(region)
-		// fall through to env then credentials file
-		A, err := aws.GetAuth(key, secret, "", time.Now().Add(30*time.Minute))
-		if err != nil {
+	// ordered messages are only sent at the end of the run, which a real-time input never reaches
+	if s.ordered && (s.seedlink != "" || s.datalink != "" || s.follow) {
+		log.Fatalf("-ordered can only be used with files, not with -seedlink, -datalink or -follow")
+	}
+
+	if s.verbose && s.logLevel == "info" {
+		s.logLevel = "debug"
+	}
+	if err := setupLogging(os.Stderr, s.logFormat, s.logLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	if s.showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	if s.selfTest {
+		if err := selftest(os.Stdout); err != nil {
 			log.Fatal(err)
 		}
+		return
+	}
 
-		S := sqs.New(A, R)
-		Q, err = S.GetQueue(queue)
-		if err != nil {
+	if s.showSinks {
+		if err := listSinks(os.Stdout, flag.CommandLine); err != nil {
 			log.Fatal(err)
 		}
+		return
 	}
 
-	// load stream configuration
-	state := impact.LoadStreams(config)
+	// when this run started, for checkpointing
+	started := time.Now()
+
+	// the files to read, with fixed or detected record lengths
+	in, err := newFileInputs(&s, flags.Args(), started)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// initial stream setup
-	for s := range state {
-		_, err := state[s].Init(s, probation, (int32)(level))
+	// just show what is in the files
+	if s.dumpHeaders {
+		dumper, err := newHeaderDumper(os.Stdout, s.dumpFormat)
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		msr := mseed.NewMSRecord()
+		defer mseed.FreeMSRecord(msr)
+
+		for _, f := range in.files {
+			if err := readRecords(f, in.size, msr, dumper.Dump); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := dumper.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// make space for miniseed blocks
-	msr := mseed.NewMSRecord()
-	defer mseed.FreeMSRecord(msr)
+	undecodable.limit = int64(s.maxErrors)
+
+	if s.httpAddr != "" {
+		serveHTTP(s.httpAddr, monitor(s.healthAge))
+	}
+	if s.debugAddr != "" {
+		serveDebug(s.debugAddr)
+	}
+	if s.statsdAddr != "" {
+		c, err := dialStatsd(s.statsdAddr, s.statsdPrefix, s.statsdTags)
+		if err != nil {
+			log.Fatalf("unable to connect to statsd agent %s: %s", s.statsdAddr, err)
+		}
+		defer c.Close()
+		stats = c
+	}
 
-	// fixup stream code for messaging
-	replace := strings.NewReplacer("_", ".")
+	// overall run results
+	report := summary{Started: started}
 
-	// output channel
-	result := make(chan impact.Message)
-	go func() {
-		for m := range result {
-			mm, err := json.Marshal(m)
-			if err != nil {
-				log.Panic(err)
-			}
-			if verbose {
-				fmt.Println(string(mm))
-			}
-			if !dryrun {
-				_, err := Q.SendMessage(string(mm))
-				if err != nil {
-					log.Panic(err)
-				}
-			}
+	// replayed messages can be moved in time rather than given the current time
+	shift, err := parseTimeShift(s.replayShift)
+	if err != nil {
+		log.Fatalf("unable to decode replay shift %q: %s", s.replayShift, err)
+	}
+	if shift != nil && s.replay {
+		log.Fatalf("only one of -replay and -replay-shift can be given")
+	}
+
+	// a queue is only needed if there is nowhere else to send messages
+	elsewhere := s.unixSocket != "" || s.topic != "" || s.kinesisStream != "" || s.kafkaBrokers != "" || s.natsURL != "" || s.mqttBroker != "" || s.webhookURL != "" || s.outFile != "" || s.serveWS != "" || s.serveGRPC != ""
+	if s.queue == "" {
+		s.queue = os.Getenv("AWS_IMPACT_QUEUE")
+		if s.queue == "" && !checkConfig && !s.benchmarking && (!elsewhere || s.roundtripTest) {
+			log.Fatalf("unable to find queue in environment or command line [AWS_IMPACT_QUEUE]")
 		}
-	}()
+	}
 
-	missing := make(map[string]string)
+	if s.region == "" {
+		s.region = os.Getenv("AWS_IMPACT_REGION")
+	}
 
-	blk := make([]byte, 512)
-	for i := range flag.Args() {
-		if verbose {
-			fmt.Printf("processing miniseed file: \"%s\"\n", flag.Args()[i])
+	// a queue url already knows its region
+	if r, ok := queueRegion(s.queue); ok {
+		if s.region != "" && s.region != r {
+			slog.Warn("region does not match queue url", "region", s.region, "queue", s.queue, "using", r)
 		}
+		s.region = r
+	}
 
-		file, err := os.Open(flag.Args()[i])
+	// cancelled once all amazon requests should stop
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// amazon client settings, only loaded if needed
+	var cfg aws.Config
+	if s.queue != "" || s.topic != "" || s.kinesisStream != "" || strings.HasPrefix(s.config, "s3://") {
+		c, err := loadAWS(ctx, s.region, s.key, s.secret)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if cfg = c; s.roleARN != "" {
+			cfg = assumeRole(c, s.roleARN, s.externalID)
+		}
+	}
 
-		in := bufio.NewReader(file)
-		for {
-			n, err := in.Read(blk)
-			if err != nil && err != io.EOF {
-				panic(err)
-			}
-			if n == 0 {
-				break
-			}
+	if (s.queue != "" || s.kinesisStream != "") && cfg.Region == "" {
+		log.Fatalf("unable to find region in environment or command line [AWS_IMPACT_REGION]")
+	}
+
+	// check the queue actually delivers messages
+	if s.roundtripTest {
+		client, queue, err := openQueue(ctx, &s, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		latency, err := roundtrip(ctx, client, queue, s.roundtripTimeout)
+		if err != nil {
+			log.Fatalf("roundtrip test failed: %s", err)
+		}
+		fmt.Printf("roundtrip test message received after %s\n", latency)
+		return
+	}
 
-			// decode mseed block
-			msr.Unpack(blk, n, 1, 0)
+	// where to keep undeliverable messages
+	var dead *deadLetter
+	if s.deadLetterFile != "" {
+		d, err := openDeadLetter(s.deadLetterFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dead = d
+	}
+
+	// messages may need to be signed
+	var signer *messageSigner
+	if s.signKeyFile != "" {
+		k, err := readSigningKey(s.signKeyFile)
+		if err != nil {
+			log.Fatalf("unable to read signing key: %s", err)
+		}
+		signer = k
+	}
 
-			// what to send
-			source := strings.TrimRight(msr.Network()+"."+msr.Station(), "\u0000")
+	// each output is delivered to independently
+	sinks, err := newOutputs(ctx, &s, cfg, dead, signer)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			// block lookup key
-			srcname := msr.SrcName(0)
-			// have we rejected this before?
-			if _, ok := missing[srcname]; ok {
-				continue
+	// send the messages in dead letter files again, rather than processing any data
+	if cmd.Name == "resend" {
+		for _, f := range flags.Args() {
+			if f == s.deadLetterFile {
+				log.Fatalf("unable to resend from the -dead-letter file itself: %s", f)
 			}
-			stream, ok := state[srcname]
-			if ok == false {
-				log.Printf("unable to find stream config! %s\n", srcname)
-				missing[srcname] = srcname
-				continue
+		}
+		report.Failed = resend(sinks, flags.Args(), &report)
+		report.Sent = sinks.Sent()
+		if err := dead.Close(); err != nil {
+			slog.Error("unable to close dead letter file", "error", err)
+		}
+		report.Finished = time.Now()
+		if s.verbose {
+			report.Print(os.Stderr)
+		}
+		if s.summaryJSON != "" {
+			if err := report.WriteJSON(s.summaryJSON); err != nil {
+				log.Fatal(err)
 			}
+		}
+		return
+	}
 
-			// recover amplitude samples
-			samples, err := msr.DataSamples()
-			if err != nil {
-				log.Printf("data sample problem! %s\n", err)
-				continue
+	// where to find the stream configuration
+	var store *s3.Client
+	if strings.HasPrefix(s.config, "s3://") {
+		if s.configRegion == "" {
+			if s.configRegion = cfg.Region; s.configRegion == "" {
+				s.configRegion = "us-east-1"
 			}
+		}
+		store = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.Region = s.configRegion
+		})
+	}
+	source := newConfigSource(s.config, store)
 
-			// process each block into a message
-			message, err := stream.ProcessSamples(replace.Replace(source), srcname, msr.Starttime(), samples)
-			if err != nil {
-				log.Printf("data processing problem! %s\n", err)
-				continue
-			}
+	// load stream configuration
+	raw, err := source.Fetch(true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	set, err := parseConfig(source.Name(), raw)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			// should we send a message .. but only on a change in MMI (no heartbeats)
-			if stream.Flush(0, message.MMI) {
-				if replay {
-					message.Time = time.Now().Truncate(time.Second)
+	// size checks and ordering before delivery
+	output := outputSink{
+		next:    sinks,
+		report:  &report,
+		dead:    dead,
+		maxSize: s.maxSize,
+		limit:   newRateLimiter(s.rateLimit, float64(s.rateBurst), s.streamRateLimit, float64(s.streamRateBurst)),
+		verbose: s.verbose,
+	}
+	if s.ordered {
+		output.buffer = newOrderBuffer(s.orderedMemory, s.orderedDir)
+	}
+
+	// optionally only the largest intensity of each station in each window is delivered
+	var delivery msimpact.Sink = &output
+	if s.maxWindow > 0 {
+		delivery = newMaxSink(&output, s.maxWindow)
+	}
+
+	// time each stage, and watch the memory used, when benchmarking
+	benchDone := make(chan struct{})
+	if s.benchmarking {
+		bench = newBenchmark()
+	}
+
+	// the stream processor and the pipeline delivering its messages
+	r, err := newRun(&s, &report, source, raw, set, &output, delivery, shift)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// only checking the config
+	if checkConfig {
+		fmt.Printf("%s: %d streams configured\n", source.Name(), len(r.processor.Streams()))
+		return
+	}
+
+	// what each stream is currently doing
+	if s.apiAddr != "" {
+		serveHTTP(s.apiAddr, streamAPI(r.processor, &report.Streams))
+	}
+
+	// carry on from where any previous run left off
+	if s.stateFile != "" {
+		state, err := readState(s.stateFile, s.stateAge)
+		if err != nil {
+			log.Fatalf("unable to read state file %s: %s", s.stateFile, err)
+		}
+		if err := r.processor.Restore(state); err != nil {
+			log.Fatalf("unable to restore stream state: %s", err)
+		}
+		slog.Debug("restored stream state", "file", s.stateFile, "streams", len(state))
+
+		if s.stateInterval > 0 {
+			go func() {
+				for range time.Tick(s.stateInterval) {
+					if err := writeState(s.stateFile, r.processor.State()); err != nil {
+						slog.Error("unable to save stream state", "file", s.stateFile, "error", err)
+					}
 				}
-				result <- message
-			}
+			}()
+		}
+	}
+
+	// the queue, outputs and config are ready, tell systemd if it is watching
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("unable to notify systemd", "error", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		slog.Debug("pinging systemd watchdog", "interval", interval)
+		go runWatchdog(interval, func() bool {
+			_, ok := status.check(s.healthAge, false)
+			return ok
+		})
+	}
 
+	// make space for miniseed blocks
+	msr := mseed.NewMSRecord()
+	defer mseed.FreeMSRecord(msr)
+
+	if bench != nil {
+		go bench.Run(100*time.Millisecond, benchDone)
+	}
+
+	// reload the configuration on request
+	signal.Notify(r.hangup, syscall.SIGHUP)
+
+	// stop processing input once the runtime limit is reached, or on an interrupt
+	if s.maxRuntime > 0 {
+		time.AfterFunc(s.maxRuntime, func() { r.halt("runtime") })
+	}
+
+	// a second interrupt gives up waiting for the outputs
+	interrupt := make(chan os.Signal, 2)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupt
+		slog.Info("interrupted, stopping input and sending outstanding messages", "signal", sig.String())
+		r.halt("interrupt")
+		<-interrupt
+		slog.Error("interrupted again, exiting without sending outstanding messages")
+		os.Exit(exitInterrupted)
+	}()
+
+	// only process files changed since any previous run
+	files, err := in.modified(&report)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := r.read(in, files, flags.Args(), msr); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := sdNotify("STOPPING=1"); err != nil {
+		slog.Warn("unable to notify systemd", "error", err)
+	}
+
+	// wait for any outstanding messages, an interrupted run only waits so long before abandoning them
+	closed := make(chan error, 1)
+	go func() { closed <- delivery.Close() }()
+	var abandon <-chan time.Time
+	if report.Interrupted && s.shutdownTimeout > 0 {
+		abandon = time.After(s.shutdownTimeout)
+	}
+	select {
+	case err := <-closed:
+		if err != nil {
+			slog.Error("unable to close outputs", "error", err)
+		}
+	case <-abandon:
+		slog.Error("outputs did not finish in time, abandoning outstanding messages", "timeout", s.shutdownTimeout)
+		cancel()
+	}
+	report.Sent, report.Failed = sinks.Sent(), sinks.Failed()
+	report.CorruptBytes, report.Undecodable = corruptBytes.Load(), undecodable.count.Load()
+
+	if s.stateFile != "" {
+		if err := writeState(s.stateFile, r.processor.State()); err != nil {
+			slog.Error("unable to save stream state", "file", s.stateFile, "error", err)
+		}
+	}
+	if err := dead.Close(); err != nil {
+		slog.Error("unable to close dead letter file", "error", err)
+	}
+
+	// not all input was processed, so this run should not be a checkpoint
+	if s.checkpoint != "" && !report.TimedOut && !report.Interrupted {
+		if err := writeCheckpoint(s.checkpoint, started); err != nil {
+			log.Fatal(err)
 		}
+	}
+
+	close(benchDone)
+	report.Benchmark = bench.Result()
+
+	report.Finished = time.Now()
+	if report.DryRun = dryRunOnly && !checkConfig; s.verbose || report.DryRun {
+		report.Print(os.Stderr)
+	}
+	if report.Benchmark != nil {
+		report.Benchmark.Print(os.Stderr)
+	}
+	if s.summaryJSON != "" {
+		if err := report.WriteJSON(s.summaryJSON); err != nil {
+			log.Fatal(err)
+		}
+	}
 
+	switch {
+	case report.Interrupted:
+		os.Exit(exitInterrupted)
+	case report.TimedOut:
+		os.Exit(exitTimedOut)
 	}
 }
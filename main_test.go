@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeQueue is an in memory stand in for an SQS queue, each send returns the next of any Fail errors, or Err if
+// set, otherwise the message is kept, so the sqs output can be exercised without AWS.
+type fakeQueue struct {
+	Err  error
+	Fail []error
+
+	Calls int
+	Sent  []sqs.SendMessageInput
+
+	sync.Mutex
+}
+
+var _ sqsSender = (*fakeQueue)(nil)
+
+func (q *fakeQueue) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.Calls++
+	if len(q.Fail) > 0 {
+		err := q.Fail[0]
+		q.Fail = q.Fail[1:]
+		return nil, err
+	}
+	if q.Err != nil {
+		return nil, q.Err
+	}
+	q.Sent = append(q.Sent, *params)
+	return &sqs.SendMessageOutput{MessageId: aws.String(fmt.Sprintf("fake-%d", len(q.Sent)))}, nil
+}
+
+// fakeCredentials counts how often any cached credentials are discarded.
+type fakeCredentials struct {
+	invalidated int
+}
+
+func (c *fakeCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return aws.Credentials{AccessKeyID: "fake", SecretAccessKey: "fake"}, nil
+}
+
+func (c *fakeCredentials) Invalidate() {
+	c.invalidated++
+}
+
+func TestSQSRetry(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "Throttling", Fault: smithy.FaultClient}
+	expired := &smithy.GenericAPIError{Code: "ExpiredToken", Fault: smithy.FaultClient}
+	invalid := &smithy.GenericAPIError{Code: "InvalidParameterValue", Fault: smithy.FaultClient}
+	unavailable := &smithy.GenericAPIError{Code: "ServiceUnavailable", Fault: smithy.FaultServer}
+
+	tests := []struct {
+		name        string
+		fail        []error
+		always      error
+		attempts    int
+		err         string
+		calls       int
+		sent        int
+		invalidated int
+	}{
+		{"sent", nil, nil, 3, "", 1, 1, 0},
+		{"throttled", []error{throttled, throttled}, nil, 3, "", 3, 1, 0},
+		{"server fault", []error{unavailable}, nil, 3, "", 2, 1, 0},
+		{"expired credentials", []error{expired}, nil, 3, "", 2, 1, 1},
+		{"permanent", []error{invalid}, nil, 3, "InvalidParameterValue", 1, 0, 0},
+		{"cancelled", nil, context.Canceled, 3, "context canceled", 1, 0, 0},
+		{"giving up", nil, throttled, 3, "giving up after 3 attempts", 3, 0, 0},
+		{"always expired", nil, expired, 2, "giving up after 2 attempts", 2, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, credentials := &fakeQueue{Fail: tt.fail, Err: tt.always}, &fakeCredentials{}
+			sink := newRetrySink(newRefreshSink(&sqsSink{ctx: context.Background(), client: queue, queue: "https://sqs.ap-southeast-2.amazonaws.com/123456789012/test"}, credentials), tt.attempts, 0, time.Millisecond)
+
+			err := sink.Send("NZ_WEL_20_HNZ", []byte(`{"MMI":3}`))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+			if queue.Calls != tt.calls {
+				t.Errorf("expected %d calls, got %d", tt.calls, queue.Calls)
+			}
+			if len(queue.Sent) != tt.sent {
+				t.Errorf("expected %d messages sent, got %d", tt.sent, len(queue.Sent))
+			}
+			if credentials.invalidated != tt.invalidated {
+				t.Errorf("expected credentials invalidated %d times, got %d", tt.invalidated, credentials.invalidated)
+			}
+		})
+	}
+}
+
+func TestSQSFifo(t *testing.T) {
+	tests := []struct {
+		name  string
+		queue string
+		dedup string
+		group string
+		id    string
+	}{
+		{"standard", "https://sqs.ap-southeast-2.amazonaws.com/123456789012/test", "", "", ""},
+		{"fifo content", "https://sqs.ap-southeast-2.amazonaws.com/123456789012/test.fifo", "content", "NZ.WEL", deduplicationID("content", "NZ_WEL_20_HNZ", []byte(`{"MMI":3,"Time":"2016-11-13T11:02:56Z"}`))},
+		{"fifo time", "https://sqs.ap-southeast-2.amazonaws.com/123456789012/test.fifo", "time", "NZ.WEL", "NZ_WEL_20_HNZ-1479034976000000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &fakeQueue{}
+			sink := &sqsSink{ctx: context.Background(), client: queue, queue: tt.queue, fifo: isFifoQueue(tt.queue), dedup: tt.dedup}
+			if err := sink.Send("NZ_WEL_20_HNZ", []byte(`{"MMI":3,"Time":"2016-11-13T11:02:56Z"}`)); err != nil {
+				t.Fatal(err)
+			}
+			if len(queue.Sent) != 1 {
+				t.Fatalf("expected a single message, got %d", len(queue.Sent))
+			}
+			m := queue.Sent[0]
+			if aws.ToString(m.QueueUrl) != tt.queue {
+				t.Errorf("expected queue %s, got %s", tt.queue, aws.ToString(m.QueueUrl))
+			}
+			if g := aws.ToString(m.MessageGroupId); g != tt.group {
+				t.Errorf("expected group %q, got %q", tt.group, g)
+			}
+			if id := aws.ToString(m.MessageDeduplicationId); id != tt.id {
+				t.Errorf("expected deduplication id %q, got %q", tt.id, id)
+			}
+		})
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection reset"), false},
+		{context.Canceled, true},
+		{&smithy.GenericAPIError{Code: "Throttling", Fault: smithy.FaultClient}, false},
+		{&smithy.GenericAPIError{Code: "ExpiredToken", Fault: smithy.FaultClient}, false},
+		{&smithy.GenericAPIError{Code: "AccessDenied", Fault: smithy.FaultClient}, true},
+		{&smithy.GenericAPIError{Code: "InternalError", Fault: smithy.FaultServer}, false},
+	}
+
+	for _, tt := range tests {
+		if got := isPermanent(tt.err); got != tt.want {
+			t.Errorf("isPermanent(%v): expected %v, got %v", tt.err, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/ozym/msimpact/msimpact"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// prometheus metrics, served on /metrics
+var (
+	metricRecords = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "msimpact_records_total",
+		Help: "Number of miniseed records read.",
+	})
+	metricSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "msimpact_records_skipped_total",
+		Help: "Number of records not processed, by reason (missing config, duplicate, window, rejected, undecodable or error).",
+	}, []string{"reason"})
+	metricCorrupt = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "msimpact_corrupt_bytes_total",
+		Help: "Number of bytes of corrupt, or truncated, records skipped while reading.",
+	})
+	metricDiscontinuities = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "msimpact_discontinuities_total",
+		Help: "Number of gaps or overlaps found between records, by type.",
+	}, []string{"type"})
+	metricMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "msimpact_messages_total",
+		Help: "Number of messages generated.",
+	})
+	metricRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "msimpact_messages_rate_limited_total",
+		Help: "Number of messages dropped by a rate limit, by limit (global or stream).",
+	}, []string{"limit"})
+	metricDeadLetters = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "msimpact_dead_letters_total",
+		Help: "Number of messages written to the dead letter file.",
+	})
+	metricSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "msimpact_messages_sent_total",
+		Help: "Number of messages delivered, by output.",
+	}, []string{"output"})
+	metricFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "msimpact_messages_failed_total",
+		Help: "Number of messages that could not be delivered, by output.",
+	}, []string{"output"})
+	metricLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "msimpact_send_duration_seconds",
+		Help:    "Time taken to deliver each message, including any retries, by output.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"output"})
+	metricStreamLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "msimpact_stream_latency_seconds",
+		Help: "Seconds between the end of the latest record of each stream and when it was read.",
+	}, []string{"stream"})
+	metricFailover = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "msimpact_sqs_active",
+		Help: "Whether each queue, primary or secondary, is being sent to.",
+	}, []string{"queue"})
+	metricFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "msimpact_sqs_failovers_total",
+		Help: "Number of switches between queues, by the queue switched to (primary or secondary).",
+	}, []string{"queue"})
+	metricProgressFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "msimpact_progress_files",
+		Help: "Number of input files, by state (total or done).",
+	}, []string{"state"})
+	metricProgressBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "msimpact_progress_bytes",
+		Help: "Bytes of input files, by state (total or done).",
+	}, []string{"state"})
+
+	lastRecords = newRecordAges()
+)
+
+func init() {
+	prometheus.MustRegister(metricRecords, metricSkipped, metricCorrupt, metricDiscontinuities, metricMessages, metricRateLimited, metricDeadLetters, metricSent, metricFailed, metricLatency, metricStreamLatency, metricFailover, metricFailovers, metricProgressFiles, metricProgressBytes, lastRecords)
+}
+
+// recordLatency is how long ago a record ended.
+func recordLatency(msr msimpact.Record, now time.Time) time.Duration {
+	end := msr.Starttime()
+	if rate := msr.Samprate(); rate > 0.0 {
+		end = end.Add(time.Duration(float64(msr.Samplecnt()) / rate * float64(time.Second)))
+	}
+	return now.Sub(end)
+}
+
+// recordAges reports how long it has been since a record was last seen for each stream.
+type recordAges struct {
+	desc *prometheus.Desc
+	seen map[string]time.Time
+	sync.Mutex
+}
+
+func newRecordAges() *recordAges {
+	return &recordAges{
+		desc: prometheus.NewDesc("msimpact_stream_last_record_age_seconds", "Seconds since a record was last read for each stream.", []string{"stream"}, nil),
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Seen notes a record has been read for a stream.
+func (r *recordAges) Seen(stream string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.seen[stream] = time.Now()
+}
+
+func (r *recordAges) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.desc
+}
+
+func (r *recordAges) Collect(ch chan<- prometheus.Metric) {
+	r.Lock()
+	defer r.Unlock()
+
+	for s, t := range r.seen {
+		ch <- prometheus.MustNewConstMetric(r.desc, prometheus.GaugeValue, time.Since(t).Seconds(), s)
+	}
+}
+
+// serveHTTP runs the monitoring endpoints in the background.
+func serveHTTP(addr string, mux *http.ServeMux) {
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("unable to serve http on %s: %s", addr, err)
+		}
+	}()
+}
+
+// monitor builds the handlers for the monitoring endpoints, maxAge is how long without processing
+// a record before the process is no longer considered healthy.
+func monitor(maxAge time.Duration) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", status.handler(maxAge, false))
+	mux.Handle("/readyz", status.handler(maxAge, true))
+	return mux
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// mapFile reads the whole of a regular file into memory, where it can't be mapped.
+func mapFile(file *os.File) ([]byte, func() error, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a regular file", file.Name())
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"os"
+	"time"
+)
+
+// how long to wait for a broker to respond
+const mqttTimeout = 30 * time.Second
+
+// mqttSink publishes each message to an mqtt broker.
+type mqttSink struct {
+	client mqtt.Client
+	topic  string
+	qos    byte
+}
+
+// mqttTLS builds a tls configuration from an optional ca certificate and client key pair.
+func mqttTLS(ca, cert, key string) (*tls.Config, error) {
+	config := tls.Config{}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ca)
+		}
+		config.RootCAs = pool
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return &config, nil
+}
+
+// newMqttSink connects to a broker, the topic may be templated with stream codes,
+// e.g. "impact/{network}/{station}".
+func newMqttSink(broker, topic string, qos int, config *tls.Config) (*mqttSink, error) {
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos: %d", qos)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("msimpact-%d", time.Now().UnixNano())).
+		SetConnectTimeout(mqttTimeout).
+		SetAutoReconnect(true)
+	if config != nil {
+		opts = opts.SetTLSConfig(config)
+	}
+
+	client := mqtt.NewClient(opts)
+	if err := mqttWait(client.Connect()); err != nil {
+		return nil, err
+	}
+
+	return &mqttSink{
+		client: client,
+		topic:  topic,
+		qos:    byte(qos),
+	}, nil
+}
+
+// mqttWait blocks until a broker request completes or times out.
+func mqttWait(t mqtt.Token) error {
+	if !t.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("timed out waiting for mqtt broker")
+	}
+	return t.Error()
+}
+
+func (m *mqttSink) Send(key string, msg []byte) error {
+	return mqttWait(m.client.Publish(expandKey(m.topic, key), m.qos, false, msg))
+}
+
+func (m *mqttSink) Close() error {
+	m.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// miniseed 3 fixed header length, all header values are little endian
+const ms3Header = 40
+
+// miniseed 3 data encodings
+const (
+	ms3Int16   = 1
+	ms3Int32   = 3
+	ms3Float32 = 4
+	ms3Float64 = 5
+	ms3Steim1  = 10
+	ms3Steim2  = 11
+)
+
+// isMS3 checks whether a header belongs to a miniseed 3 record.
+func isMS3(hdr []byte) bool {
+	return len(hdr) > 2 && hdr[0] == 'M' && hdr[1] == 'S' && hdr[2] == 3
+}
+
+// ms3Length returns the full record length given its fixed header.
+func ms3Length(hdr []byte) int {
+	if len(hdr) < ms3Header {
+		return 0
+	}
+	return ms3Header + int(hdr[33]) + int(binary.LittleEndian.Uint16(hdr[34:36])) + int(binary.LittleEndian.Uint32(hdr[36:40]))
+}
+
+// ms3Record is a decoded miniseed 3 record.
+type ms3Record struct {
+	id       []string
+	start    time.Time
+	rate     float64
+	samples  int64
+	encoding uint8
+	extra    []byte
+	payload  []byte
+	sourceID string
+	version  uint8
+}
+
+// parseMS3 decodes a complete miniseed 3 record.
+func parseMS3(buf []byte) (*ms3Record, error) {
+	if !isMS3(buf) || len(buf) < ms3Header {
+		return nil, fmt.Errorf("not a miniseed 3 record")
+	}
+	if n := ms3Length(buf); n > len(buf) {
+		return nil, fmt.Errorf("truncated miniseed 3 record: %d of %d bytes", len(buf), n)
+	}
+
+	le := binary.LittleEndian
+
+	idlen := int(buf[33])
+	extralen := int(le.Uint16(buf[34:36]))
+	datalen := int(le.Uint32(buf[36:40]))
+
+	r := ms3Record{
+		samples:  int64(le.Uint32(buf[24:28])),
+		encoding: buf[15],
+		version:  buf[32],
+	}
+
+	r.start = time.Date(int(le.Uint16(buf[8:10])), time.January, 1,
+		int(buf[12]), int(buf[13]), int(buf[14]), int(le.Uint32(buf[4:8])), time.UTC).AddDate(0, 0, int(le.Uint16(buf[10:12]))-1)
+
+	// negative values give the sample period
+	switch rate := math.Float64frombits(le.Uint64(buf[16:24])); {
+	case rate < 0.0:
+		r.rate = -1.0 / rate
+	default:
+		r.rate = rate
+	}
+
+	r.sourceID = string(buf[ms3Header : ms3Header+idlen])
+	r.id = strings.Split(strings.TrimPrefix(r.sourceID, "FDSN:"), "_")
+	r.extra = buf[ms3Header+idlen : ms3Header+idlen+extralen]
+	r.payload = buf[ms3Header+idlen+extralen : ms3Header+idlen+extralen+datalen]
+
+	return &r, nil
+}
+
+func (r *ms3Record) part(n int) string {
+	if len(r.id) != 6 {
+		return ""
+	}
+	return r.id[n]
+}
+
+func (r *ms3Record) Network() string  { return r.part(0) }
+func (r *ms3Record) Station() string  { return r.part(1) }
+func (r *ms3Record) Location() string { return r.part(2) }
+func (r *ms3Record) Channel() string {
+	if len(r.id) != 6 {
+		return ""
+	}
+	return r.id[3] + r.id[4] + r.id[5]
+}
+
+// SrcName returns the stream name in the same NN_SSS_LL_CCC form as miniseed 2 records.
+func (r *ms3Record) SrcName(quality int) string {
+	if len(r.id) != 6 {
+		return strings.TrimPrefix(r.sourceID, "FDSN:")
+	}
+	return strings.Join([]string{r.Network(), r.Station(), r.Location(), r.Channel()}, "_")
+}
+
+func (r *ms3Record) Starttime() time.Time { return r.start }
+func (r *ms3Record) Samprate() float64    { return r.rate }
+func (r *ms3Record) Samplecnt() int64     { return r.samples }
+func (r *ms3Record) Encoding() int8       { return int8(r.encoding) }
+func (r *ms3Record) Byteorder() int8      { return 0 }
+
+// DataSamples decodes the record payload as integer samples.
+func (r *ms3Record) DataSamples() ([]int32, error) {
+	n := int(r.samples)
+	le := binary.LittleEndian
+
+	switch r.encoding {
+	case ms3Int16:
+		if len(r.payload) < 2*n {
+			return nil, fmt.Errorf("short int16 payload")
+		}
+		s := make([]int32, n)
+		for i := range s {
+			s[i] = int32(int16(le.Uint16(r.payload[2*i:])))
+		}
+		return s, nil
+	case ms3Int32:
+		if len(r.payload) < 4*n {
+			return nil, fmt.Errorf("short int32 payload")
+		}
+		s := make([]int32, n)
+		for i := range s {
+			s[i] = int32(le.Uint32(r.payload[4*i:]))
+		}
+		return s, nil
+	case ms3Float32:
+		if len(r.payload) < 4*n {
+			return nil, fmt.Errorf("short float32 payload")
+		}
+		s := make([]int32, n)
+		for i := range s {
+			s[i] = int32(math.Floor(float64(math.Float32frombits(le.Uint32(r.payload[4*i:]))) + 0.5))
+		}
+		return s, nil
+	case ms3Float64:
+		if len(r.payload) < 8*n {
+			return nil, fmt.Errorf("short float64 payload")
+		}
+		s := make([]int32, n)
+		for i := range s {
+			s[i] = int32(math.Floor(math.Float64frombits(le.Uint64(r.payload[8*i:])) + 0.5))
+		}
+		return s, nil
+	case ms3Steim1, ms3Steim2:
+		return decodeSteim(r.payload, n, r.encoding == ms3Steim2)
+	default:
+		return nil, fmt.Errorf("unsupported miniseed 3 encoding: %d", r.encoding)
+	}
+}
+
+// steim frames are sixty four bytes of big endian words
+const steimFrame = 64
+
+// signExtend recovers a signed value from the lower bits of a word.
+func signExtend(v uint32, bits uint) int32 {
+	shift := 32 - bits
+	return int32(v<<shift) >> shift
+}
+
+// decodeSteim unpacks steim1 or steim2 compressed samples.
+func decodeSteim(data []byte, n int, steim2 bool) ([]int32, error) {
+	be := binary.BigEndian
+
+	var diffs []int32
+	var x0, xn int32
+
+	for f := 0; f+steimFrame <= len(data) && len(diffs) < n; f += steimFrame {
+		frame := data[f : f+steimFrame]
+		nibbles := be.Uint32(frame[0:4])
+
+		for w := 1; w < 16; w++ {
+			word := be.Uint32(frame[4*w:])
+			code := (nibbles >> uint(30-2*w)) & 0x03
+
+			// integration constants are held in the first frame
+			if f == 0 && w == 1 {
+				x0 = int32(word)
+				continue
+			}
+			if f == 0 && w == 2 {
+				xn = int32(word)
+				continue
+			}
+
+			switch {
+			case code == 0:
+			case code == 1:
+				for i := uint(0); i < 4; i++ {
+					diffs = append(diffs, int32(int8(word>>(24-8*i))))
+				}
+			case !steim2 && code == 2:
+				diffs = append(diffs, int32(int16(word>>16)), int32(int16(word)))
+			case !steim2 && code == 3:
+				diffs = append(diffs, int32(word))
+			default:
+				dnib := word >> 30
+				var count, bits uint
+				switch {
+				case code == 2 && dnib == 1:
+					count, bits = 1, 30
+				case code == 2 && dnib == 2:
+					count, bits = 2, 15
+				case code == 2 && dnib == 3:
+					count, bits = 3, 10
+				case code == 3 && dnib == 0:
+					count, bits = 5, 6
+				case code == 3 && dnib == 1:
+					count, bits = 6, 5
+				case code == 3 && dnib == 2:
+					count, bits = 7, 4
+				default:
+					return nil, fmt.Errorf("invalid steim2 encoding")
+				}
+				mask := uint32(1)<<bits - 1
+				for i := uint(0); i < count; i++ {
+					diffs = append(diffs, signExtend((word>>((count-1-i)*bits))&mask, bits))
+				}
+			}
+		}
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+	if len(diffs) < n {
+		return nil, fmt.Errorf("steim data holds %d of %d samples", len(diffs), n)
+	}
+
+	// the first difference refers to the previous record
+	samples := make([]int32, n)
+	samples[0] = x0
+	for i := 1; i < n; i++ {
+		samples[i] = samples[i-1] + diffs[i]
+	}
+	if samples[n-1] != xn {
+		return nil, fmt.Errorf("steim integrity check failed: %d != %d", samples[n-1], xn)
+	}
+
+	return samples, nil
+}
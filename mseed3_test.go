@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ms3Build assembles a miniseed 3 record, a negative rate gives the sample period.
+func ms3Build(sid string, start time.Time, rate float64, encoding uint8, samples int, extra, payload []byte) []byte {
+	le := binary.LittleEndian
+
+	buf := make([]byte, ms3Header)
+	buf[0], buf[1], buf[2] = 'M', 'S', 3
+	le.PutUint32(buf[4:8], uint32(start.Nanosecond()))
+	le.PutUint16(buf[8:10], uint16(start.Year()))
+	le.PutUint16(buf[10:12], uint16(start.YearDay()))
+	buf[12], buf[13], buf[14] = byte(start.Hour()), byte(start.Minute()), byte(start.Second())
+	buf[15] = encoding
+	le.PutUint64(buf[16:24], math.Float64bits(rate))
+	le.PutUint32(buf[24:28], uint32(samples))
+	buf[32] = 1
+	buf[33] = byte(len(sid))
+	le.PutUint16(buf[34:36], uint16(len(extra)))
+	le.PutUint32(buf[36:40], uint32(len(payload)))
+
+	buf = append(buf, sid...)
+	buf = append(buf, extra...)
+	return append(buf, payload...)
+}
+
+// steimWord is a single data word of a steim frame along with its nibble code.
+type steimWord struct {
+	code uint32
+	word uint32
+}
+
+// steimFrames packs data words into frames after the forward and reverse integration constants.
+func steimFrames(x0, xn int32, words []steimWord) []byte {
+	be := binary.BigEndian
+
+	var data []byte
+	for f := 0; f == 0 || len(words) > 0; f++ {
+		frame := make([]byte, steimFrame)
+		w := 1
+		if f == 0 {
+			be.PutUint32(frame[4:], uint32(x0))
+			be.PutUint32(frame[8:], uint32(xn))
+			w = 3
+		}
+		var nibbles uint32
+		for ; w < 16 && len(words) > 0; w++ {
+			nibbles |= words[0].code << uint(30-2*w)
+			be.PutUint32(frame[4*w:], words[0].word)
+			words = words[1:]
+		}
+		be.PutUint32(frame[0:4], nibbles)
+		data = append(data, frame...)
+	}
+	return data
+}
+
+// steimBytes packs four byte differences into a word.
+func steimBytes(d ...int8) uint32 {
+	return uint32(uint8(d[0]))<<24 | uint32(uint8(d[1]))<<16 | uint32(uint8(d[2]))<<8 | uint32(uint8(d[3]))
+}
+
+// steimPack packs equal width differences, with the steim2 dnib code, into a word.
+func steimPack(dnib uint32, bits uint, d ...int32) uint32 {
+	word, mask := dnib<<30, uint32(1)<<bits-1
+	for i, v := range d {
+		word |= (uint32(v) & mask) << (uint(len(d)-1-i) * bits)
+	}
+	return word
+}
+
+func TestParseMS3(t *testing.T) {
+	start := time.Date(2016, time.November, 13, 11, 2, 56, 500000000, time.UTC)
+
+	tests := []struct {
+		name    string
+		buf     []byte
+		srcname string
+		rate    float64
+		err     bool
+	}{
+		{"rate", ms3Build("FDSN:NZ_WEL_20_H_N_Z", start, 100.0, ms3Int32, 0, nil, nil), "NZ_WEL_20_HNZ", 100.0, false},
+		{"period", ms3Build("FDSN:NZ_WEL_20_H_N_Z", start, -0.02, ms3Int32, 0, nil, nil), "NZ_WEL_20_HNZ", 50.0, false},
+		{"extra", ms3Build("FDSN:NZ_WEL__B_N_Z", start, 50.0, ms3Int32, 1, []byte(`{"FDSN":{}}`), []byte{1, 0, 0, 0}), "NZ_WEL__BNZ", 50.0, false},
+		{"unknown identifier", ms3Build("XX:WEL", start, 100.0, ms3Int32, 0, nil, nil), "XX:WEL", 100.0, false},
+		{"short header", ms3Build("FDSN:NZ_WEL_20_H_N_Z", start, 100.0, ms3Int32, 0, nil, nil)[:ms3Header-1], "", 0, true},
+		{"truncated", ms3Build("FDSN:NZ_WEL_20_H_N_Z", start, 100.0, ms3Int32, 2, nil, make([]byte, 8))[:ms3Header+24], "", 0, true},
+		{"version", append([]byte("MS\x02"), make([]byte, ms3Header)...), "", 0, true},
+		{"empty", nil, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseMS3(tt.buf)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := r.SrcName(0); s != tt.srcname {
+				t.Errorf("expected stream %s, got %s", tt.srcname, s)
+			}
+			if !r.Starttime().Equal(start) {
+				t.Errorf("expected start %s, got %s", start, r.Starttime())
+			}
+			if r.Samprate() != tt.rate {
+				t.Errorf("expected rate %g, got %g", tt.rate, r.Samprate())
+			}
+			if n := ms3Length(tt.buf); n != len(tt.buf) {
+				t.Errorf("expected length %d, got %d", len(tt.buf), n)
+			}
+		})
+	}
+}
+
+func TestMS3Samples(t *testing.T) {
+	le := binary.LittleEndian
+
+	int16s, int32s, float32s, float64s := make([]byte, 6), make([]byte, 12), make([]byte, 12), make([]byte, 24)
+	for i, v := range []int32{-2, 0, 30000} {
+		le.PutUint16(int16s[2*i:], uint16(int16(v)))
+		le.PutUint32(int32s[4*i:], uint32(v))
+		le.PutUint32(float32s[4*i:], math.Float32bits(float32(v)+0.4))
+		le.PutUint64(float64s[8*i:], math.Float64bits(float64(v)-0.4))
+	}
+
+	tests := []struct {
+		name     string
+		encoding uint8
+		samples  int
+		payload  []byte
+		want     []int32
+		err      bool
+	}{
+		{"int16", ms3Int16, 3, int16s, []int32{-2, 0, 30000}, false},
+		{"int32", ms3Int32, 3, int32s, []int32{-2, 0, 30000}, false},
+		{"float32", ms3Float32, 3, float32s, []int32{-2, 0, 30000}, false},
+		{"float64", ms3Float64, 3, float64s, []int32{-2, 0, 30000}, false},
+		{"short int16", ms3Int16, 4, int16s, nil, true},
+		{"short int32", ms3Int32, 4, int32s, nil, true},
+		{"short float32", ms3Float32, 4, float32s, nil, true},
+		{"short float64", ms3Float64, 4, float64s, nil, true},
+		{"unsupported", 2, 3, int32s, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseMS3(ms3Build("FDSN:NZ_WEL_20_H_N_Z", time.Now(), 100.0, tt.encoding, tt.samples, nil, tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples, err := r.DataSamples()
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", samples)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalSamples(samples, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, samples)
+			}
+		})
+	}
+}
+
+func TestDecodeSteim(t *testing.T) {
+	ramp := make([]int32, 56)
+	ones := make([]steimWord, 14)
+	for i := range ramp {
+		ramp[i] = int32(i)
+	}
+	for i := range ones {
+		ones[i] = steimWord{1, steimBytes(1, 1, 1, 1)}
+	}
+
+	tests := []struct {
+		name   string
+		steim2 bool
+		x0     int32
+		words  []steimWord
+		want   []int32
+		// the number of samples to decode, if not all those wanted
+		n int
+		// whether the reverse constant does not match the last sample
+		reverse bool
+		err     bool
+	}{
+		{name: "steim1 bytes", x0: 10, words: []steimWord{{1, steimBytes(0, 1, -1, 2)}}, want: []int32{10, 11, 10, 12}},
+		{name: "steim1 halves", x0: 100, words: []steimWord{{2, uint32(300)}, {2, uint32(0xfed4)<<16 | 7}}, want: []int32{100, 400, 100, 107}},
+		{name: "steim1 words", x0: 5, words: []steimWord{{3, 0}, {3, 70000}, {3, uint32(0xfffeee90)}}, want: []int32{5, 70005, 5}},
+		{name: "steim1 skipped", words: []steimWord{{0, 0xffffffff}, {1, steimBytes(0, 1, 2, 3)}}, want: []int32{0, 1, 3, 6}},
+		{name: "steim1 frames", words: ones, want: ramp},
+		{name: "steim1 partial", words: ones, want: ramp[:50]},
+		{name: "steim2 bytes", steim2: true, x0: 10, words: []steimWord{{1, steimBytes(0, 1, -1, 2)}}, want: []int32{10, 11, 10, 12}},
+		{name: "steim2 one", steim2: true, x0: 1, words: []steimWord{{2, steimPack(1, 30, 0)}, {2, steimPack(1, 30, -100000)}}, want: []int32{1, -99999}},
+		{name: "steim2 two", steim2: true, words: []steimWord{{2, steimPack(2, 15, 0, -1000)}, {2, steimPack(2, 15, 2000, -3)}}, want: []int32{0, -1000, 1000, 997}},
+		{name: "steim2 three", steim2: true, x0: 7, words: []steimWord{{2, steimPack(3, 10, 0, 511, -512)}}, want: []int32{7, 518, 6}},
+		{name: "steim2 five", steim2: true, words: []steimWord{{3, steimPack(0, 6, 0, 31, -32, 1, -1)}}, want: []int32{0, 31, -1, 0, -1}},
+		{name: "steim2 six", steim2: true, x0: 3, words: []steimWord{{3, steimPack(1, 5, 0, 15, -16, 2, -2, 0)}}, want: []int32{3, 18, 2, 4, 2, 2}},
+		{name: "steim2 seven", steim2: true, words: []steimWord{{3, steimPack(2, 4, 0, 7, -8, 1, -1, 3, -3)}}, want: []int32{0, 7, -1, 0, -1, 2, -1}},
+		{name: "steim2 skipped", steim2: true, words: []steimWord{{0, 0xffffffff}, {2, steimPack(3, 10, 0, 1, 2)}}, want: []int32{0, 1, 3}},
+		{name: "steim2 frames", steim2: true, words: ones, want: ramp},
+		{name: "no samples", words: ones},
+		{name: "steim2 invalid halves", steim2: true, words: []steimWord{{2, steimPack(0, 15, 0, 1)}}, n: 2, err: true},
+		{name: "steim2 invalid words", steim2: true, words: []steimWord{{3, steimPack(3, 4, 0, 1, 2, 3, 4, 5, 6)}}, n: 7, err: true},
+		{name: "steim1 reverse", x0: 10, words: []steimWord{{1, steimBytes(0, 1, -1, 2)}}, want: []int32{10, 11, 10, 12}, reverse: true, err: true},
+		{name: "steim2 reverse", steim2: true, words: []steimWord{{3, steimPack(0, 6, 0, 31, -32, 1, -1)}}, want: []int32{0, 31, -1, 0, -1}, reverse: true, err: true},
+		{name: "steim1 short", words: []steimWord{{1, steimBytes(0, 1, 2, 3)}}, n: 5, err: true},
+		{name: "steim2 short", steim2: true, words: ones, n: 60, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, xn := tt.n, int32(0)
+			if n == 0 {
+				n = len(tt.want)
+			}
+			if len(tt.want) > 0 {
+				xn = tt.want[len(tt.want)-1]
+			}
+			if tt.reverse {
+				xn++
+			}
+
+			samples, err := decodeSteim(steimFrames(tt.x0, xn, tt.words), n, tt.steim2)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", samples)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalSamples(samples, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, samples)
+			}
+		})
+	}
+}
+
+func TestMS3Corrupt(t *testing.T) {
+	start := time.Date(2016, time.November, 13, 11, 2, 56, 0, time.UTC)
+	words := []steimWord{{1, steimBytes(0, 1, -1, 2)}, {2, steimPack(1, 30, -100000)}, {3, steimPack(0, 6, 0, 31, -32, 1, -1)}}
+	buf := ms3Build("FDSN:NZ_WEL_20_H_N_Z", start, 100.0, ms3Steim2, 10, nil, steimFrames(0, -99999, words))
+
+	r, err := parseMS3(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DataSamples(); err != nil {
+		t.Fatal(err)
+	}
+
+	// truncated records are rejected rather than read past their end
+	for n := 0; n < len(buf); n++ {
+		if _, err := parseMS3(buf[:n]); err == nil {
+			t.Errorf("expected an error for a record truncated to %d bytes", n)
+		}
+	}
+
+	// corrupt headers and payloads give errors or bad samples, but never panic
+	for i := range buf {
+		for _, b := range []byte{0x00, 0x03, 0x7f, 0xff} {
+			corrupt := append([]byte{}, buf...)
+			corrupt[i] = b
+			if r, err := parseMS3(corrupt); err == nil {
+				_, _ = r.DataSamples()
+				_ = r.SrcName(0)
+			}
+		}
+	}
+
+	// a record claiming more samples than its payload holds
+	short := ms3Build("FDSN:NZ_WEL_20_H_N_Z", start, 100.0, ms3Steim1, 1000, nil, steimFrames(0, 0, words)[:steimFrame-4])
+	if r, err := parseMS3(short); err != nil {
+		t.Fatal(err)
+	} else if _, err := r.DataSamples(); err == nil || !strings.Contains(err.Error(), "of 1000 samples") {
+		t.Errorf("expected a short steim payload error, got %v", err)
+	}
+}
+
+func equalSamples(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+package msimpact
+
+import "time"
+
+// Clock provides the current time, allowing the wall clock to be replaced, e.g. by a fake in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the default clock, the wall clock.
+var SystemClock Clock = systemClock{}
@@ -0,0 +1,93 @@
+package msimpact
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ozym/impact"
+	"time"
+)
+
+// Duration decodes either a go duration string (e.g. "-1.5s") or a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch x := v.(type) {
+	case float64:
+		*d = Duration(x * float64(time.Second))
+	case string:
+		t, err := time.ParseDuration(x)
+		if err != nil {
+			return err
+		}
+		*d = Duration(t)
+	default:
+		return fmt.Errorf("invalid duration: %s", string(b))
+	}
+	return nil
+}
+
+// StreamConfig holds the extra per stream settings, these are read from
+// the same file as the impact stream parameters.
+type StreamConfig struct {
+	// station elevation, in metres, added to messages
+	Elevation *float64 `json:"elevation"`
+
+	// clock correction applied to record start times
+	TimeOffset Duration `json:"time_offset"`
+
+	// noise probation window and suppression level
+	Probation *Duration `json:"probation"`
+	Level     *int32    `json:"level"`
+
+	// noise level above which messages are flagged as possibly noisy
+	WarnLevel *int32 `json:"warn_level"`
+
+	// intensity assumed before the first record is processed
+	InitialMMI *int32 `json:"initial_mmi"`
+
+	// messages below this intensity are not sent, overrides the flush policy threshold
+	MinMMI *int32 `json:"min_mmi"`
+
+	// remove the "mean" or "linear" trend of each record before any filtering
+	Detrend string `json:"detrend"`
+
+	// optional filter corner frequencies, in Hz
+	Highpass float64 `json:"highpass"`
+	Lowpass  float64 `json:"lowpass"`
+
+	// reduce the sample rate by this factor, after an anti-alias filter, the stream
+	// Rate should be the decimated rate
+	Decimate int `json:"decimate"`
+
+	// the instrument counts per m/s, or m/s/s, either directly or as the product of the response
+	// stage gains, samples are rescaled to the stream gain before processing
+	Sensitivity float64         `json:"sensitivity"`
+	Stages      []ResponseStage `json:"stages"`
+
+	// report peak ground motions, the sensor records either "acceleration" or "velocity"
+	Sensor string `json:"sensor"`
+
+	// alternative intensity scales to include, overrides the global setting
+	Scales []string `json:"scales"`
+
+	// the channel code of the other horizontal component, the pair is then reported as a single
+	// stream of their vector sum rather than each component independently
+	Horizontal string `json:"horizontal"`
+
+	// the channel code of the combined stream of a horizontal pair, by default the component letter is replaced by "H"
+	Combined string `json:"combined"`
+}
+
+// Config holds the decoded contents of a stream config, keyed by stream name or wildcard pattern.
+type Config struct {
+	// impact stream parameters
+	Streams map[string]*impact.Stream
+	// extra stream settings
+	Settings map[string]StreamConfig
+	// raw, compacted, entries used for detecting changes, streams without an entry are always reinitialised on reload
+	Entries map[string][]byte
+}
@@ -0,0 +1,61 @@
+package msimpact
+
+import (
+	"fmt"
+	"time"
+)
+
+// decimator reduces the sample rate of a stream by an integer factor, after a lowpass anti-alias
+// filter, keeping the filter state and sample phase between records but starting again on any gap.
+type decimator struct {
+	factor int
+
+	rate    float64
+	filters []*streamFilter
+
+	// samples still to skip before the next one kept, and the expected start of the next record
+	skip int
+	next time.Time
+}
+
+func newDecimator(factor int) (*decimator, error) {
+	if factor < 1 {
+		return nil, fmt.Errorf("invalid decimation factor: %d", factor)
+	}
+	return &decimator{factor: factor}, nil
+}
+
+// Apply decimates a record, returning the start time and rate of the samples kept, which may be none.
+func (d *decimator) Apply(start time.Time, rate float64, samples []int32) (time.Time, float64, []int32) {
+	if d.factor == 1 || rate <= 0.0 || len(samples) == 0 {
+		return start, rate, samples
+	}
+
+	// two lowpass sections, with a corner well below the new nyquist frequency
+	if rate != d.rate {
+		corner := 0.4 * rate / float64(d.factor)
+		d.rate, d.filters = rate, []*streamFilter{newStreamFilter(0.0, corner), newStreamFilter(0.0, corner)}
+	}
+	for _, f := range d.filters {
+		samples = f.Apply(start, rate, samples)
+	}
+
+	period := time.Duration(float64(time.Second) / rate)
+	if d.next.IsZero() || start.Sub(d.next) > period/2 || d.next.Sub(start) > period/2 {
+		d.skip = 0
+	}
+	d.next = start.Add(time.Duration(len(samples)) * period)
+
+	first := start.Add(time.Duration(d.skip) * period)
+	var out []int32
+	for i := d.skip; i < len(samples); i += d.factor {
+		out = append(out, samples[i])
+	}
+	if n := len(samples) - d.skip; n > 0 {
+		d.skip = (d.factor - n%d.factor) % d.factor
+	} else {
+		d.skip = -n
+	}
+
+	return first, rate / float64(d.factor), out
+}
@@ -0,0 +1,48 @@
+package msimpact
+
+import (
+	"fmt"
+	"math"
+)
+
+// detrending applied to each record before processing
+const (
+	DetrendMean   = "mean"
+	DetrendLinear = "linear"
+)
+
+func checkDetrend(mode string) error {
+	switch mode {
+	case "", DetrendMean, DetrendLinear:
+		return nil
+	default:
+		return fmt.Errorf("unknown detrend %q, expected %s or %s", mode, DetrendMean, DetrendLinear)
+	}
+}
+
+// detrend removes either the mean, or the least squares straight line, from a block of samples.
+func detrend(mode string, samples []int32) []int32 {
+	n := len(samples)
+	if n == 0 || mode == "" {
+		return samples
+	}
+
+	var sx, sy, sxx, sxy float64
+	for i, s := range samples {
+		x, y := float64(i), float64(s)
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+
+	// the trend is a + b*i
+	a, b := sy/float64(n), 0.0
+	if d := float64(n)*sxx - sx*sx; mode == DetrendLinear && d != 0.0 {
+		b = (float64(n)*sxy - sx*sy) / d
+		a = (sy - b*sx) / float64(n)
+	}
+
+	out := make([]int32, n)
+	for i, s := range samples {
+		out[i] = int32(math.Floor(float64(s) - (a + b*float64(i)) + 0.5))
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package msimpact
+
+import (
+	"math"
+	"time"
+)
+
+// biquad is a second order butterworth filter section.
+type biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+
+	x1, x2 float64
+	y1, y2 float64
+
+	// steady state gain, used when priming
+	dc float64
+}
+
+// newBiquad builds a lowpass, or highpass, section for a corner frequency and sample rate.
+func newBiquad(corner, rate float64, high bool) biquad {
+	w0 := 2.0 * math.Pi * corner / rate
+	alpha := math.Sin(w0) / math.Sqrt2
+	cos := math.Cos(w0)
+
+	a0 := 1.0 + alpha
+
+	var b biquad
+	switch {
+	case high:
+		b.b0, b.b1, b.b2 = (1.0+cos)/2.0, -(1.0 + cos), (1.0+cos)/2.0
+	default:
+		b.b0, b.b1, b.b2 = (1.0-cos)/2.0, 1.0-cos, (1.0-cos)/2.0
+		b.dc = 1.0
+	}
+	b.b0, b.b1, b.b2 = b.b0/a0, b.b1/a0, b.b2/a0
+	b.a1, b.a2 = -2.0*cos/a0, (1.0-alpha)/a0
+
+	return b
+}
+
+// prime sets the filter history as if the input had been constant, to avoid a startup transient.
+func (b *biquad) prime(x float64) {
+	b.x1, b.x2 = x, x
+	b.y1, b.y2 = x*b.dc, x*b.dc
+}
+
+func (b *biquad) filter(x float64) float64 {
+	y := b.b0*x + b.b1*b.x1 + b.b2*b.x2 - b.a1*b.y1 - b.a2*b.y2
+	b.x2, b.x1 = b.x1, x
+	b.y2, b.y1 = b.y1, y
+	return y
+}
+
+// streamFilter applies an optional highpass and lowpass filter to a stream of records,
+// the filter state is carried between records but is reset on any gap.
+type streamFilter struct {
+	highpass float64
+	lowpass  float64
+
+	rate     float64
+	sections []biquad
+
+	// expected start of the next record
+	next time.Time
+}
+
+func newStreamFilter(highpass, lowpass float64) *streamFilter {
+	return &streamFilter{
+		highpass: highpass,
+		lowpass:  lowpass,
+	}
+}
+
+func (f *streamFilter) design(rate float64) {
+	f.rate, f.sections = rate, nil
+	if f.highpass > 0.0 && f.highpass < rate/2.0 {
+		f.sections = append(f.sections, newBiquad(f.highpass, rate, true))
+	}
+	if f.lowpass > 0.0 && f.lowpass < rate/2.0 {
+		f.sections = append(f.sections, newBiquad(f.lowpass, rate, false))
+	}
+}
+
+// Apply filters a record of samples, returning a new slice.
+func (f *streamFilter) Apply(start time.Time, rate float64, samples []int32) []int32 {
+	if rate <= 0.0 || len(samples) == 0 {
+		return samples
+	}
+
+	// any change or gap restarts the filter
+	period := time.Duration(float64(time.Second) / rate)
+	gap := f.next.IsZero() || start.Sub(f.next) > period/2 || f.next.Sub(start) > period/2
+	if rate != f.rate {
+		f.design(rate)
+		gap = true
+	}
+	if gap {
+		for i := range f.sections {
+			f.sections[i].prime(float64(samples[0]))
+		}
+	}
+	f.next = start.Add(time.Duration(len(samples)) * period)
+
+	out := make([]int32, len(samples))
+	for i, s := range samples {
+		v := float64(s)
+		for j := range f.sections {
+			v = f.sections[j].filter(v)
+		}
+		out[i] = int32(math.Floor(v + 0.5))
+	}
+
+	return out
+}
@@ -0,0 +1,56 @@
+package msimpact
+
+import (
+	"math"
+	"time"
+)
+
+// message types used for reporting discontinuities in the data
+const (
+	Gap     = "gap"
+	Overlap = "overlap"
+)
+
+// Discontinuity is a gap, or overlap, between consecutive records of a stream.
+type Discontinuity struct {
+	Stream string
+
+	// when the record was expected to start, and how far it was out,
+	// positive for a gap and negative for an overlap
+	Expected time.Time
+	Offset   time.Duration
+}
+
+// Type returns whether the discontinuity is a gap or an overlap.
+func (d Discontinuity) Type() string {
+	if d.Offset < 0 {
+		return Overlap
+	}
+	return Gap
+}
+
+// continuity checks whether a record starts where the previous record of its stream ended, to
+// within half a sample, remembering where the next should start.
+func (p *StreamProcessor) continuity(srcname string, msr Record) (Discontinuity, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rate := msr.Samprate()
+	if rate <= 0.0 {
+		return Discontinuity{}, false
+	}
+	start := msr.Starttime()
+
+	expected, ok := p.expected[srcname]
+	p.expected[srcname] = start.Add(time.Duration(float64(msr.Samplecnt()) / rate * float64(time.Second)))
+	if !ok {
+		return Discontinuity{}, false
+	}
+
+	offset := start.Sub(expected)
+	if math.Abs(offset.Seconds()) <= 0.5/rate {
+		return Discontinuity{}, false
+	}
+
+	return Discontinuity{Stream: srcname, Expected: expected, Offset: offset}, true
+}
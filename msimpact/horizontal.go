@@ -0,0 +1,152 @@
+package msimpact
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// horizontalName gives the stream name used for the vector sum of a pair of horizontal components, the
+// channel code is replaced by any configured channel, otherwise its component letter is replaced by "H", e.g. NZ_WEL_20_HNH.
+func horizontalName(s, channel string) string {
+	if s == "" {
+		return s
+	}
+	if channel != "" {
+		return s[:strings.LastIndex(s, "_")+1] + channel
+	}
+	return s[:len(s)-1] + "H"
+}
+
+// componentSamples are the processed samples of one component not yet combined with the other.
+type componentSamples struct {
+	start   time.Time
+	rate    float64
+	samples []int32
+}
+
+func (c *componentSamples) end() time.Time {
+	return c.start.Add(time.Duration(float64(len(c.samples)) / c.rate * float64(time.Second)))
+}
+
+// trim drops the samples before a given time.
+func (c *componentSamples) trim(t time.Time) {
+	n := int(math.Round(t.Sub(c.start).Seconds() * c.rate))
+	if n <= 0 {
+		return
+	}
+	if n > len(c.samples) {
+		n = len(c.samples)
+	}
+	c.start, c.samples = c.start.Add(time.Duration(float64(n)/c.rate*float64(time.Second))), c.samples[n:]
+}
+
+// horizontalPair combines the samples of two horizontal components, a combined sample has the
+// magnitude of the horizontal vector and the sign of the larger component, so it can be processed
+// as an ordinary stream giving the intensity of the vector sum rather than of either component.
+type horizontalPair struct {
+	name        string
+	first, last string
+
+	pending map[string]*componentSamples
+
+	// guards the pending samples, and serialises processing of the combined stream
+	mu sync.Mutex
+}
+
+func newHorizontalPair(name, first, last string) *horizontalPair {
+	return &horizontalPair{
+		name:    name,
+		first:   first,
+		last:    last,
+		pending: make(map[string]*componentSamples),
+	}
+}
+
+// other returns the name of the partner component.
+func (h *horizontalPair) other(s string) string {
+	if s == h.first {
+		return h.last
+	}
+	return h.first
+}
+
+// add stores the processed samples of one component, returning any combined samples now available
+// from the overlap with the other component, gaps in either component discard unmatched samples.
+func (h *horizontalPair) add(s string, start time.Time, rate float64, samples []int32) (time.Time, []int32, error) {
+	c, ok := h.pending[s]
+	if ok && c.rate == rate && math.Abs(start.Sub(c.end()).Seconds()) < 0.5/rate {
+		c.samples = append(c.samples, samples...)
+	} else {
+		c = &componentSamples{start: start, rate: rate, samples: append([]int32(nil), samples...)}
+		h.pending[s] = c
+	}
+
+	o, ok := h.pending[h.other(s)]
+	if !ok {
+		return time.Time{}, nil, nil
+	}
+	if o.rate != c.rate {
+		delete(h.pending, h.other(s))
+		return time.Time{}, nil, fmt.Errorf("horizontal components %s and %s have different sample rates", h.first, h.last)
+	}
+
+	from, to := c.start, c.end()
+	if o.start.After(from) {
+		from = o.start
+	}
+	if o.end().Before(to) {
+		to = o.end()
+	}
+	c.trim(from)
+	o.trim(from)
+
+	n := int(math.Round(to.Sub(from).Seconds() * rate))
+	if n > len(c.samples) {
+		n = len(c.samples)
+	}
+	if n > len(o.samples) {
+		n = len(o.samples)
+	}
+	if n <= 0 {
+		return time.Time{}, nil, nil
+	}
+
+	combined := make([]int32, n)
+	for i := range combined {
+		a, b := float64(c.samples[i]), float64(o.samples[i])
+		v := math.Min(math.Hypot(a, b), math.MaxInt32)
+		if math.Abs(b) > math.Abs(a) {
+			a = b
+		}
+		if a < 0.0 {
+			v = -v
+		}
+		combined[i] = int32(math.Round(v))
+	}
+	start = c.start
+	next := start.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+	c.trim(next)
+	o.trim(next)
+
+	return start, combined, nil
+}
+
+// record builds a record of the vector sum samples of a pair.
+func (h *horizontalPair) record(msr Record, start time.Time, rate float64, samples []int32) Record {
+	parts := strings.Split(h.name, "_")
+	r := snapshot{
+		network:   msr.Network(),
+		station:   msr.Station(),
+		location:  msr.Location(),
+		channel:   parts[len(parts)-1],
+		srcname:   h.name,
+		start:     start,
+		samprate:  rate,
+		samplecnt: int64(len(samples)),
+		samples:   samples,
+	}
+	return &r
+}
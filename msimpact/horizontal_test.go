@@ -0,0 +1,69 @@
+package msimpact_test
+
+import (
+	"github.com/ozym/impact"
+	"github.com/ozym/msimpact/msimpact"
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"math"
+	"testing"
+)
+
+func TestHorizontalPair(t *testing.T) {
+	const (
+		north = "XX_TEST_10_HNN"
+		east  = "XX_TEST_10_HNE"
+	)
+
+	// equal components in phase have a vector sum of root two times either component, to within a count or so of rounding
+	alone := testRunSource(t, testProcessor(t, msimpact.Options{InitialMMI: -1}, msimpact.StreamConfig{Sensor: "velocity"}), testComponent(testStream, math.Sqrt2))
+
+	tests := []struct {
+		name     string
+		combined string
+		stream   string
+	}{
+		{"default channel", "", "XX_TEST_10_HNH"},
+		{"configured channel", "HNX", "XX_TEST_10_HNX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := msimpact.Config{
+				Streams: map[string]*impact.Stream{
+					north: {Name: "Test", Latitude: -41.0, Longitude: 174.5, Q: 0.98, Rate: testRate, Gain: testGain},
+					east:  {Name: "Test", Latitude: -41.0, Longitude: 174.5, Q: 0.98, Rate: testRate, Gain: testGain},
+				},
+				Settings: map[string]msimpact.StreamConfig{
+					north: {Horizontal: "HNE", Combined: tt.combined, Sensor: "velocity"},
+					east:  {Horizontal: "HNN", Combined: tt.combined, Sensor: "velocity"},
+				},
+			}
+			p, err := msimpact.NewStreamProcessor(msimpact.Options{InitialMMI: -1}, &config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the components arrive interleaved, as from a real-time feed
+			var records msimpacttest.Source
+			n, e := testComponent(north, 1.0), testComponent(east, 1.0)
+			for i := range n {
+				records = append(records, n[i], e[i])
+			}
+
+			messages := testRunSource(t, p, records)
+			if len(messages) != len(alone) {
+				t.Fatalf("expected %d combined messages, got %d", len(alone), len(messages))
+			}
+			for i, m := range messages {
+				switch {
+				case m.Stream != tt.stream:
+					t.Errorf("expected only combined messages from %s, got %s", tt.stream, m.Stream)
+				case m.MMI != alone[i].MMI || !m.Time.Equal(alone[i].Time):
+					t.Errorf("expected MMI %d at %s, got %d at %s", alone[i].MMI, alone[i].Time, m.MMI, m.Time)
+				case math.Abs(m.PGV-alone[i].PGV) > 1.0e-5*alone[i].PGV+2.0/testGain:
+					t.Errorf("expected the vector sum PGV %g, got %g", alone[i].PGV, m.PGV)
+				}
+			}
+		})
+	}
+}
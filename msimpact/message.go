@@ -0,0 +1,39 @@
+package msimpact
+
+import "github.com/ozym/impact"
+
+// message types, normal intensity messages have no type
+const (
+	AllClear = "all-clear"
+)
+
+// Message is an impact message with any extra output fields,
+// these are omitted when empty to keep the original encoding.
+type Message struct {
+	impact.Message
+
+	Type string `json:"Type,omitempty"`
+
+	// the station elevation in metres, if configured
+	Elevation *float64 `json:"Elevation,omitempty"`
+
+	// the stream is above the noise warning level but below the suppression level
+	PossiblyNoisy bool `json:"PossiblyNoisy,omitempty"`
+
+	// peak ground acceleration (m/s/s) and velocity (m/s) of the record, if the sensor type is configured
+	PGA float64 `json:"PGA,omitempty"`
+	PGV float64 `json:"PGV,omitempty"`
+
+	// intensities on any alternative scales requested, JMA needs a configured sensor
+	JMA   *float64 `json:"JMA,omitempty"`
+	EMS98 *int32   `json:"EMS98,omitempty"`
+
+	// the intensity has not changed, but is resent to show the stream is alive
+	Heartbeat bool `json:"Heartbeat,omitempty"`
+
+	// the version of the program that generated the message, if known
+	Version string `json:"Version,omitempty"`
+
+	// the stream name, used as the message key
+	Stream string `json:"-"`
+}
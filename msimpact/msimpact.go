@@ -0,0 +1,160 @@
+// Package msimpact provides the pipeline used to turn miniseed records into shaking intensity messages,
+// records are read from a Source, turned into messages by a Processor, and delivered to a Sink.
+package msimpact
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Source provides decoded records to a handler, the record may be reused between calls.
+type Source interface {
+	Records(handler func(Record) error) error
+}
+
+// SourceFunc allows an ordinary function to be used as a Source.
+type SourceFunc func(handler func(Record) error) error
+
+func (f SourceFunc) Records(handler func(Record) error) error {
+	return f(handler)
+}
+
+// Processor builds messages from records, a nil message indicates there is nothing to send.
+type Processor interface {
+	Process(msr Record) (*Message, error)
+}
+
+// Sink is an output destination for encoded messages, the key is the
+// stream name (NN_SSS_LL_CCC) that generated the message.
+type Sink interface {
+	Send(key string, msg []byte) error
+	Close() error
+}
+
+// Pipeline passes each record from a source through a processor, sending any resulting messages, as JSON, to a sink.
+type Pipeline struct {
+	Processor Processor
+	Sink      Sink
+
+	// optional handling of record processing problems, these are otherwise logged as warnings and skipped
+	Problem func(msr Record, err error)
+
+	// if set, each stream is processed in its own goroutine, with up to this many records waiting,
+	// so a slow stream does not hold up the others. The processor, sink and any problem handler must
+	// then be safe for concurrent use.
+	Queue int
+
+	// if set, up to this many records of each stream are held back and released in start time order,
+	// delaying each record until that many later records of the stream have arrived.
+	Reorder int
+
+	// optional timing of the "process" and "marshal" stages of each record, e.g. for benchmarking,
+	// it must be safe for concurrent use if the records are processed concurrently.
+	Observe func(stage string, elapsed time.Duration)
+}
+
+// Run processes all the records from a source, stopping on any source or sink error.
+func (p *Pipeline) Run(src Source) error {
+	if p.Reorder > 0 {
+		src = reorderSource{src: src, depth: p.Reorder}
+	}
+	if p.Queue > 0 {
+		return p.concurrent(src)
+	}
+	return src.Records(p.process)
+}
+
+// process handles a single record.
+func (p *Pipeline) process(msr Record) error {
+	start := time.Now()
+	m, err := p.Processor.Process(msr)
+	p.observe("process", start)
+	if err != nil {
+		if p.Problem != nil {
+			p.Problem(msr, err)
+		} else {
+			slog.Warn("processing problem", "stream", msr.SrcName(0), "time", msr.Starttime(), "error", err)
+		}
+		return nil
+	}
+	if m == nil {
+		return nil
+	}
+
+	start = time.Now()
+	b, err := json.Marshal(m)
+	p.observe("marshal", start)
+	if err != nil {
+		return err
+	}
+
+	return p.Sink.Send(m.Stream, b)
+}
+
+// observe passes on the time taken by a stage, if wanted.
+func (p *Pipeline) observe(stage string, start time.Time) {
+	if p.Observe != nil {
+		p.Observe(stage, time.Since(start))
+	}
+}
+
+// concurrent passes a copy of each record to a goroutine for its stream, the first error stops the run.
+func (p *Pipeline) concurrent(src Source) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failed error
+	done := make(chan struct{})
+
+	queues := make(map[string]chan Record)
+	err := src.Records(func(msr Record) error {
+		select {
+		case <-done:
+			return failed
+		default:
+		}
+
+		r := Snapshot(msr)
+		queue, ok := queues[r.SrcName(0)]
+		if !ok {
+			queue = make(chan Record, p.Queue)
+			queues[r.SrcName(0)] = queue
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r := range queue {
+					if err := p.process(r); err != nil {
+						once.Do(func() {
+							failed = err
+							close(done)
+						})
+					}
+				}
+			}()
+		}
+
+		select {
+		case queue <- r:
+			return nil
+		case <-done:
+			return failed
+		}
+	})
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return failed
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,159 @@
+// Package msimpacttest provides fake clocks, records, sources and sinks for testing the msimpact pipeline
+// without real miniseed files or message queues.
+package msimpacttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock is a fake clock that only moves when told to, it is safe for concurrent use.
+type Clock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+// NewClock returns a fake clock set to the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Set moves the clock to a given time.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+// Advance moves the clock forward.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Record is a decoded miniseed record built directly from its samples.
+type Record struct {
+	Net, Sta, Loc, Cha string
+	Start              time.Time
+	Rate               float64
+	Samples            []int32
+
+	// returned instead of the samples, if set
+	Err error
+}
+
+// NewRecord builds a record for a stream given as NN_SSS_LL_CCC.
+func NewRecord(stream string, start time.Time, rate float64, samples []int32) *Record {
+	parts := append(strings.SplitN(stream, "_", 4), "", "", "", "")
+	return &Record{Net: parts[0], Sta: parts[1], Loc: parts[2], Cha: parts[3], Start: start, Rate: rate, Samples: samples}
+}
+
+func (r *Record) Network() string  { return r.Net }
+func (r *Record) Station() string  { return r.Sta }
+func (r *Record) Location() string { return r.Loc }
+func (r *Record) Channel() string  { return r.Cha }
+func (r *Record) SrcName(quality int) string {
+	return fmt.Sprintf("%s_%s_%s_%s", r.Net, r.Sta, r.Loc, r.Cha)
+}
+func (r *Record) Starttime() time.Time { return r.Start }
+func (r *Record) Samprate() float64    { return r.Rate }
+func (r *Record) Samplecnt() int64     { return int64(len(r.Samples)) }
+func (r *Record) Encoding() int8       { return 3 }
+func (r *Record) Byteorder() int8      { return 1 }
+func (r *Record) DataSamples() ([]int32, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return r.Samples, nil
+}
+
+// Source provides a fixed list of records, in order.
+type Source []msimpact.Record
+
+func (s Source) Records(handler func(msimpact.Record) error) error {
+	for _, r := range s {
+		if err := handler(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sent is a message delivered to a fake sink.
+type Sent struct {
+	Key     string
+	Message []byte
+}
+
+// Sink collects the messages sent to it, it is safe for concurrent use. An error, if set, is returned by
+// every Send instead of collecting the message.
+type Sink struct {
+	Err error
+
+	sent   []Sent
+	closed bool
+	mu     sync.Mutex
+}
+
+func (s *Sink) Send(key string, msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return s.Err
+	}
+	s.sent = append(s.sent, Sent{Key: key, Message: append([]byte{}, msg...)})
+	return nil
+}
+
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+// Sent returns the messages collected so far.
+func (s *Sink) Sent() []Sent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Sent{}, s.sent...)
+}
+
+// Messages decodes the messages collected so far, the stream is taken from the message key.
+func (s *Sink) Messages() ([]msimpact.Message, error) {
+	var messages []msimpact.Message
+	for _, m := range s.Sent() {
+		var msg msimpact.Message
+		if err := json.Unmarshal(m.Message, &msg); err != nil {
+			return nil, err
+		}
+		msg.Stream = m.Key
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Closed checks whether the sink has been closed.
+func (s *Sink) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
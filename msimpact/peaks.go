@@ -0,0 +1,65 @@
+package msimpact
+
+import (
+	"fmt"
+	"math"
+)
+
+// time constant of the leaky integrator used to estimate velocity from acceleration, in seconds
+const peakLeak = 10.0
+
+// peakMeter finds the peak ground acceleration and velocity of each block of samples, integrating
+// or differentiating depending on the sensor type, keeping state between blocks.
+type peakMeter struct {
+	velocity bool
+	gain     float64
+
+	// the previous sample, in physical units, and the running integral
+	last, integral float64
+	primed         bool
+}
+
+func newPeakMeter(sensor string, gain float64) (*peakMeter, error) {
+	var velocity bool
+	switch sensor {
+	case "acceleration":
+	case "velocity":
+		velocity = true
+	default:
+		return nil, fmt.Errorf("unknown sensor type %q, expected acceleration or velocity", sensor)
+	}
+	if gain <= 0.0 {
+		return nil, fmt.Errorf("a positive gain is needed to measure peak ground motions")
+	}
+	return &peakMeter{velocity: velocity, gain: gain}, nil
+}
+
+// Measure returns the peak absolute acceleration, in m/s/s, and velocity, in m/s, of a block of samples.
+func (m *peakMeter) Measure(rate float64, samples []int32) (float64, float64) {
+	if rate <= 0.0 {
+		return 0.0, 0.0
+	}
+	dt := 1.0 / rate
+	leak := math.Max(0.0, 1.0-dt/peakLeak)
+
+	var pga, pgv float64
+	for _, s := range samples {
+		x := float64(s) / m.gain
+		if !m.primed {
+			m.last, m.primed = x, true
+		}
+
+		var a, v float64
+		if m.velocity {
+			a, v = (x-m.last)/dt, x
+		} else {
+			m.integral = m.integral*leak + 0.5*(x+m.last)*dt
+			a, v = x, m.integral
+		}
+		m.last = x
+
+		pga, pgv = math.Max(pga, math.Abs(a)), math.Max(pgv, math.Abs(v))
+	}
+
+	return pga, pgv
+}
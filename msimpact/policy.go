@@ -0,0 +1,62 @@
+package msimpact
+
+import "time"
+
+// FlushPolicy restricts which of the intensity changes found for a stream are sent, the zero value
+// sends every change. All-clear messages are always sent.
+type FlushPolicy struct {
+	// only send increases on the intensity last sent, e.g. for alerting
+	Increases bool
+
+	// with Increases, a lower intensity is sent once this long has passed since the last message, by record
+	// time, so the level can decay rather than staying at its peak, zero to never send a decrease
+	Reset time.Duration
+
+	// send at most one change per stream in this interval, by record time, a change held back is
+	// sent once the interval has passed if the intensity still differs, zero for no limit
+	Interval time.Duration
+
+	// only send messages at or above this intensity, zero for all, a stream's min_mmi setting overrides this
+	Threshold int32
+}
+
+// permit applies the flush policy to a message, given whether the stream itself would flush it.
+func (p *StreamProcessor) permit(srcname string, m *Message, flush bool) bool {
+	policy := p.options.Policy
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last, known := p.last[srcname]
+	if !flush && (!p.held[srcname] || !known || m.MMI == last.MMI || m.Time.Sub(last.Time) < policy.Interval) {
+		return false
+	}
+
+	threshold := policy.Threshold
+	if s := p.settings[srcname].MinMMI; s != nil {
+		threshold = *s
+	}
+	if threshold > 0 && m.MMI < threshold {
+		return false
+	}
+	if m.Heartbeat {
+		return true
+	}
+	if policy.Increases && known && m.MMI <= last.MMI {
+		switch {
+		case m.MMI == last.MMI || policy.Reset <= 0:
+			return false
+		case m.Time.Sub(last.Time) < policy.Reset:
+			// a decrease held back is sent once the reset has passed, if still lower
+			p.held[srcname] = true
+			return false
+		}
+	}
+	if policy.Interval > 0 && known && m.Time.Sub(last.Time) < policy.Interval {
+		p.held[srcname] = true
+		return false
+	}
+	delete(p.held, srcname)
+
+	return true
+}
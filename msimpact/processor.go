@@ -0,0 +1,673 @@
+package msimpact
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ozym/impact"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options are the processing settings shared by all streams, some may be overridden per stream in the config.
+type Options struct {
+	// noise probation window and suppression level
+	Probation time.Duration
+	Level     int32
+
+	// noise level, below Level, above which messages are flagged as possibly noisy, zero to disable
+	WarnLevel int32
+
+	// intensity assumed for each stream at startup, negative to disable
+	InitialMMI int32
+
+	// send an all-clear message when a stream returns to the baseline intensity
+	AllClear bool
+	Baseline int32
+
+	// use the current time rather than the recorded time
+	Replay bool
+
+	// resend the current intensity of each active stream this often, even without a change, zero to disable
+	Heartbeat time.Duration
+
+	// which changes in intensity are sent
+	Policy FlushPolicy
+
+	// alternative intensity scales to include in messages, e.g. ScaleJMA or ScaleEMS98
+	Scales []string
+
+	// the version of the program generating the messages, added to each message if set
+	Version string
+
+	// skip records with the same start time as any of this many recent records of a stream, zero to disable
+	Duplicates int
+
+	// optionally called with any gaps or overlaps between records, this may be called
+	// concurrently for different streams
+	Discontinuity func(Discontinuity)
+
+	// where to log stream changes, defaults to the slog default logger
+	Logger *slog.Logger
+
+	// the current time used for replayed messages, defaults to the system clock
+	Clock Clock
+}
+
+// MissingStreamError is returned the first time a record is found for a stream without any config.
+type MissingStreamError struct {
+	Stream string
+}
+
+func (e *MissingStreamError) Error() string {
+	return fmt.Sprintf("unable to find stream config: %s", e.Stream)
+}
+
+// DuplicateRecordError is returned for a record that has already been processed, e.g. from overlapping files.
+type DuplicateRecordError struct {
+	Stream string
+	Start  time.Time
+}
+
+func (e *DuplicateRecordError) Error() string {
+	return fmt.Sprintf("duplicate record: %s %s", e.Stream, e.Start.Format(time.RFC3339Nano))
+}
+
+// StreamProcessor keeps the impact state of each configured stream, wildcard entries in the config
+// are used as templates for streams created on first use. Records for different streams may be
+// processed concurrently, but each stream's records must be processed in turn.
+type StreamProcessor struct {
+	options Options
+
+	state     map[string]*impact.Stream
+	settings  map[string]StreamConfig
+	entries   map[string][]byte
+	templates map[string]*impact.Stream
+	instances map[string]string
+	missing   map[string]bool
+
+	shadows  map[string]*impact.Stream
+	filters  map[string]*streamFilter
+	meters   map[string]*peakMeter
+	gains    map[string]*gainCorrection
+	reducers map[string]*decimator
+	elevated map[string]bool
+
+	// paired horizontal components, keyed by each component, and the streams of their vector sums
+	pairs    map[string]*horizontalPair
+	combined map[string]bool
+
+	// the last intensity sent for each stream, and whether a later change was held back
+	last map[string]StreamState
+	held map[string]bool
+
+	// start times of the latest records of each stream, and when the next should start
+	recent   map[string][]time.Time
+	expected map[string]time.Time
+
+	// what was last seen of each stream, for monitoring
+	status map[string]*streamStatus
+
+	// fixup stream code for messaging
+	replace *strings.Replacer
+
+	log *slog.Logger
+
+	// guards the maps, not the individual stream state
+	mu sync.Mutex
+}
+
+// NewStreamProcessor initialises each stream given in a config.
+func NewStreamProcessor(options Options, config *Config) (*StreamProcessor, error) {
+	p := StreamProcessor{
+		options:   options,
+		state:     make(map[string]*impact.Stream),
+		settings:  config.Settings,
+		entries:   config.Entries,
+		templates: make(map[string]*impact.Stream),
+		instances: make(map[string]string),
+		missing:   make(map[string]bool),
+		shadows:   make(map[string]*impact.Stream),
+		filters:   make(map[string]*streamFilter),
+		meters:    make(map[string]*peakMeter),
+		gains:     make(map[string]*gainCorrection),
+		reducers:  make(map[string]*decimator),
+		elevated:  make(map[string]bool),
+		pairs:     make(map[string]*horizontalPair),
+		combined:  make(map[string]bool),
+		last:      make(map[string]StreamState),
+		held:      make(map[string]bool),
+		recent:    make(map[string][]time.Time),
+		expected:  make(map[string]time.Time),
+		status:    make(map[string]*streamStatus),
+		replace:   strings.NewReplacer("_", "."),
+		log:       options.Logger,
+	}
+	if p.log == nil {
+		p.log = slog.Default()
+	}
+	if p.options.Clock == nil {
+		p.options.Clock = SystemClock
+	}
+	if p.settings == nil {
+		p.settings = make(map[string]StreamConfig)
+	}
+
+	for s, stream := range config.Streams {
+		if IsWildcard(s) {
+			p.templates[s] = stream
+			continue
+		}
+		if err := p.setup(s, stream, p.settings[s]); err != nil {
+			return nil, err
+		}
+		p.state[s] = stream
+	}
+
+	return &p, nil
+}
+
+// Streams returns the configured stream names, and patterns, for requesting real-time or historic data.
+func (p *StreamProcessor) Streams() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var streams []string
+	for s := range p.state {
+		if _, ok := p.instances[s]; !ok && !p.combined[s] {
+			streams = append(streams, s)
+		}
+	}
+	for s := range p.templates {
+		streams = append(streams, s)
+	}
+	sort.Strings(streams)
+	return streams
+}
+
+// prepare a stream for processing
+func (p *StreamProcessor) setup(s string, stream *impact.Stream, c StreamConfig) error {
+	if c.TimeOffset != 0 {
+		p.log.Info("applying time offset", "stream", s, "offset", time.Duration(c.TimeOffset))
+	}
+
+	// the vector sum stream starts from the same, uninitialised, parameters
+	template := *stream
+
+	// noise probation settings, which noisy sites may need to change
+	probation, level := p.options.Probation, p.options.Level
+	if c.Probation != nil {
+		probation = time.Duration(*c.Probation)
+	}
+	if c.Level != nil {
+		level = *c.Level
+	}
+
+	// shadow streams are used to detect possibly noisy messages
+	warn := p.options.WarnLevel
+	if c.WarnLevel != nil {
+		warn = *c.WarnLevel
+	}
+	if warn > 0 {
+		shadow := *stream
+		if _, err := shadow.Init(s, probation, warn); err != nil {
+			return err
+		}
+		p.shadows[s] = &shadow
+	}
+
+	if _, err := stream.Init(s, probation, level); err != nil {
+		return err
+	}
+	p.status[s] = &streamStatus{probation: probation}
+
+	// streams recorded with a different sensitivity to the stream gain
+	if sensitivity := c.sensitivity(); sensitivity != 0.0 {
+		g, err := newGainCorrection(sensitivity, stream.Gain)
+		if err != nil {
+			return err
+		}
+		p.gains[s] = g
+	}
+
+	if err := checkDetrend(c.Detrend); err != nil {
+		return err
+	}
+
+	// streams needing filtering before processing
+	if c.Highpass > 0.0 || c.Lowpass > 0.0 {
+		p.filters[s] = newStreamFilter(c.Highpass, c.Lowpass)
+	}
+
+	// intensity scales must be known
+	if err := checkScales(p.scales(c)); err != nil {
+		return err
+	}
+
+	// high rate streams
+	if c.Decimate > 1 {
+		d, err := newDecimator(c.Decimate)
+		if err != nil {
+			return err
+		}
+		p.reducers[s] = d
+	}
+
+	// streams reporting peak ground motions
+	if c.Sensor != "" {
+		meter, err := newPeakMeter(c.Sensor, stream.Gain)
+		if err != nil {
+			return err
+		}
+		p.meters[s] = meter
+	}
+
+	// seed the previous intensity so the first record is not always a change
+	initial := p.options.InitialMMI
+	if c.InitialMMI != nil {
+		initial = *c.InitialMMI
+	}
+	if initial >= 0 {
+		stream.Flush(0, initial)
+		if shadow, ok := p.shadows[s]; ok {
+			shadow.Flush(0, initial)
+		}
+		if initial > p.options.Baseline {
+			p.elevated[s] = true
+		}
+		p.last[s] = StreamState{MMI: initial}
+	}
+
+	// horizontal components reported as their vector sum
+	if c.Horizontal != "" {
+		if err := p.pair(s, &template, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pair a horizontal component with its partner, building the stream used for their vector sum.
+func (p *StreamProcessor) pair(s string, stream *impact.Stream, c StreamConfig) error {
+	if _, ok := p.pairs[s]; ok {
+		return nil
+	}
+	i := strings.LastIndex(s, "_")
+	if i < 0 || c.Horizontal == "" || strings.Contains(c.Horizontal, "_") {
+		return fmt.Errorf("invalid horizontal channel %q for stream %s", c.Horizontal, s)
+	}
+	if s[:i+1]+c.Horizontal == s {
+		return fmt.Errorf("stream %s cannot be paired with itself", s)
+	}
+	if strings.Contains(c.Combined, "_") || c.Combined == c.Horizontal || s[:i+1]+c.Combined == s {
+		return fmt.Errorf("invalid combined channel %q for stream %s", c.Combined, s)
+	}
+	h := newHorizontalPair(horizontalName(s, c.Combined), s, s[:i+1]+c.Horizontal)
+	if _, ok := p.state[h.name]; ok && !p.combined[h.name] {
+		return fmt.Errorf("vector sum stream %s of %s and %s is already configured", h.name, h.first, h.last)
+	}
+
+	// the components have already been corrected and filtered
+	settings := StreamConfig{
+		Elevation:  c.Elevation,
+		Probation:  c.Probation,
+		Level:      c.Level,
+		WarnLevel:  c.WarnLevel,
+		InitialMMI: c.InitialMMI,
+		MinMMI:     c.MinMMI,
+		Sensor:     c.Sensor,
+		Scales:     c.Scales,
+	}
+	if err := p.setup(h.name, stream, settings); err != nil {
+		return err
+	}
+	p.state[h.name], p.settings[h.name], p.combined[h.name] = stream, settings, true
+	p.pairs[h.first], p.pairs[h.last] = h, h
+	p.log.Info("combining horizontal components", "stream", h.name, "first", h.first, "last", h.last)
+
+	return nil
+}
+
+// forget any stream processing state
+func (p *StreamProcessor) teardown(s string) {
+	delete(p.shadows, s)
+	delete(p.filters, s)
+	delete(p.meters, s)
+	delete(p.gains, s)
+	delete(p.reducers, s)
+	delete(p.elevated, s)
+	delete(p.last, s)
+	delete(p.held, s)
+	delete(p.status, s)
+
+	// a pair is broken up if either component changes
+	if h, ok := p.pairs[s]; ok {
+		delete(p.pairs, h.first)
+		delete(p.pairs, h.last)
+		delete(p.state, h.name)
+		delete(p.settings, h.name)
+		delete(p.combined, h.name)
+		p.teardown(h.name)
+	}
+}
+
+// build a stream from the first wildcard entry to match
+func (p *StreamProcessor) instantiate(srcname string) (*impact.Stream, error) {
+	var keys []string
+	for k := range p.templates {
+		keys = append(keys, k)
+	}
+	t, ok := MatchWildcard(WildcardPatterns(keys), srcname)
+	if !ok {
+		return nil, nil
+	}
+	stream := *p.templates[t]
+	if err := p.setup(srcname, &stream, p.settings[t]); err != nil {
+		return nil, err
+	}
+	p.state[srcname], p.settings[srcname], p.instances[srcname] = &stream, p.settings[t], t
+	p.log.Debug("initialising stream from wildcard", "stream", srcname, "pattern", t)
+	return &stream, nil
+}
+
+// Reload updates the stream config, keeping the state of any unchanged streams.
+func (p *StreamProcessor) Reload(config *Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	streams, extra, latest := make(map[string]*impact.Stream), config.Settings, config.Entries
+	if extra == nil {
+		extra = make(map[string]StreamConfig)
+	}
+
+	patterns := make(map[string]*impact.Stream)
+	for s, stream := range config.Streams {
+		if IsWildcard(s) {
+			patterns[s] = stream
+		} else {
+			streams[s] = stream
+		}
+	}
+
+	// an entry is unchanged if it was, and still is, given exactly the same way
+	unchanged := func(s string) bool {
+		a, ok := latest[s]
+		b, found := p.entries[s]
+		return ok && found && bytes.Equal(a, b)
+	}
+
+	for s := range p.state {
+		// keep streams built from unchanged wildcard entries, unless now given explicitly
+		if t, ok := p.instances[s]; ok {
+			_, exact := streams[s]
+			if _, found := patterns[t]; found && !exact && unchanged(t) {
+				extra[s] = extra[t]
+				continue
+			}
+			delete(p.instances, s)
+		} else if _, ok := streams[s]; ok || p.combined[s] {
+			continue
+		}
+		p.log.Info("removing stream", "stream", s)
+		delete(p.state, s)
+		p.teardown(s)
+	}
+	for s, stream := range streams {
+		if _, ok := p.state[s]; ok && unchanged(s) {
+			continue
+		}
+		p.log.Info("initialising stream", "stream", s)
+		p.teardown(s)
+		if err := p.setup(s, stream, extra[s]); err != nil {
+			return err
+		}
+		p.state[s] = stream
+	}
+
+	// restore pairs broken up by a change to only one component
+	for s := range p.state {
+		if c := extra[s]; c.Horizontal != "" && p.pairs[s] == nil {
+			template, ok := streams[s]
+			if !ok {
+				template, ok = patterns[p.instances[s]]
+			}
+			if !ok {
+				continue
+			}
+			stream := *template
+			if err := p.pair(s, &stream, c); err != nil {
+				return err
+			}
+		}
+	}
+	for s := range p.combined {
+		extra[s] = p.settings[s]
+	}
+
+	p.settings, p.entries, p.templates = extra, latest, patterns
+
+	// a new wildcard may now match
+	p.missing = make(map[string]bool)
+
+	return nil
+}
+
+// Process builds a message from a single decoded record, a nil message is returned if there is no change to send.
+func (p *StreamProcessor) Process(msr Record) (*Message, error) {
+	// what to send
+	source := strings.TrimRight(msr.Network()+"."+msr.Station(), "\u0000")
+
+	// block lookup key
+	srcname := msr.SrcName(0)
+
+	parts, err := p.lookup(srcname)
+	if parts == nil || err != nil {
+		return nil, err
+	}
+	stream, shadow, settings := parts.stream, parts.shadow, parts.settings
+
+	// overlapping input should not be processed twice
+	if p.options.Duplicates > 0 && p.duplicate(srcname, msr.Starttime()) {
+		return nil, &DuplicateRecordError{Stream: srcname, Start: msr.Starttime()}
+	}
+
+	// keep an eye on missing, or repeated, data
+	if p.options.Discontinuity != nil {
+		if d, ok := p.continuity(srcname, msr); ok {
+			p.options.Discontinuity(d)
+		}
+	}
+
+	// recover amplitude samples
+	samples, err := msr.DataSamples()
+	if err != nil {
+		return nil, fmt.Errorf("data sample problem: %s", err)
+	}
+
+	// apply any known clock correction
+	start := msr.Starttime().Add(time.Duration(settings.TimeOffset))
+
+	// allow for the instrument sensitivity
+	if parts.gain != nil {
+		samples = parts.gain.Apply(samples)
+	}
+
+	// remove any offset or drift
+	samples = detrend(settings.Detrend, samples)
+
+	// remove any unwanted frequencies
+	rate := msr.Samprate()
+	if parts.filter != nil {
+		samples = parts.filter.Apply(start, rate, samples)
+	}
+
+	// reduce high sample rates
+	if parts.reducer != nil {
+		if start, rate, samples = parts.reducer.Apply(start, rate, samples); len(samples) == 0 {
+			return nil, nil
+		}
+	}
+
+	// horizontal components are only reported through their vector sum
+	if parts.pair != nil {
+		return p.combine(parts.pair, msr, srcname, start, rate, samples)
+	}
+
+	// peak ground motions, if the sensor type is known
+	var pga, pgv float64
+	if parts.meter != nil {
+		pga, pgv = parts.meter.Measure(rate, samples)
+	}
+
+	// process each block into a message
+	message, err := stream.ProcessSamples(p.replace.Replace(source), srcname, start, samples)
+	if err != nil {
+		return nil, fmt.Errorf("data processing problem: %s", err)
+	}
+
+	// should we send a message .. on a change in MMI, or if a heartbeat is due
+	previous, known := p.previous(srcname)
+	flush := stream.Flush(p.options.Heartbeat, message.MMI)
+
+	output := Message{Message: message, Stream: srcname, Elevation: settings.Elevation, PGA: pga, PGV: pgv, Version: p.options.Version}
+	if flush && p.options.Heartbeat > 0 && known && previous == message.MMI {
+		output.Heartbeat = true
+	}
+
+	// would this have been suppressed at the warning level
+	if shadow != nil && !output.Heartbeat {
+		if m, err := shadow.ProcessSamples(p.replace.Replace(source), srcname, start, samples); err == nil {
+			if !shadow.Flush(0, m.MMI) && flush {
+				output.PossiblyNoisy = true
+			}
+		}
+	}
+
+	p.observe(srcname, start, message.MMI, output.PossiblyNoisy)
+
+	// apply any restrictions on what is sent
+	flush = p.permit(srcname, &output, flush)
+
+	addScales(&output, p.scales(settings))
+
+	// closing an event is always sent
+	if p.options.AllClear && p.closing(srcname, message.MMI) {
+		output.Type, flush = AllClear, true
+	}
+
+	if !flush {
+		return nil, nil
+	}
+	p.sent(srcname, &output)
+
+	if p.options.Replay {
+		output.Time = p.options.Clock.Now().Truncate(time.Second)
+	}
+
+	return &output, nil
+}
+
+// streamParts are the pieces used to process a single stream.
+type streamParts struct {
+	stream   *impact.Stream
+	shadow   *impact.Stream
+	filter   *streamFilter
+	meter    *peakMeter
+	gain     *gainCorrection
+	reducer  *decimator
+	pair     *horizontalPair
+	settings StreamConfig
+}
+
+// lookup finds, or builds, the state used to process a stream, nil parts without an error
+// indicates a stream already reported as missing.
+func (p *StreamProcessor) lookup(srcname string) (*streamParts, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// have we rejected this before?
+	if p.missing[srcname] {
+		return nil, nil
+	}
+	stream, ok := p.state[srcname]
+	if ok == false && len(p.templates) > 0 {
+		t, err := p.instantiate(srcname)
+		if err != nil {
+			return nil, fmt.Errorf("unable to initialise stream %s: %s", srcname, err)
+		}
+		stream, ok = t, t != nil
+	}
+	if ok == false {
+		p.missing[srcname] = true
+		return nil, &MissingStreamError{Stream: srcname}
+	}
+
+	return &streamParts{
+		stream:   stream,
+		shadow:   p.shadows[srcname],
+		filter:   p.filters[srcname],
+		meter:    p.meters[srcname],
+		gain:     p.gains[srcname],
+		reducer:  p.reducers[srcname],
+		pair:     p.pairs[srcname],
+		settings: p.settings[srcname],
+	}, nil
+}
+
+// combine adds the processed samples of a horizontal component to its pair, processing any vector sum
+// samples now available as a record of the combined stream.
+func (p *StreamProcessor) combine(h *horizontalPair, msr Record, srcname string, start time.Time, rate float64, samples []int32) (*Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start, combined, err := h.add(srcname, start, rate, samples)
+	if err != nil || len(combined) == 0 {
+		return nil, err
+	}
+	return p.Process(h.record(msr, start, rate, combined))
+}
+
+// closing tracks whether a stream is above the baseline intensity, returning true when it drops back.
+func (p *StreamProcessor) closing(srcname string, mmi int32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case mmi > p.options.Baseline:
+		p.elevated[srcname] = true
+	case p.elevated[srcname]:
+		delete(p.elevated, srcname)
+		return true
+	}
+	return false
+}
+
+// duplicate checks whether a record start time has recently been seen for a stream, remembering it if not.
+func (p *StreamProcessor) duplicate(srcname string, start time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	recent := p.recent[srcname]
+	for _, t := range recent {
+		if t.Equal(start) {
+			return true
+		}
+	}
+	if recent = append(recent, start); len(recent) > p.options.Duplicates {
+		recent = recent[len(recent)-p.options.Duplicates:]
+	}
+	p.recent[srcname] = recent
+
+	return false
+}
+
+// scales returns the intensity scales used for a stream.
+func (p *StreamProcessor) scales(c StreamConfig) []string {
+	if c.Scales != nil {
+		return c.Scales
+	}
+	return p.options.Scales
+}
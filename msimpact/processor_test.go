@@ -0,0 +1,225 @@
+package msimpact_test
+
+import (
+	"github.com/ozym/impact"
+	"github.com/ozym/msimpact/msimpact"
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"math"
+	"testing"
+	"time"
+)
+
+const (
+	testStream = "XX_TEST_10_HNZ"
+	testRate   = 100.0
+	testGain   = 1.0e6
+)
+
+var testStart = time.Date(2016, time.November, 13, 11, 0, 0, 0, time.UTC)
+
+// testRecords builds a minute of one second records, quiet apart from shaking that builds up from 20 seconds,
+// is strongest between 25 and 30 seconds, then dies away by 40 seconds, the amplitudes are in m/s before the gain.
+func testRecords() msimpacttest.Source {
+	return testComponent(testStream, 1.0)
+}
+
+// testComponent builds the test records for a stream, with the amplitudes scaled.
+func testComponent(stream string, scale float64) msimpacttest.Source {
+	var records msimpacttest.Source
+	for s := 0; s < 60; s++ {
+		level := -5.0
+		switch {
+		case s >= 20 && s < 25:
+			level = float64(s - 24)
+		case s >= 25 && s < 30:
+			level = 0.0
+		case s >= 30 && s < 40:
+			level = -0.5 * float64(s-29)
+		}
+		amplitude := scale * math.Pow(10.0, level)
+		samples := make([]int32, int(testRate))
+		for i := range samples {
+			t := float64(s) + float64(i)/testRate
+			samples[i] = int32(math.Round(testGain * amplitude * math.Sin(2.0*math.Pi*2.0*t)))
+		}
+		records = append(records, msimpacttest.NewRecord(stream, testStart.Add(time.Duration(s)*time.Second), testRate, samples))
+	}
+	return records
+}
+
+// testProcessor builds a processor for the single test stream.
+func testProcessor(t *testing.T, options msimpact.Options, settings msimpact.StreamConfig) *msimpact.StreamProcessor {
+	t.Helper()
+
+	config := msimpact.Config{
+		Streams: map[string]*impact.Stream{
+			testStream: {Name: "Test", Latitude: -41.0, Longitude: 174.5, Q: 0.98, Rate: testRate, Gain: testGain},
+		},
+		Settings: map[string]msimpact.StreamConfig{testStream: settings},
+	}
+	p, err := msimpact.NewStreamProcessor(options, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testRun passes the test records through a pipeline, returning the messages sent.
+func testRun(t *testing.T, p *msimpact.StreamProcessor) []msimpact.Message {
+	t.Helper()
+
+	return testRunSource(t, p, testRecords())
+}
+
+// testRunSource passes records through a pipeline, returning the messages sent.
+func testRunSource(t *testing.T, p *msimpact.StreamProcessor, records msimpacttest.Source) []msimpact.Message {
+	t.Helper()
+
+	sink := &msimpacttest.Sink{}
+	pipeline := msimpact.Pipeline{
+		Processor: p,
+		Sink:      sink,
+		Problem: func(msr msimpact.Record, err error) {
+			t.Errorf("%s at %s: %s", msr.SrcName(0), msr.Starttime(), err)
+		},
+	}
+	if err := pipeline.Run(records); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := sink.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestFlushPolicy(t *testing.T) {
+	minimum := int32(4)
+
+	every := testRun(t, testProcessor(t, msimpact.Options{InitialMMI: -1}, msimpact.StreamConfig{}))
+
+	tests := []struct {
+		name     string
+		policy   msimpact.FlushPolicy
+		settings msimpact.StreamConfig
+		check    func(previous, m msimpact.Message) bool
+	}{
+		{"every change", msimpact.FlushPolicy{}, msimpact.StreamConfig{}, func(previous, m msimpact.Message) bool {
+			return m.MMI != previous.MMI
+		}},
+		{"increases", msimpact.FlushPolicy{Increases: true}, msimpact.StreamConfig{}, func(previous, m msimpact.Message) bool {
+			return m.MMI > previous.MMI
+		}},
+		{"reset", msimpact.FlushPolicy{Increases: true, Reset: 10 * time.Second}, msimpact.StreamConfig{}, func(previous, m msimpact.Message) bool {
+			return m.MMI > previous.MMI || (m.MMI < previous.MMI && m.Time.Sub(previous.Time) >= 10*time.Second)
+		}},
+		{"interval", msimpact.FlushPolicy{Interval: 5 * time.Second}, msimpact.StreamConfig{}, func(previous, m msimpact.Message) bool {
+			return m.MMI != previous.MMI && m.Time.Sub(previous.Time) >= 5*time.Second
+		}},
+		{"threshold", msimpact.FlushPolicy{Threshold: 3}, msimpact.StreamConfig{}, func(previous, m msimpact.Message) bool {
+			return m.MMI >= 3
+		}},
+		{"min_mmi", msimpact.FlushPolicy{Threshold: 1}, msimpact.StreamConfig{MinMMI: &minimum}, func(previous, m msimpact.Message) bool {
+			return m.MMI >= minimum
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := testRun(t, testProcessor(t, msimpact.Options{InitialMMI: -1, Policy: tt.policy}, tt.settings))
+			if len(messages) == 0 {
+				t.Fatal("expected at least one message")
+			}
+			if len(messages) > len(every) {
+				t.Errorf("expected no more than the %d messages of every change, got %d", len(every), len(messages))
+			}
+			for i := 1; i < len(messages); i++ {
+				if !tt.check(messages[i-1], messages[i]) {
+					t.Errorf("unexpected message %d: MMI %d at %s after MMI %d at %s", i,
+						messages[i].MMI, messages[i].Time, messages[i-1].MMI, messages[i-1].Time)
+				}
+			}
+		})
+	}
+
+	// the shaking is both an increase and, later, a decrease
+	var up, down bool
+	for i := 1; i < len(every); i++ {
+		up, down = up || every[i].MMI > every[i-1].MMI, down || every[i].MMI < every[i-1].MMI
+	}
+	if !up || !down {
+		t.Errorf("expected the shaking to be sent as both an increase and a decrease")
+	}
+}
+
+func TestProbation(t *testing.T) {
+	short := msimpact.Duration(10 * time.Second)
+
+	tests := []struct {
+		name      string
+		options   msimpact.Options
+		settings  msimpact.StreamConfig
+		noisy     bool
+		probation time.Duration
+	}{
+		{"no warning level", msimpact.Options{Probation: time.Hour, Level: 10, InitialMMI: -1}, msimpact.StreamConfig{}, false, 0},
+		{"warning level", msimpact.Options{Probation: time.Hour, Level: 10, WarnLevel: 3, InitialMMI: -1}, msimpact.StreamConfig{}, true, time.Hour},
+		{"stream probation", msimpact.Options{Probation: time.Hour, Level: 10, WarnLevel: 3, InitialMMI: -1}, msimpact.StreamConfig{Probation: &short}, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testProcessor(t, tt.options, tt.settings)
+			messages := testRun(t, p)
+
+			var flagged bool
+			for _, m := range messages {
+				flagged = flagged || m.PossiblyNoisy
+			}
+			if flagged != (tt.options.WarnLevel > 0) {
+				t.Errorf("expected possibly noisy messages %v, got %v", tt.options.WarnLevel > 0, flagged)
+			}
+
+			status, ok := p.Status()[testStream]
+			if !ok {
+				t.Fatal("expected a stream status")
+			}
+			if status.Noisy != tt.noisy {
+				t.Errorf("expected noisy %v, got %v", tt.noisy, status.Noisy)
+			}
+			if status.Probation < 0 || status.Probation > tt.probation || (tt.noisy && status.Probation == 0) {
+				t.Errorf("expected up to %s probation remaining, got %s", tt.probation, status.Probation)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	now := time.Date(2030, time.January, 2, 3, 4, 5, 600000000, time.UTC)
+
+	tests := []struct {
+		name   string
+		replay bool
+	}{
+		{"record time", false},
+		{"replay time", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := msimpacttest.NewClock(now)
+			messages := testRun(t, testProcessor(t, msimpact.Options{InitialMMI: -1, Replay: tt.replay, Clock: clock}, msimpact.StreamConfig{}))
+			if len(messages) == 0 {
+				t.Fatal("expected at least one message")
+			}
+			for _, m := range messages {
+				switch {
+				case tt.replay && !m.Time.Equal(now.Truncate(time.Second)):
+					t.Errorf("expected the replay time %s, got %s", now.Truncate(time.Second), m.Time)
+				case !tt.replay && (m.Time.Before(testStart) || m.Time.After(testStart.Add(time.Minute))):
+					t.Errorf("expected a record time, got %s", m.Time)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package msimpact
+
+import "time"
+
+// Record is a decoded miniseed record, both libmseed version 2 records and
+// native miniseed 3 records provide these.
+type Record interface {
+	Network() string
+	Station() string
+	Location() string
+	Channel() string
+	SrcName(quality int) string
+	Starttime() time.Time
+	Samprate() float64
+	Samplecnt() int64
+	Encoding() int8
+	Byteorder() int8
+	DataSamples() ([]int32, error)
+}
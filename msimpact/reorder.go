@@ -0,0 +1,59 @@
+package msimpact
+
+import "sort"
+
+// reorderSource holds back up to depth records of each stream, releasing them in start time order,
+// so records arriving slightly out of order are still processed in turn.
+type reorderSource struct {
+	src   Source
+	depth int
+}
+
+func (r reorderSource) Records(handler func(Record) error) error {
+	var failed bool
+
+	held := make(map[string][]Record)
+	err := r.src.Records(func(msr Record) error {
+		s := Snapshot(msr)
+
+		queue := held[s.SrcName(0)]
+		i := sort.Search(len(queue), func(i int) bool {
+			return queue[i].Starttime().After(s.Starttime())
+		})
+		queue = append(queue, nil)
+		copy(queue[i+1:], queue[i:])
+		queue[i] = s
+
+		if len(queue) <= r.depth {
+			held[s.SrcName(0)] = queue
+			return nil
+		}
+
+		next := queue[0]
+		held[s.SrcName(0)] = append(queue[:0], queue[1:]...)
+		if err := handler(next); err != nil {
+			failed = true
+			return err
+		}
+		return nil
+	})
+	if failed {
+		return err
+	}
+
+	// anything still held is released once the source is finished
+	var keys []string
+	for k := range held {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, msr := range held[k] {
+			if err := handler(msr); err != nil {
+				return err
+			}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,49 @@
+package msimpact
+
+import (
+	"fmt"
+	"math"
+)
+
+// ResponseStage is a single gain stage of an instrument response, e.g. a sensor in volts
+// per m/s, or a datalogger in counts per volt.
+type ResponseStage struct {
+	Gain float64 `json:"gain"`
+}
+
+// sensitivity returns the overall counts per physical unit of a stream's instrument, either as given
+// or as the product of its response stages, zero if neither are configured.
+func (c StreamConfig) sensitivity() float64 {
+	if c.Sensitivity != 0.0 || len(c.Stages) == 0 {
+		return c.Sensitivity
+	}
+	s := 1.0
+	for _, stage := range c.Stages {
+		s *= stage.Gain
+	}
+	return s
+}
+
+// gainCorrection rescales samples recorded with one sensitivity to the gain expected by the impact stream.
+type gainCorrection struct {
+	scale float64
+}
+
+func newGainCorrection(sensitivity, gain float64) (*gainCorrection, error) {
+	if sensitivity <= 0.0 {
+		return nil, fmt.Errorf("invalid instrument sensitivity: %g", sensitivity)
+	}
+	if gain <= 0.0 {
+		return nil, fmt.Errorf("a positive stream gain is needed to correct for the instrument sensitivity")
+	}
+	return &gainCorrection{scale: gain / sensitivity}, nil
+}
+
+// Apply returns the rescaled samples, the originals are not changed.
+func (g *gainCorrection) Apply(samples []int32) []int32 {
+	out := make([]int32, len(samples))
+	for i, s := range samples {
+		out[i] = int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Floor(float64(s)*g.scale+0.5))))
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package msimpact
+
+import (
+	"fmt"
+	"math"
+)
+
+// alternative intensity scales that can be added to messages
+const (
+	ScaleJMA   = "jma"
+	ScaleEMS98 = "ems98"
+)
+
+// checkScales makes sure each scale is known.
+func checkScales(scales []string) error {
+	for _, s := range scales {
+		switch s {
+		case ScaleJMA, ScaleEMS98:
+		default:
+			return fmt.Errorf("unknown intensity scale %q, expected %s or %s", s, ScaleJMA, ScaleEMS98)
+		}
+	}
+	return nil
+}
+
+// jmaIntensity estimates the JMA instrumental intensity from the peak ground velocity, in m/s, using
+// the Midorikawa et al. (1999) relation, rounded to one decimal place and limited to the 0 to 7 range.
+func jmaIntensity(pgv float64) float64 {
+	if pgv <= 0.0 {
+		return 0.0
+	}
+	i := 2.68 + 1.72*math.Log10(pgv*100.0)
+	return math.Round(math.Min(7.0, math.Max(0.0, i))*10.0) / 10.0
+}
+
+// ems98Intensity converts an MMI, the two scales have been found to be equivalent in practice
+// (Musson et al. 2010), so this is only a change of name.
+func ems98Intensity(mmi int32) int32 {
+	return mmi
+}
+
+// addScales fills in any requested alternative intensities, JMA needs the peak velocity.
+func addScales(m *Message, scales []string) {
+	for _, s := range scales {
+		switch s {
+		case ScaleJMA:
+			if m.PGV > 0.0 {
+				v := jmaIntensity(m.PGV)
+				m.JMA = &v
+			}
+		case ScaleEMS98:
+			v := ems98Intensity(m.MMI)
+			m.EMS98 = &v
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package msimpact
+
+import "time"
+
+// snapshot is a copy of a decoded record, including its samples, which unlike a reused
+// libmseed record can be handed on to another goroutine.
+type snapshot struct {
+	network, station, location, channel string
+	srcname                             string
+	start                               time.Time
+	samprate                            float64
+	samplecnt                           int64
+	encoding, byteorder                 int8
+
+	samples []int32
+	err     error
+}
+
+// Snapshot copies a record, decoding its samples, so it remains valid after the original has been reused.
+func Snapshot(r Record) Record {
+	s := snapshot{
+		network:   r.Network(),
+		station:   r.Station(),
+		location:  r.Location(),
+		channel:   r.Channel(),
+		srcname:   r.SrcName(0),
+		start:     r.Starttime(),
+		samprate:  r.Samprate(),
+		samplecnt: r.Samplecnt(),
+		encoding:  r.Encoding(),
+		byteorder: r.Byteorder(),
+	}
+	s.samples, s.err = r.DataSamples()
+	return &s
+}
+
+func (s *snapshot) Network() string               { return s.network }
+func (s *snapshot) Station() string               { return s.station }
+func (s *snapshot) Location() string              { return s.location }
+func (s *snapshot) Channel() string               { return s.channel }
+func (s *snapshot) SrcName(quality int) string    { return s.srcname }
+func (s *snapshot) Starttime() time.Time          { return s.start }
+func (s *snapshot) Samprate() float64             { return s.samprate }
+func (s *snapshot) Samplecnt() int64              { return s.samplecnt }
+func (s *snapshot) Encoding() int8                { return s.encoding }
+func (s *snapshot) Byteorder() int8               { return s.byteorder }
+func (s *snapshot) DataSamples() ([]int32, error) { return s.samples, s.err }
@@ -0,0 +1,134 @@
+package msimpact
+
+import "time"
+
+// StreamState is the part of a stream's processing state that can be carried across restarts,
+// the intensity last sent and whether the stream was above the all-clear baseline.
+type StreamState struct {
+	MMI      int32
+	Time     time.Time
+	Elevated bool `json:",omitempty"`
+}
+
+// StreamStatus is a point in time view of a stream, for monitoring, noise is only flagged if a
+// warning level is set, and is kept for the probation window after the last flagged record.
+type StreamStatus struct {
+	Record    time.Time
+	MMI       int32
+	Sent      *StreamState `json:",omitempty"`
+	Noisy     bool
+	Probation time.Duration `json:",omitempty"`
+}
+
+// streamStatus is what has been seen of a stream since it was set up.
+type streamStatus struct {
+	record    time.Time
+	mmi       int32
+	noisy     time.Time
+	probation time.Duration
+}
+
+// observe notes the latest record processed for a stream and whether it was possibly noisy.
+func (p *StreamProcessor) observe(srcname string, start time.Time, mmi int32, noisy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.status[srcname]
+	if !ok {
+		return
+	}
+	s.record, s.mmi = start, mmi
+	if noisy {
+		s.noisy = start
+	}
+}
+
+// Status returns the current view of each stream that has processed a record, the probation remaining
+// is measured against the time of the latest record so it also makes sense for replays.
+func (p *StreamProcessor) Status() map[string]StreamStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := make(map[string]StreamStatus)
+	for k, s := range p.status {
+		if s.record.IsZero() {
+			continue
+		}
+		v := StreamStatus{Record: s.record, MMI: s.mmi}
+		if last, ok := p.last[k]; ok {
+			last.Elevated = p.elevated[k]
+			v.Sent = &last
+		}
+		if !s.noisy.IsZero() {
+			if remaining := s.noisy.Add(s.probation).Sub(s.record); remaining > 0 {
+				v.Noisy, v.Probation = true, remaining
+			}
+		}
+		status[k] = v
+	}
+	return status
+}
+
+// State returns the current state of each stream that has sent a message.
+func (p *StreamProcessor) State() map[string]StreamState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := make(map[string]StreamState)
+	for s, v := range p.last {
+		v.Elevated = p.elevated[s]
+		state[s] = v
+	}
+	return state
+}
+
+// Restore seeds streams with previously saved state, so the first record after a restart is not
+// always a change, streams no longer in the config are ignored.
+func (p *StreamProcessor) Restore(state map[string]StreamState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for s, v := range state {
+		stream, ok := p.state[s]
+		if !ok && len(p.templates) > 0 {
+			t, err := p.instantiate(s)
+			if err != nil {
+				return err
+			}
+			stream, ok = t, t != nil
+		}
+		if !ok {
+			continue
+		}
+
+		stream.Flush(0, v.MMI)
+		if shadow, ok := p.shadows[s]; ok {
+			shadow.Flush(0, v.MMI)
+		}
+		if v.Elevated {
+			p.elevated[s] = true
+		} else {
+			delete(p.elevated, s)
+		}
+		p.last[s] = StreamState{MMI: v.MMI, Time: v.Time}
+	}
+
+	return nil
+}
+
+// previous returns the intensity last sent for a stream, if any.
+func (p *StreamProcessor) previous(srcname string) (int32, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v, ok := p.last[srcname]
+	return v.MMI, ok
+}
+
+// sent notes the intensity sent for a stream.
+func (p *StreamProcessor) sent(srcname string, m *Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last[srcname] = StreamState{MMI: m.MMI, Time: m.Time}
+}
@@ -0,0 +1,95 @@
+package msimpact
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// IsWildcard checks whether a stream config key is a pattern rather than a stream name.
+func IsWildcard(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// WildcardPatterns returns the sorted wildcard keys of a config.
+func WildcardPatterns(keys []string) []string {
+	var patterns []string
+	for _, k := range keys {
+		if IsWildcard(k) {
+			patterns = append(patterns, k)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// MatchWildcard finds the first, in sorted order, pattern that matches a stream name.
+func MatchWildcard(patterns []string, name string) (string, bool) {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// WildcardRegexp converts the "*", "?" and "[...]" wildcards of a stream pattern, as used by path.Match,
+// to an unanchored regular expression, a class that is not closed is taken literally.
+func WildcardRegexp(pattern string) string {
+	var re strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*':
+			re.WriteString(".*")
+		case c == '?':
+			re.WriteString(".")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '[':
+			class, n, ok := wildcardClass(pattern[i+1:])
+			if !ok {
+				re.WriteString(regexp.QuoteMeta(pattern[i:]))
+				return re.String()
+			}
+			re.WriteString(class)
+			i += n
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return re.String()
+}
+
+// wildcardClass converts the contents of a character class, following the opening bracket, returning the
+// regular expression class and the number of bytes used, including the closing bracket.
+func wildcardClass(s string) (string, int, bool) {
+	var class strings.Builder
+	class.WriteByte('[')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '^' && i == 0:
+			class.WriteByte('^')
+		case c == ']' && i > 0 && !(i == 1 && s[0] == '^'):
+			class.WriteByte(']')
+			return class.String(), i + 1, true
+		case c == '-' && i > 0:
+			class.WriteByte('-')
+		case c == '\\' && i+1 < len(s):
+			i++
+			class.WriteString(classByte(s[i]))
+		default:
+			class.WriteString(classByte(c))
+		}
+	}
+	return "", 0, false
+}
+
+// classByte escapes a byte that is special inside a regular expression character class.
+func classByte(c byte) string {
+	if strings.IndexByte(`\[]^-`, c) >= 0 {
+		return `\` + string(c)
+	}
+	return string(c)
+}
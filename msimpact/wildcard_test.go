@@ -0,0 +1,43 @@
+package msimpact
+
+import (
+	"path"
+	"regexp"
+	"testing"
+)
+
+func TestWildcardRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"NZ_WEL_20_HNZ", `NZ_WEL_20_HNZ`},
+		{"NZ_*_20_HN?", `NZ_.*_20_HN.`},
+		{"NZ_WEL[12]_HNZ", `NZ_WEL[12]_HNZ`},
+		{"NZ_WEL_[0-9]0_HN[^Z]", `NZ_WEL_[0-9]0_HN[^Z]`},
+		{`NZ_WEL_20_HN[\-Z]`, `NZ_WEL_20_HN[\-Z]`},
+		{`NZ_WEL_20_HN[d\]]`, `NZ_WEL_20_HN[d\]]`},
+		{"NZ.WEL", `NZ\.WEL`},
+		{"NZ_WEL[12", `NZ_WEL\[12`},
+	}
+
+	for _, tt := range tests {
+		if got := WildcardRegexp(tt.pattern); got != tt.want {
+			t.Errorf("WildcardRegexp(%q): expected %q, got %q", tt.pattern, tt.want, got)
+		}
+	}
+
+	// both forms must agree on which streams match
+	for _, pattern := range []string{"NZ_WEL[12]_20_HNZ", "NZ_*_[0-9]0_HN[^Z]", "NZ_WEL?_??_HN[EN]"} {
+		re := regexp.MustCompile("^" + WildcardRegexp(pattern) + "$")
+		for _, name := range []string{"NZ_WEL1_20_HNZ", "NZ_WEL3_20_HNZ", "NZ_WEL_20_HNE", "NZ_WEL_20_HNZ", "NZ_WEL2_10_HNN", "NZ_WELL_1A_HNE"} {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if re.MatchString(name) != ok {
+				t.Errorf("%s: pattern %s matches %v, regular expression %v", name, pattern, ok, !ok)
+			}
+		}
+	}
+}
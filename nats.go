@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/nats-io/nats.go"
+)
+
+// natsSink publishes each message onto a nats subject, optionally via jetstream for persistence.
+type natsSink struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+}
+
+// newNatsSink connects to a nats server, the subject may be templated with stream codes,
+// e.g. "impact.{network}.{station}".
+func newNatsSink(url, subject string, jetstream bool) (*natsSink, error) {
+	conn, err := nats.Connect(url, nats.Name("msimpact"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &natsSink{
+		conn:    conn,
+		subject: subject,
+	}
+
+	if jetstream {
+		js, err := conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		n.js = js
+	}
+
+	return n, nil
+}
+
+func (n *natsSink) Send(key string, msg []byte) error {
+	subject := expandKey(n.subject, key)
+	if n.js != nil {
+		_, err := n.js.Publish(subject, msg)
+		return err
+	}
+	return n.conn.Publish(subject, msg)
+}
+
+func (n *natsSink) Close() error {
+	return n.conn.Drain()
+}
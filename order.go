@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// orderedItem is a buffered message with its sort time and key.
+type orderedItem struct {
+	Time time.Time
+	Key  string
+	Msg  json.RawMessage
+}
+
+// orderBuffer holds messages until flushed in time order, once the buffered
+// messages exceed the memory limit they are sorted and spilled to a temporary
+// file, the files are then merged back together when flushed.
+type orderBuffer struct {
+	limit int
+	dir   string
+
+	size  int
+	items []orderedItem
+	runs  []*os.File
+}
+
+// newOrderBuffer returns a buffer holding about limit bytes in memory, zero for no limit,
+// spill files are created in dir, or the default temporary directory if empty.
+func newOrderBuffer(limit int, dir string) *orderBuffer {
+	return &orderBuffer{
+		limit: limit,
+		dir:   dir,
+	}
+}
+
+func (o *orderBuffer) sort() {
+	sort.SliceStable(o.items, func(i, j int) bool {
+		return o.items[i].Time.Before(o.items[j].Time)
+	})
+}
+
+// Add buffers a message, spilling to disk if needed.
+func (o *orderBuffer) Add(at time.Time, key string, msg []byte) error {
+	o.items = append(o.items, orderedItem{Time: at, Key: key, Msg: append(json.RawMessage{}, msg...)})
+	o.size += len(msg)
+
+	if o.limit > 0 && o.size > o.limit {
+		return o.spill()
+	}
+
+	return nil
+}
+
+func (o *orderBuffer) spill() error {
+	o.sort()
+
+	file, err := os.CreateTemp(o.dir, "msimpact-order-")
+	if err != nil {
+		return err
+	}
+	o.runs = append(o.runs, file)
+
+	out := bufio.NewWriter(file)
+	enc := json.NewEncoder(out)
+	for _, i := range o.items {
+		if err := enc.Encode(i); err != nil {
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	o.items, o.size = nil, 0
+
+	return nil
+}
+
+// mergeSource is either a spilled run, or the in memory items.
+type mergeSource struct {
+	dec   *json.Decoder
+	items []orderedItem
+	order int
+	next  orderedItem
+}
+
+func (m *mergeSource) advance() (bool, error) {
+	if m.dec == nil {
+		if len(m.items) == 0 {
+			return false, nil
+		}
+		m.next, m.items = m.items[0], m.items[1:]
+		return true, nil
+	}
+	m.next = orderedItem{}
+	switch err := m.dec.Decode(&m.next); err {
+	case nil:
+		return true, nil
+	case io.EOF:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].next.Time.Equal(h[j].next.Time) {
+		return h[i].order < h[j].order
+	}
+	return h[i].next.Time.Before(h[j].next.Time)
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Flush passes every buffered message, in time order, to the emit function and empties the buffer.
+func (o *orderBuffer) Flush(emit func(string, []byte) error) error {
+	defer o.cleanup()
+
+	o.sort()
+
+	var sources []*mergeSource
+	for _, r := range o.runs {
+		sources = append(sources, &mergeSource{dec: json.NewDecoder(bufio.NewReader(r))})
+	}
+	sources = append(sources, &mergeSource{items: o.items})
+
+	h := &mergeHeap{}
+	for n, s := range sources {
+		s.order = n
+		ok, err := s.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Push(h, s)
+		}
+	}
+
+	for h.Len() > 0 {
+		s := (*h)[0]
+		if err := emit(s.next.Key, s.next.Msg); err != nil {
+			return err
+		}
+		ok, err := s.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	return nil
+}
+
+func (o *orderBuffer) cleanup() {
+	for _, r := range o.runs {
+		r.Close()
+		os.Remove(r.Name())
+	}
+	o.runs, o.items, o.size = nil, nil, 0
+}
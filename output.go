@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// outputSink keeps an eye on the encoded messages before passing them on, dropping any that
+// are too large, holding the latest of each stream over a rate limit until it can be sent, and if ordered,
+// holding them in a time ordered buffer until closed. It is safe for concurrent use.
+type outputSink struct {
+	next    msimpact.Sink
+	report  *summary
+	dead    *deadLetter
+	maxSize int
+	buffer  *orderBuffer
+	limit   *rateLimiter
+	verbose bool
+
+	// the latest rate limited message of each stream, and when to try sending them again
+	held  map[string]heldMessage
+	timer *time.Timer
+
+	sync.Mutex
+}
+
+func (o *outputSink) Send(key string, msg []byte) error {
+	o.Lock()
+	defer o.Unlock()
+
+	o.report.Messages++
+	metricMessages.Inc()
+	stats.Count("messages", 1)
+
+	var m struct {
+		Time time.Time
+		MMI  int32
+		Type string
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return err
+	}
+	if m.Type == "" {
+		if o.report.Intensity == nil {
+			o.report.Intensity = make(map[int32]int)
+		}
+		o.report.Intensity[m.MMI]++
+		o.report.Streams.Message(key, m.MMI)
+	}
+
+	// keep an eye on growing message sizes
+	o.report.Sizes.Add(len(msg))
+	if o.maxSize > 0 && len(msg) > o.maxSize {
+		slog.Warn("dropping oversize message", "stream", key, "bytes", len(msg))
+		o.report.Oversize++
+		return o.dead.Write(key, msg, "oversize")
+	}
+
+	// an all-clear is always sent, replacing any earlier state of the stream still held back
+	if m.Type == msimpact.AllClear {
+		if h, ok := o.held[key]; ok {
+			delete(o.held, key)
+			if err := o.drop(key, h); err != nil {
+				return err
+			}
+		}
+		return o.deliver(m.Time, key, msg)
+	}
+
+	// a flapping stream should not flood the outputs, but its latest state is still sent once the limits allow
+	now := time.Now()
+	if err := o.release(now); err != nil {
+		return err
+	}
+	if limit, ok := o.limit.allow(key, now); !ok {
+		return o.hold(key, heldMessage{at: m.Time, msg: msg, limit: limit}, now)
+	}
+
+	return o.deliver(m.Time, key, msg)
+}
+
+// heldMessage is a rate limited message waiting to be sent.
+type heldMessage struct {
+	at    time.Time
+	msg   []byte
+	limit string
+}
+
+// hold keeps a rate limited message as the latest of its stream, dropping any earlier message it replaces.
+func (o *outputSink) hold(key string, h heldMessage, now time.Time) error {
+	slog.Debug("holding rate limited message", "stream", key, "limit", h.limit)
+
+	var err error
+	if p, ok := o.held[key]; ok {
+		err = o.drop(key, p)
+	}
+	if o.held == nil {
+		o.held = make(map[string]heldMessage)
+	}
+	o.held[key] = h
+	o.schedule(now)
+
+	return err
+}
+
+// drop gives up on a rate limited message.
+func (o *outputSink) drop(key string, h heldMessage) error {
+	slog.Warn("dropping rate limited message", "stream", key, "limit", h.limit)
+	o.report.RateLimited++
+	metricRateLimited.WithLabelValues(h.limit).Inc()
+	stats.Count("messages.rate_limited."+h.limit, 1)
+	return o.dead.Write(key, h.msg, "rate-limited")
+}
+
+// heldKeys returns the streams with held messages, oldest message first.
+func (o *outputSink) heldKeys() []string {
+	keys := make([]string, 0, len(o.held))
+	for k := range o.held {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return o.held[keys[i]].at.Before(o.held[keys[j]].at)
+	})
+	return keys
+}
+
+// release sends any held messages the rate limits now allow.
+func (o *outputSink) release(now time.Time) error {
+	if len(o.held) == 0 {
+		return nil
+	}
+	for _, k := range o.heldKeys() {
+		if _, ok := o.limit.allow(k, now); !ok {
+			continue
+		}
+		h := o.held[k]
+		delete(o.held, k)
+		if err := o.deliver(h.at, k, h.msg); err != nil {
+			return err
+		}
+	}
+	o.schedule(now)
+	return nil
+}
+
+// schedule tries the held messages again once the first of them would be allowed.
+func (o *outputSink) schedule(now time.Time) {
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if len(o.held) == 0 {
+		return
+	}
+
+	wait := time.Duration(math.MaxInt64)
+	for k := range o.held {
+		if w := o.limit.wait(k, now); w < wait {
+			wait = w
+		}
+	}
+	if wait < time.Millisecond {
+		wait = time.Millisecond
+	}
+	o.timer = time.AfterFunc(wait, func() {
+		o.Lock()
+		defer o.Unlock()
+
+		if err := o.release(time.Now()); err != nil {
+			slog.Warn("unable to send rate limited message", "error", err)
+		}
+	})
+}
+
+// deliver passes a message on, or adds it to any ordered buffer.
+func (o *outputSink) deliver(at time.Time, key string, msg []byte) error {
+	if o.buffer != nil {
+		return o.buffer.Add(at, key, msg)
+	}
+	return o.send(key, msg)
+}
+
+// deliver an encoded message
+func (o *outputSink) send(key string, msg []byte) error {
+	if o.verbose {
+		fmt.Println(string(msg))
+	}
+	return o.next.Send(key, msg)
+}
+
+func (o *outputSink) Close() error {
+	o.Lock()
+	defer o.Unlock()
+
+	// the latest state of each stream is sent, even if still over a limit
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	for _, k := range o.heldKeys() {
+		h := o.held[k]
+		delete(o.held, k)
+		if err := o.deliver(h.at, k, h.msg); err != nil {
+			return err
+		}
+	}
+
+	if o.buffer != nil {
+		if err := o.buffer.Flush(o.send); err != nil {
+			return err
+		}
+	}
+	return o.next.Close()
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/ozym/msimpact/msimpact"
+)
+
+// outputs builds each output given on the command line, wrapping it with any signing, encoding and filtering.
+type outputs struct {
+	s      *settings
+	ctx    context.Context
+	cfg    aws.Config
+	dead   *deadLetter
+	signer *messageSigner
+
+	sinks *fanout
+}
+
+// newOutputs builds the outputs, only real-time inputs need protecting from a slow output, files
+// can simply be read more slowly, a dry run has none.
+func newOutputs(ctx context.Context, s *settings, cfg aws.Config, dead *deadLetter, signer *messageSigner) (*fanout, error) {
+	o := outputs{
+		s:      s,
+		ctx:    ctx,
+		cfg:    cfg,
+		dead:   dead,
+		signer: signer,
+		sinks:  &fanout{dead: dead, wait: s.seedlink == "" && s.datalink == "" && !s.follow},
+	}
+	if err := o.build(); err != nil {
+		return nil, err
+	}
+	return o.sinks, nil
+}
+
+// add starts delivery to an output, encoded as given by -sink-format or -format.
+func (o *outputs) add(name string, sink msimpact.Sink) error {
+	f, ok := o.s.sinkFormat[name]
+	if !ok {
+		f = o.s.format
+	}
+	sink, err := signSink(name, f, sink, o.signer)
+	if err != nil {
+		return err
+	}
+	if sink, err = encodeSink(name, f, sink, capOptions{Sender: o.s.capSender, MMI: int32(o.s.capMMI), Radius: o.s.capRadius}); err != nil {
+		return err
+	}
+	return o.sinks.Add(name, sink, o.s.sinkMMI[name], o.s.sinkStreams[name])
+}
+
+// addBatch starts delivery to an output that may be batched, so each message is only counted once its batch has gone.
+func (o *outputs) addBatch(name string, sink msimpact.Sink, batch *batchSink) error {
+	if err := o.add(name, sink); err != nil {
+		return err
+	}
+	if batch == nil {
+		return nil
+	}
+	return o.sinks.Holding(name, batch)
+}
+
+func (o *outputs) build() error {
+	s := o.s
+
+	if s.fifoDedup != "time" && s.fifoDedup != "content" {
+		return fmt.Errorf("unknown fifo deduplication method: %s", s.fifoDedup)
+	}
+	if s.dryrun {
+		return nil
+	}
+
+	// configure amazon data stream ...
+	if s.kinesisStream != "" {
+		if err := o.add("kinesis", newRetrySink(newRefreshSink(newKinesisSink(o.ctx, kinesis.NewFromConfig(o.cfg), s.kinesisStream), o.cfg.Credentials), s.retryAttempts, s.retryElapsed, s.retryDelay)); err != nil {
+			return err
+		}
+	}
+
+	// configure amazon topic ...
+	if s.topic != "" {
+		r, ok := topicRegion(s.topic)
+		if !ok {
+			return fmt.Errorf("unable to find region in topic arn %s", s.topic)
+		}
+		client := sns.NewFromConfig(o.cfg, func(opts *sns.Options) {
+			opts.Region = r
+		})
+		if err := o.add("sns", newRetrySink(newRefreshSink(&snsSink{ctx: o.ctx, client: client, topic: s.topic, signer: o.signer}, o.cfg.Credentials), s.retryAttempts, s.retryElapsed, s.retryDelay)); err != nil {
+			return err
+		}
+	}
+
+	// configure amazon ...
+	if s.queue != "" {
+		out, batch, err := o.sqs()
+		if err != nil {
+			return err
+		}
+		if err := o.addBatch("sqs", out, batch); err != nil {
+			return err
+		}
+	}
+
+	// configure kafka ...
+	if s.kafkaBrokers != "" {
+		K, err := newKafkaSink(s.kafkaBrokers, s.kafkaTopic, s.kafkaKey, s.kafkaAcks)
+		if err != nil {
+			return err
+		}
+		if err := o.add("kafka", K); err != nil {
+			return err
+		}
+	}
+
+	// configure nats ...
+	if s.natsURL != "" {
+		N, err := newNatsSink(s.natsURL, s.natsSubject, s.natsJetStream)
+		if err != nil {
+			return err
+		}
+		if err := o.add("nats", N); err != nil {
+			return err
+		}
+	}
+
+	// configure mqtt ...
+	if s.mqttBroker != "" {
+		var config *tls.Config
+		if s.mqttCA != "" || s.mqttCert != "" {
+			c, err := mqttTLS(s.mqttCA, s.mqttCert, s.mqttKey)
+			if err != nil {
+				return err
+			}
+			config = c
+		}
+		M, err := newMqttSink(s.mqttBroker, s.mqttTopic, s.mqttQoS, config)
+		if err != nil {
+			return err
+		}
+		if err := o.add("mqtt", M); err != nil {
+			return err
+		}
+	}
+
+	// configure webhook ...
+	if s.webhookURL != "" {
+		W := newWebhookSink(s.webhookURL, s.webhookHeaders, s.webhookTimeout)
+		W.signer = o.signer
+		var out msimpact.Sink = newRetrySink(W, s.retryAttempts, s.retryElapsed, s.retryDelay)
+		var batch *batchSink
+		if s.webhookBatch > 0 {
+			batch = newArraySink(out, s.webhookBatch, 0, s.batchInterval)
+			out = batch
+		}
+		if err := o.addBatch("webhook", out, batch); err != nil {
+			return err
+		}
+	}
+
+	// configure local socket ...
+	if s.unixSocket != "" {
+		U, err := newUnixSink(s.unixSocket, s.unixListen)
+		if err != nil {
+			return err
+		}
+		// a listening socket drops slow clients rather than failing
+		var out msimpact.Sink = U
+		if !s.unixListen {
+			out = newRetrySink(U, s.retryAttempts, s.retryElapsed, s.retryDelay)
+		}
+		if err := o.add("unix", out); err != nil {
+			return err
+		}
+	}
+
+	// configure websocket server ...
+	if s.serveWS != "" {
+		W, err := newWebsocketSink(s.serveWS)
+		if err != nil {
+			return err
+		}
+		if err := o.add("websocket", W); err != nil {
+			return err
+		}
+	}
+
+	// configure grpc server, always sent as protobuf Impact messages so the format and signing are ignored ...
+	if s.serveGRPC != "" {
+		G, err := newGrpcSink(s.serveGRPC)
+		if err != nil {
+			return err
+		}
+		if err := o.sinks.Add("grpc", G, s.sinkMMI["grpc"], s.sinkStreams["grpc"]); err != nil {
+			return err
+		}
+	}
+
+	// configure local file, which is skipped by a dry run as for the network outputs ...
+	if s.outFile != "" {
+		F, err := newFileSink(s.outFile, s.outMaxBytes, s.outDaily)
+		if err != nil {
+			return err
+		}
+		if err := o.add("file", F); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqs builds the queue output, with any failover queue, spool and batching, the batch is
+// returned separately so its messages can be counted once sent.
+func (o *outputs) sqs() (msimpact.Sink, *batchSink, error) {
+	s := o.s
+
+	client, queue, err := openQueue(o.ctx, s, o.cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// with a secondary queue, a failing message is passed on sooner
+	attempts := s.retryAttempts
+	if s.failoverQueue != "" {
+		attempts = s.failoverAttempts
+	}
+	var out msimpact.Sink = newRetrySink(newRefreshSink(&sqsSink{ctx: o.ctx, client: client, queue: queue, fifo: isFifoQueue(queue), dedup: s.fifoDedup, signer: o.signer}, o.cfg.Credentials), attempts, s.retryElapsed, s.retryDelay)
+	if s.failoverQueue != "" {
+		region := s.failoverRegion
+		if r, ok := queueRegion(s.failoverQueue); ok {
+			region = r
+		}
+		if region == "" {
+			return nil, nil, fmt.Errorf("unable to find the region of the failover queue %s, use -failover-region", s.failoverQueue)
+		}
+		F := sqs.NewFromConfig(o.cfg, func(opts *sqs.Options) {
+			opts.Region = region
+		})
+		failover := s.failoverQueue
+		if !isQueueURL(failover) {
+			input := sqs.GetQueueUrlInput{QueueName: aws.String(failover)}
+			if s.queueOwner != "" {
+				input.QueueOwnerAWSAccountId = aws.String(s.queueOwner)
+			}
+			resp, err := F.GetQueueUrl(o.ctx, &input)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to find failover queue %s: %s", failover, err)
+			}
+			failover = aws.ToString(resp.QueueUrl)
+		}
+		secondary := newRetrySink(newRefreshSink(&sqsSink{ctx: o.ctx, client: F, queue: failover, fifo: isFifoQueue(failover), dedup: s.fifoDedup, signer: o.signer}, o.cfg.Credentials), s.retryAttempts, s.retryElapsed, s.retryDelay)
+		out = newFailoverSink(out, secondary, s.failoverThreshold, s.failbackInterval)
+	}
+	if s.spoolDir != "" {
+		spool, err := newSpoolSink(out, s.spoolDir, s.spoolBytes, s.spoolAge, s.spoolInterval, "sqs", o.dead)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = spool
+	}
+
+	var batch *batchSink
+	if s.batchSize > 0 {
+		batch = newBatchSink(out, s.batchSize, s.maxSize, s.batchInterval)
+		out = batch
+	}
+
+	return out, batch, nil
+}
+
+// openQueue returns a client for the queue along with its url, creating the queue if asked to.
+func openQueue(ctx context.Context, s *settings, cfg aws.Config) (*sqs.Client, string, error) {
+	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if s.sqsEndpoint != "" {
+			o.BaseEndpoint = aws.String(s.sqsEndpoint)
+		}
+	})
+
+	switch {
+	case s.queueCreate:
+		attrs, err := parseQueueAttributes(s.queueAttributes)
+		if err != nil {
+			return nil, "", err
+		}
+		url, err := createQueue(ctx, client, s.queue, s.queueOwner, attrs)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to create queue %s: %s", s.queue, err)
+		}
+		return client, url, nil
+	case !isQueueURL(s.queue):
+		input := sqs.GetQueueUrlInput{QueueName: aws.String(s.queue)}
+		if s.queueOwner != "" {
+			input.QueueOwnerAWSAccountId = aws.String(s.queueOwner)
+		}
+		resp, err := client.GetQueueUrl(ctx, &input)
+		if err != nil {
+			return nil, "", err
+		}
+		return client, aws.ToString(resp.QueueUrl), nil
+	default:
+		return client, s.queue, nil
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		outputs []string
+		wait    bool
+		err     bool
+	}{
+		{"file", []string{"-out", "out.jsonl"}, []string{"file"}, true, false},
+		{"dry run", []string{"-out", "out.jsonl", "-dry-run"}, nil, true, false},
+		{"followed", []string{"-out", "out.jsonl", "-follow"}, []string{"file"}, false, false},
+		{"sink format", []string{"-out", "out.jsonl", "-sink-format", "file=geojson"}, []string{"file"}, true, false},
+		{"unknown format", []string{"-out", "out.jsonl", "-sink-format", "file=xml"}, nil, false, true},
+		{"unknown dedup", []string{"-out", "out.jsonl", "-fifo-dedup", "never"}, nil, false, true},
+		{"sink threshold", []string{"-out", "out.jsonl", "-sink-mmi", "file=x"}, nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s settings
+			fs := flag.NewFlagSet("msimpact", flag.ContinueOnError)
+			s.register(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			s.outFile = filepath.Join(t.TempDir(), s.outFile)
+
+			sinks, err := newOutputs(context.Background(), &s, aws.Config{}, nil, nil)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sinks.Close()
+
+			if len(sinks.outputs) != len(tt.outputs) {
+				t.Fatalf("expected %d outputs, got %d", len(tt.outputs), len(sinks.outputs))
+			}
+			for i, o := range sinks.outputs {
+				if o.name != tt.outputs[i] {
+					t.Errorf("expected output %s, got %s", tt.outputs[i], o.name)
+				}
+			}
+			if sinks.wait != tt.wait {
+				t.Errorf("expected wait %v, got %v", tt.wait, sinks.wait)
+			}
+		})
+	}
+}
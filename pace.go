@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pacer delays records so they are processed at a multiple of the rate they were recorded, the first
+// record sets the starting point. Records earlier than any seen before are not delayed, so for more
+// realistic timing the records should be read in time order, e.g. from multiplexed files.
+type pacer struct {
+	speed float64
+
+	mu      sync.Mutex
+	first   time.Time
+	started time.Time
+}
+
+func newPacer(speed float64) *pacer {
+	return &pacer{speed: speed}
+}
+
+// wait blocks until a record starting at the given time is due, it returns false if stop was closed first.
+func (p *pacer) wait(at time.Time, stop <-chan struct{}) bool {
+	if p == nil || p.speed <= 0.0 {
+		return true
+	}
+
+	p.mu.Lock()
+	if p.first.IsZero() {
+		p.first, p.started = at, clock.Now()
+	}
+	due := p.started.Add(time.Duration(float64(at.Sub(p.first)) / p.speed))
+	p.mu.Unlock()
+
+	delay := due.Sub(clock.Now())
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseFlushPolicy decodes a comma separated list of flush rules: "change" to send any change,
+// "increase" to only send increases, "reset=5m" to send a decrease after an interval without an increase,
+// "every=30s" to send at most one change per interval, and "min=4" to only send messages at or above
+// an intensity, e.g. "increase,reset=10m,min=3".
+func parseFlushPolicy(s string) (msimpact.FlushPolicy, error) {
+	var policy msimpact.FlushPolicy
+	for _, rule := range strings.Split(s, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "", "change":
+		case "increase":
+			policy.Increases = true
+		case "reset":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return policy, fmt.Errorf("invalid flush reset %q", value)
+			}
+			policy.Reset = d
+		case "every":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return policy, fmt.Errorf("invalid flush interval %q", value)
+			}
+			policy.Interval = d
+		case "min":
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 0 {
+				return policy, fmt.Errorf("invalid flush threshold %q", value)
+			}
+			policy.Threshold = int32(v)
+		default:
+			return policy, fmt.Errorf("unknown flush rule %q", rule)
+		}
+	}
+	if policy.Reset > 0 && !policy.Increases {
+		return policy, fmt.Errorf("the flush reset rule only applies with increase")
+	}
+	return policy, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progress tracks how far through a list of files a run is, it is safe for concurrent use and a nil progress is ignored.
+type progress struct {
+	started time.Time
+
+	files, bytes int64
+	doneFiles    int64
+	doneBytes    int64
+	records      int64
+}
+
+// newProgress finds the total size of the files, standard input, or a file that can't be found, is taken as empty.
+func newProgress(files []string) *progress {
+	p := progress{started: time.Now(), files: int64(len(files))}
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && f != stdinName {
+			p.bytes += info.Size()
+		}
+	}
+	metricProgressFiles.WithLabelValues("total").Set(float64(p.files))
+	metricProgressBytes.WithLabelValues("total").Set(float64(p.bytes))
+	return &p
+}
+
+// Record counts a record read.
+func (p *progress) Record() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.records, 1)
+}
+
+// Done notes a file has been read.
+func (p *progress) Done(file string) {
+	if p == nil {
+		return
+	}
+	var size int64
+	if info, err := os.Stat(file); err == nil && file != stdinName {
+		size = info.Size()
+	}
+	metricProgressFiles.WithLabelValues("done").Set(float64(atomic.AddInt64(&p.doneFiles, 1)))
+	metricProgressBytes.WithLabelValues("done").Set(float64(atomic.AddInt64(&p.doneBytes, size)))
+}
+
+// Log reports the progress so far, with an estimate of the time left based on the bytes read.
+func (p *progress) Log() {
+	elapsed := time.Since(p.started)
+	files, bytes, records := atomic.LoadInt64(&p.doneFiles), atomic.LoadInt64(&p.doneBytes), atomic.LoadInt64(&p.records)
+
+	args := []interface{}{"files", files, "total_files", p.files, "bytes", bytes, "total_bytes", p.bytes, "records", records}
+	if elapsed > 0 {
+		args = append(args, "records_per_second", int64(float64(records)/elapsed.Seconds()))
+	}
+	if bytes > 0 && p.bytes > bytes {
+		eta := time.Duration(float64(elapsed) * float64(p.bytes-bytes) / float64(bytes))
+		args = append(args, "eta", eta.Truncate(time.Second))
+	}
+	slog.Info("progress", args...)
+}
+
+// Run logs the progress at each interval until stop is closed.
+func (p *progress) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.Log()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/ozym/msimpact/msimpact"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"time"
+)
+
+// impactFields are all the fields that may be found in an encoded message, see msimpact.proto.
+type impactFields struct {
+	Source        string
+	Quality       string
+	Latitude      float64
+	Longitude     float64
+	Time          time.Time
+	MMI           int32
+	Comment       string
+	Type          string
+	PossiblyNoisy bool
+	Heartbeat     bool
+	PGA           float64
+	PGV           float64
+	JMA           *float64
+	EMS98         *int32
+	Stream        string
+	Duration      float64
+	Elevation     *float64
+	Version       string
+}
+
+// encodeProtobuf converts a JSON message to the protocol buffer wire format, the stream
+// is taken from the key if not in the message itself.
+func encodeProtobuf(key string, msg []byte) ([]byte, error) {
+	var m impactFields
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, err
+	}
+	if m.Stream == "" {
+		m.Stream = key
+	}
+
+	var b []byte
+	str := func(n protowire.Number, v string) {
+		if v != "" {
+			b = protowire.AppendString(protowire.AppendTag(b, n, protowire.BytesType), v)
+		}
+	}
+	dbl := func(n protowire.Number, v float64) {
+		if v != 0.0 {
+			b = protowire.AppendFixed64(protowire.AppendTag(b, n, protowire.Fixed64Type), math.Float64bits(v))
+		}
+	}
+	varint := func(n protowire.Number, v uint64) {
+		if v != 0 {
+			b = protowire.AppendVarint(protowire.AppendTag(b, n, protowire.VarintType), v)
+		}
+	}
+
+	str(1, m.Source)
+	str(2, m.Quality)
+	dbl(3, m.Latitude)
+	dbl(4, m.Longitude)
+	if !m.Time.IsZero() {
+		var ts []byte
+		if s := m.Time.Unix(); s != 0 {
+			ts = protowire.AppendVarint(protowire.AppendTag(ts, 1, protowire.VarintType), uint64(s))
+		}
+		if n := m.Time.Nanosecond(); n != 0 {
+			ts = protowire.AppendVarint(protowire.AppendTag(ts, 2, protowire.VarintType), uint64(n))
+		}
+		b = protowire.AppendBytes(protowire.AppendTag(b, 5, protowire.BytesType), ts)
+	}
+	varint(6, uint64(int64(m.MMI)))
+	str(7, m.Comment)
+	str(8, m.Type)
+	varint(9, protowire.EncodeBool(m.PossiblyNoisy))
+	varint(10, protowire.EncodeBool(m.Heartbeat))
+	dbl(11, m.PGA)
+	dbl(12, m.PGV)
+	// optional fields are sent even when zero
+	if m.JMA != nil {
+		b = protowire.AppendFixed64(protowire.AppendTag(b, 13, protowire.Fixed64Type), math.Float64bits(*m.JMA))
+	}
+	if m.EMS98 != nil {
+		b = protowire.AppendVarint(protowire.AppendTag(b, 14, protowire.VarintType), uint64(int64(*m.EMS98)))
+	}
+	str(15, m.Stream)
+	dbl(16, m.Duration)
+	if m.Elevation != nil {
+		b = protowire.AppendFixed64(protowire.AppendTag(b, 17, protowire.Fixed64Type), math.Float64bits(*m.Elevation))
+	}
+	str(18, m.Version)
+
+	return b, nil
+}
+
+// protobufSink re-encodes each JSON message as a protocol buffer before passing it on,
+// outputs that only carry text are given the base64 encoding of the message.
+type protobufSink struct {
+	msimpact.Sink
+	text bool
+}
+
+func (p *protobufSink) Send(key string, msg []byte) error {
+	b, err := encodeProtobuf(key, msg)
+	if err != nil {
+		return err
+	}
+	if p.text {
+		b = []byte(base64.StdEncoding.EncodeToString(b))
+	}
+	return p.Sink.Send(key, b)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// isQueueURL checks whether the queue has been given as a full url rather than a name.
+func isQueueURL(queue string) bool {
+	return strings.HasPrefix(queue, "https://") || strings.HasPrefix(queue, "http://")
+}
+
+// queueRegion extracts the region embedded in an SQS queue url hostname, either of the
+// form sqs.<region>.amazonaws.com, the legacy <region>.queue.amazonaws.com, or a VPC interface endpoint
+// vpce-<id>.sqs.<region>.vpce.amazonaws.com, other hosts, e.g. a local test server, have no region.
+func queueRegion(queue string) (string, bool) {
+	if !isQueueURL(queue) {
+		return "", false
+	}
+	u, err := url.Parse(queue)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	switch {
+	case len(parts) > 3 && parts[0] == "sqs" && parts[2] == "amazonaws":
+		return parts[1], true
+	case len(parts) > 3 && parts[1] == "queue" && parts[2] == "amazonaws":
+		return parts[0], true
+	case len(parts) > 5 && strings.HasPrefix(parts[0], "vpce-") && parts[1] == "sqs" && parts[3] == "vpce":
+		return parts[2], true
+	default:
+		return "", false
+	}
+}
+
+// queueName returns the name of a queue given either by name or url.
+func queueName(queue string) string {
+	if !isQueueURL(queue) {
+		return queue
+	}
+	u, err := url.Parse(queue)
+	if err != nil {
+		return queue
+	}
+	return path.Base(u.Path)
+}
+
+// parseQueueAttributes decodes a comma separated list of name=value queue attributes.
+func parseQueueAttributes(list string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, a := range strings.Split(list, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected a name=value queue attribute, got %q", a)
+		}
+		attrs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return attrs, nil
+}
+
+// createQueue creates a queue if it does not already exist, returning its url, a fifo queue is
+// recognised by its name.
+func createQueue(ctx context.Context, client *sqs.Client, queue, owner string, attrs map[string]string) (string, error) {
+	input := sqs.GetQueueUrlInput{QueueName: aws.String(queueName(queue))}
+	if owner != "" {
+		input.QueueOwnerAWSAccountId = aws.String(owner)
+	}
+	resp, err := client.GetQueueUrl(ctx, &input)
+	if err == nil {
+		return aws.ToString(resp.QueueUrl), nil
+	}
+	var missing *types.QueueDoesNotExist
+	if !errors.As(err, &missing) {
+		return "", err
+	}
+	if owner != "" {
+		return "", fmt.Errorf("unable to create queue %s owned by another account", queue)
+	}
+
+	create := sqs.CreateQueueInput{
+		QueueName:  aws.String(queueName(queue)),
+		Attributes: make(map[string]string),
+	}
+	for k, v := range attrs {
+		create.Attributes[k] = v
+	}
+	if isFifoQueue(queue) {
+		create.Attributes[string(types.QueueAttributeNameFifoQueue)] = "true"
+	}
+	out, err := client.CreateQueue(ctx, &create)
+	if err != nil {
+		return "", err
+	}
+	slog.Info("created queue", "queue", aws.ToString(out.QueueUrl))
+
+	return aws.ToString(out.QueueUrl), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows bursts of up to burst messages, refilled at rate messages per second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill tops up the bucket for the time since it was last used.
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if b.tokens += now.Sub(b.last).Seconds() * rate; b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// wait gives how long until the bucket, once refilled, holds a token.
+func (b *tokenBucket) wait(rate float64) time.Duration {
+	if b.tokens >= 1.0 {
+		return 0
+	}
+	return time.Duration((1.0 - b.tokens) / rate * float64(time.Second))
+}
+
+// rateLimiter limits the rate messages are sent, both overall and for each stream, a zero rate is unlimited.
+type rateLimiter struct {
+	rate, burst             float64
+	streamRate, streamBurst float64
+
+	mu      sync.Mutex
+	global  tokenBucket
+	streams map[string]*tokenBucket
+}
+
+func newRateLimiter(rate, burst, streamRate, streamBurst float64) *rateLimiter {
+	if rate <= 0.0 && streamRate <= 0.0 {
+		return nil
+	}
+	// a bucket needs room for at least one token
+	return &rateLimiter{
+		rate:        rate,
+		burst:       math.Max(burst, 1.0),
+		streamRate:  streamRate,
+		streamBurst: math.Max(streamBurst, 1.0),
+		streams:     make(map[string]*tokenBucket),
+	}
+}
+
+// buckets refills and returns the buckets used for a stream, either may be nil if there is no limit.
+func (r *rateLimiter) buckets(key string, now time.Time) (*tokenBucket, *tokenBucket) {
+	var stream, global *tokenBucket
+	if r.streamRate > 0.0 {
+		b, ok := r.streams[key]
+		if !ok {
+			b = &tokenBucket{}
+			r.streams[key] = b
+		}
+		stream = b
+		stream.refill(now, r.streamRate, r.streamBurst)
+	}
+	if r.rate > 0.0 {
+		global = &r.global
+		global.refill(now, r.rate, r.burst)
+	}
+	return stream, global
+}
+
+// allow checks whether a message from a stream can be sent now, returning which limit was reached if not,
+// a token is only taken from each bucket once both limits allow the message.
+func (r *rateLimiter) allow(key string, now time.Time) (string, bool) {
+	if r == nil {
+		return "", true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// a stream flooding messages should not use up the overall allowance
+	stream, global := r.buckets(key, now)
+	switch {
+	case stream != nil && stream.tokens < 1.0:
+		return "stream", false
+	case global != nil && global.tokens < 1.0:
+		return "global", false
+	}
+	if stream != nil {
+		stream.tokens--
+	}
+	if global != nil {
+		global.tokens--
+	}
+
+	return "", true
+}
+
+// wait gives how long until a message from a stream would be allowed.
+func (r *rateLimiter) wait(key string, now time.Time) time.Duration {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var d time.Duration
+	stream, global := r.buckets(key, now)
+	if stream != nil {
+		d = stream.wait(r.streamRate)
+	}
+	if global != nil {
+		if w := global.wait(r.rate); w > d {
+			d = w
+		}
+	}
+	return d
+}
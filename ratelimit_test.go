@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2016, time.November, 13, 11, 2, 56, 0, time.UTC)
+
+	// the overall limit is reached by one stream, without using up the allowance of another
+	r := newRateLimiter(1.0, 1.0, 1.0, 1.0)
+	if _, ok := r.allow("NZ_WEL_20_HNZ", now); !ok {
+		t.Fatal("expected the first message to be allowed")
+	}
+	if limit, ok := r.allow("NZ_WEL_20_HNZ", now); ok || limit != "stream" {
+		t.Errorf("expected the stream limit, got %q", limit)
+	}
+	if limit, ok := r.allow("NZ_SNZO_10_HNZ", now); ok || limit != "global" {
+		t.Errorf("expected the global limit, got %q", limit)
+	}
+	if w := r.wait("NZ_SNZO_10_HNZ", now); w != time.Second {
+		t.Errorf("expected to wait a second, got %s", w)
+	}
+	if _, ok := r.allow("NZ_SNZO_10_HNZ", now.Add(time.Second)); !ok {
+		t.Error("expected a stream token to be kept when the global limit was reached")
+	}
+}
+
+func TestOutputRateLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		sent     []string
+		dropped  int
+	}{
+		{"latest", []string{`{"MMI":3}`, `{"MMI":4}`, `{"MMI":5}`}, []string{`{"MMI":3}`, `{"MMI":5}`}, 1},
+		{"all-clear", []string{`{"MMI":3}`, `{"MMI":4}`, `{"MMI":1,"Type":"all-clear"}`}, []string{`{"MMI":3}`, `{"MMI":1,"Type":"all-clear"}`}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &msimpacttest.Sink{}
+			report := summary{}
+			output := outputSink{next: sink, report: &report, limit: newRateLimiter(0, 0, 20.0, 1.0)}
+			for _, m := range tt.messages {
+				if err := output.Send("NZ_WEL_20_HNZ", []byte(m)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// held messages are sent once a token is free, without waiting to close
+			time.Sleep(200 * time.Millisecond)
+
+			sent := sink.Sent()
+			if len(sent) != len(tt.sent) {
+				t.Fatalf("expected %d messages, got %d", len(tt.sent), len(sent))
+			}
+			for i, s := range sent {
+				if string(s.Message) != tt.sent[i] {
+					t.Errorf("expected message %s, got %s", tt.sent[i], s.Message)
+				}
+			}
+			if report.RateLimited != tt.dropped {
+				t.Errorf("expected %d dropped messages, got %d", tt.dropped, report.RateLimited)
+			}
+			if err := output.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// longest wait between reconnection attempts
+const maxBackoff = time.Minute
+
+// reconnect repeatedly runs a network session until the stop channel is closed, or the session
+// returns errStop, waiting a little longer after each failure.
+func reconnect(name string, stop <-chan struct{}, session func() error) error {
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := session()
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if err == errStop {
+			return nil
+		}
+
+		// a long lived connection starts the backoff again
+		if time.Since(started) > maxBackoff {
+			delay = time.Second
+		}
+		slog.Warn("connection problem, reconnecting", "input", name, "delay", delay, "error", err)
+		status.Input(name, false)
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+}
+
+// closeOnStop closes a connection once stop is closed, unblocking any reads, the returned
+// function should be called when the connection is finished with.
+func closeOnStop(stop <-chan struct{}, conn interface{ Close() error }) func() {
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-finished:
+		}
+	}()
+	return func() { close(finished) }
+}
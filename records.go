@@ -0,0 +1,428 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// default miniseed block size
+const blockSize = 512
+
+// limits on record sizes, and how far into a record to look for the blockette 1000
+const (
+	minReclen   = 128
+	maxReclen   = 1 << 20
+	headerPeek  = 256
+	fixedHeader = 48
+)
+
+// the file name used to read from standard input
+const stdinName = "-"
+
+// corruptBytes counts the bytes skipped over while looking for a record after corrupt data.
+var corruptBytes atomic.Int64
+
+// undecodable counts the records skipped as they could not be decoded.
+var undecodable decodeErrors
+
+// decodeErrors counts records that could not be decoded, each file input is given up once it has more than the limit.
+type decodeErrors struct {
+	count atomic.Int64
+
+	// zero for no limit
+	limit int64
+}
+
+// Skipped logs and counts a record that could not be decoded.
+func (d *decodeErrors) Skipped(input string, offset int64, err error) int64 {
+	slog.Warn("skipping undecodable record", "input", input, "offset", offset, "error", err)
+	metricSkipped.WithLabelValues("undecodable").Inc()
+	return d.count.Add(1)
+}
+
+// Input starts counting the undecodable records of a single input.
+func (d *decodeErrors) Input(name string) *inputErrors {
+	return &inputErrors{decode: d, name: name}
+}
+
+// inputErrors counts the undecodable records of a single input against the limit.
+type inputErrors struct {
+	decode *decodeErrors
+	name   string
+	count  int64
+}
+
+// Failed notes a record that could not be decoded, returning an error once the input has had too many.
+func (e *inputErrors) Failed(offset int64, err error) error {
+	e.decode.Skipped(e.name, offset, err)
+	if e.count++; e.decode.limit > 0 && e.count > e.decode.limit {
+		return &tooManyErrors{Input: e.name, Limit: e.decode.limit}
+	}
+	return nil
+}
+
+// tooManyErrors is returned for an input with more undecodable records than the limit, only that input is given up.
+type tooManyErrors struct {
+	Input string
+	Limit int64
+}
+
+func (e *tooManyErrors) Error() string {
+	return fmt.Sprintf("too many undecodable records in %s, more than %d", e.Input, e.Limit)
+}
+
+// skippedInput checks whether an error only gives up on a single input, logging it if so.
+func skippedInput(err error) bool {
+	var e *tooManyErrors
+	if !errors.As(err, &e) {
+		return false
+	}
+	slog.Error("giving up on input", "input", e.Input, "error", err)
+	return true
+}
+
+// unpack decodes a miniseed 2 record, any panic while decoding is returned as an error.
+func unpack(msr *mseed.MSRecord, buf []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to unpack record: %v", r)
+		}
+	}()
+	if bench == nil {
+		return msr.Unpack(buf, len(buf), 1, 0)
+	}
+	start := time.Now()
+	err = msr.Unpack(buf, len(buf), 1, 0)
+	bench.Decoded(time.Since(start), msr.Samplecnt())
+	return err
+}
+
+// errStop can be returned by a record handler to stop reading the current input.
+var errStop = errors.New("stop reading records")
+
+// readRecords decodes each miniseed block in a file, or stdin, and passes the record to the handler,
+// the record is reused between calls. A zero record length will detect the length of each record.
+func readRecords(path string, reclen int, msr *mseed.MSRecord, handler func(msimpact.Record) error) error {
+	in := io.Reader(os.Stdin)
+	if path != stdinName {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+
+		// plain files are mapped into memory and decoded in place, rather than read block by block
+		if data, release, err := mapFile(file); err == nil {
+			defer release()
+			if !isGzip(data) && !isTar(data) {
+				if err := scanRecords(path, &memoryReader{data: data}, reclen, msr, handler); err != errStop {
+					return err
+				}
+				return nil
+			}
+			in = bytes.NewReader(data)
+		}
+	}
+
+	if err := readArchive(path, in, reclen, msr, handler); err != errStop {
+		return err
+	}
+
+	return nil
+}
+
+// readArchive decodes records from a reader that may be gzip compressed, and may be
+// a tar archive, in which case each regular member is read in turn. The input names the reader in any warnings.
+func readArchive(input string, rd io.Reader, reclen int, msr *mseed.MSRecord, handler func(msimpact.Record) error) error {
+	in := bufio.NewReader(rd)
+
+	if magic, _ := in.Peek(2); isGzip(magic) {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in = bufio.NewReader(gz)
+	}
+
+	if header, _ := in.Peek(blockSize); isTar(header) {
+		archive := tar.NewReader(in)
+		for {
+			member, err := archive.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if member.Typeflag != tar.TypeReg && member.Typeflag != tar.TypeRegA {
+				continue
+			}
+			if err := readArchive(input+":"+member.Name, archive, reclen, msr, handler); err != nil && !skippedInput(err) {
+				return err
+			}
+		}
+	}
+
+	return readStream(input, in, reclen, msr, handler)
+}
+
+// isGzip checks for the gzip magic number.
+func isGzip(hdr []byte) bool {
+	return len(hdr) > 1 && hdr[0] == 0x1f && hdr[1] == 0x8b
+}
+
+// isTar checks for a tar header, which has its magic at a fixed offset.
+func isTar(hdr []byte) bool {
+	return len(hdr) >= blockSize && bytes.HasPrefix(hdr[257:], []byte("ustar"))
+}
+
+// readStream decodes miniseed blocks from a reader until EOF, any short reads are
+// accumulated until a complete block is available. Any handler error, including errStop, is returned.
+// A zero record length will use the blockette 1000 of each record, falling back to the default size.
+func readStream(input string, rd io.Reader, reclen int, msr *mseed.MSRecord, handler func(msimpact.Record) error) error {
+	return scanRecords(input, bufio.NewReaderSize(rd, maxReclen), reclen, msr, handler)
+}
+
+// recordReader is the part of a bufio.Reader used to step through records, it allows files held in memory
+// to be decoded in place.
+type recordReader interface {
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+}
+
+// memoryReader steps through records held in memory, e.g. a mapped file.
+type memoryReader struct {
+	data []byte
+}
+
+func (m *memoryReader) Peek(n int) ([]byte, error) {
+	if n > len(m.data) {
+		return m.data, io.EOF
+	}
+	return m.data[:n], nil
+}
+
+func (m *memoryReader) Discard(n int) (int, error) {
+	if n > len(m.data) {
+		n = len(m.data)
+		m.data = nil
+		return n, io.EOF
+	}
+	m.data = m.data[n:]
+	return n, nil
+}
+
+// scanRecords decodes each record available from a reader, named by input, each block is decoded where it lies rather than being copied.
+func scanRecords(input string, in recordReader, reclen int, msr *mseed.MSRecord, handler func(msimpact.Record) error) error {
+	// how far into the stream the reader is, for logging, and its undecodable records
+	var offset int64
+	failures := undecodable.Input(input)
+
+	for {
+		// skip forward to the next plausible record after any corrupt, or truncated, data
+		if skipped, err := resync(in); err != nil {
+			return err
+		} else if skipped > 0 {
+			slog.Warn("skipped corrupt miniseed data", "input", input, "offset", offset, "bytes", skipped)
+			corruptBytes.Add(int64(skipped))
+			metricCorrupt.Add(float64(skipped))
+			offset += int64(skipped)
+		}
+
+		// version 3 records give their own length, and are copied as the record refers to its data
+		if hdr, _ := in.Peek(ms3Header); isMS3(hdr) {
+			n := ms3Length(hdr)
+			if n > maxReclen {
+				return fmt.Errorf("miniseed 3 record too large: %d bytes", n)
+			}
+			blk, err := in.Peek(n)
+			switch {
+			case len(blk) < n && (err == nil || err == io.EOF):
+				slog.Warn("ignoring incomplete trailing miniseed 3 record", "input", input)
+				return nil
+			case err != nil:
+				return err
+			}
+			start := time.Now()
+			r, err := parseMS3(append([]byte{}, blk...))
+			if err == nil {
+				bench.Decoded(time.Since(start), r.Samplecnt())
+			}
+			in.Discard(n)
+			if err != nil {
+				if err := failures.Failed(offset, err); err != nil {
+					return err
+				}
+				offset += int64(n)
+				continue
+			}
+			offset += int64(n)
+			if err := handler(r); err != nil {
+				return err
+			}
+			continue
+		}
+
+		size := reclen
+		if size <= 0 {
+			hdr, _ := in.Peek(headerPeek)
+			if size = recordLength(hdr); size == 0 {
+				size = probeLength(in)
+			}
+			if size == 0 {
+				size = blockSize
+			}
+		}
+
+		blk, err := in.Peek(size)
+		switch {
+		case len(blk) == 0 && (err == nil || err == io.EOF):
+			return nil
+		case len(blk) < size && (err == nil || err == io.EOF):
+			slog.Warn("ignoring incomplete trailing block", "input", input, "bytes", len(blk))
+			return nil
+		case err != nil:
+			return err
+		}
+
+		// decode mseed block
+		err = unpack(msr, blk)
+		in.Discard(size)
+		if err != nil {
+			if err := failures.Failed(offset, err); err != nil {
+				return err
+			}
+			offset += int64(size)
+			continue
+		}
+		offset += int64(size)
+
+		if err := handler(msr); err != nil {
+			return err
+		}
+	}
+}
+
+// resync steps over any bytes that can't be the start of a record, returning how many were skipped,
+// any trailing data too short to hold a record header is left to be reported as incomplete.
+func resync(in recordReader) (int, error) {
+	var skipped int
+	for {
+		hdr, err := in.Peek(fixedHeader)
+		switch {
+		case len(hdr) < fixedHeader && (err == io.EOF || err == bufio.ErrBufferFull):
+			return skipped, nil
+		case len(hdr) < fixedHeader:
+			return skipped, err
+		case isMS3(hdr) || validHeader(hdr):
+			return skipped, nil
+		}
+		if _, err := in.Discard(1); err != nil {
+			return skipped, err
+		}
+		skipped++
+	}
+}
+
+// validHeader checks whether a miniseed 2 fixed header is plausible, a numeric sequence number, a known quality
+// indicator, printable stream codes, and a sensible start time in either byte order.
+func validHeader(hdr []byte) bool {
+	if len(hdr) < fixedHeader {
+		return false
+	}
+	for _, c := range hdr[0:6] {
+		if c != ' ' && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	switch hdr[6] {
+	case 'D', 'R', 'Q', 'M':
+	default:
+		return false
+	}
+	if hdr[7] != ' ' && hdr[7] != 0 {
+		return false
+	}
+	for _, c := range hdr[8:20] {
+		if c != 0 && (c < ' ' || c > '~') {
+			return false
+		}
+	}
+
+	order := binary.ByteOrder(binary.BigEndian)
+	if y := order.Uint16(hdr[20:22]); y < 1900 || y > 2100 {
+		order = binary.LittleEndian
+	}
+	year, day, ticks := order.Uint16(hdr[20:22]), order.Uint16(hdr[22:24]), order.Uint16(hdr[28:30])
+	switch {
+	case year < 1900 || year > 2100:
+		return false
+	case day < 1 || day > 366:
+		return false
+	case hdr[24] > 23 || hdr[25] > 59 || hdr[26] > 60:
+		return false
+	case ticks > 9999:
+		return false
+	}
+
+	return true
+}
+
+// recordLength searches the blockettes in a record header for a blockette 1000 and returns
+// the record length it gives, or zero if none could be found.
+func recordLength(hdr []byte) int {
+	if len(hdr) < fixedHeader {
+		return 0
+	}
+
+	// the start year is used to find the header byte order
+	order := binary.ByteOrder(binary.BigEndian)
+	if y := order.Uint16(hdr[20:22]); y < 1900 || y > 2100 {
+		order = binary.LittleEndian
+	}
+
+	next := int(order.Uint16(hdr[46:48]))
+	for i := 0; i < 16 && next >= fixedHeader && next+8 <= len(hdr); i++ {
+		if order.Uint16(hdr[next:next+2]) == 1000 {
+			size := 1 << uint(hdr[next+6])
+			if size < minReclen || size > maxReclen {
+				return 0
+			}
+			return size
+		}
+		next = int(order.Uint16(hdr[next+2 : next+4]))
+	}
+
+	return 0
+}
+
+// probeLength finds the length of a record without a blockette 1000 by looking for the start of the next
+// record, or the end of the data, after each possible record length in turn, returning zero if neither is found.
+func probeLength(in recordReader) int {
+	for size := minReclen; size+fixedHeader <= maxReclen; size *= 2 {
+		buf, _ := in.Peek(size + fixedHeader)
+		switch {
+		case len(buf) < size:
+			return 0
+		case len(buf) == size:
+			return size
+		case len(buf) == size+fixedHeader && (isMS3(buf[size:]) || validHeader(buf[size:])):
+			return size
+		}
+	}
+	return 0
+}
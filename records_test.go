@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"testing"
+	"time"
+)
+
+func TestInputErrors(t *testing.T) {
+	var d decodeErrors
+	d.limit = 2
+
+	// each input has its own allowance, while the run keeps the total
+	first, second := d.Input("first.mseed"), d.Input("second.mseed")
+	for i := 0; i < 2; i++ {
+		if err := first.Failed(int64(i*512), errors.New("bad blockette")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := second.Failed(0, errors.New("bad blockette")); err != nil {
+		t.Fatalf("unexpected error for another input: %s", err)
+	}
+
+	err := first.Failed(1024, errors.New("bad blockette"))
+	if err == nil {
+		t.Fatal("expected to give up on the first input")
+	}
+	if !skippedInput(fmt.Errorf("reading: %w", err)) {
+		t.Errorf("expected %q to only give up on the input", err)
+	}
+	if skippedInput(errors.New("disk failure")) {
+		t.Error("expected other errors to end the run")
+	}
+	if n := d.count.Load(); n != 4 {
+		t.Errorf("expected 4 undecodable records in total, got %d", n)
+	}
+}
+
+// testHeader builds a miniseed 2 data record of a given length, with a blockette 1000 if the exponent is set.
+func testHeader(order binary.ByteOrder, length int, exponent byte) []byte {
+	buf := make([]byte, length)
+	copy(buf, "000001D WEL  20HNZNZ")
+	order.PutUint16(buf[20:22], 2016)
+	order.PutUint16(buf[22:24], 318)
+	buf[24], buf[25], buf[26] = 11, 2, 56
+	order.PutUint16(buf[28:30], 5000)
+	order.PutUint16(buf[44:46], 64)
+	if exponent > 0 {
+		buf[39] = 1
+		order.PutUint16(buf[46:48], fixedHeader)
+		order.PutUint16(buf[fixedHeader:], 1000)
+		buf[fixedHeader+4], buf[fixedHeader+6] = 11, exponent
+		if order == binary.BigEndian {
+			buf[fixedHeader+5] = 1
+		}
+	}
+	return buf
+}
+
+func TestValidHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func([]byte)
+		order binary.ByteOrder
+		valid bool
+	}{
+		{"big endian", func([]byte) {}, binary.BigEndian, true},
+		{"little endian", func([]byte) {}, binary.LittleEndian, true},
+		{"blank sequence", func(b []byte) { copy(b, "      ") }, binary.BigEndian, true},
+		{"null reserved", func(b []byte) { b[7] = 0 }, binary.BigEndian, true},
+		{"sequence", func(b []byte) { b[2] = 'x' }, binary.BigEndian, false},
+		{"quality", func(b []byte) { b[6] = 'X' }, binary.BigEndian, false},
+		{"reserved", func(b []byte) { b[7] = 'x' }, binary.BigEndian, false},
+		{"station", func(b []byte) { b[9] = 0x07 }, binary.BigEndian, false},
+		{"year", func(b []byte) { binary.BigEndian.PutUint16(b[20:22], 1800) }, binary.BigEndian, false},
+		{"day", func(b []byte) { binary.BigEndian.PutUint16(b[22:24], 367) }, binary.BigEndian, false},
+		{"zero day", func(b []byte) { binary.BigEndian.PutUint16(b[22:24], 0) }, binary.BigEndian, false},
+		{"hour", func(b []byte) { b[24] = 24 }, binary.BigEndian, false},
+		{"minute", func(b []byte) { b[25] = 60 }, binary.BigEndian, false},
+		{"leap second", func(b []byte) { b[26] = 60 }, binary.BigEndian, true},
+		{"second", func(b []byte) { b[26] = 61 }, binary.BigEndian, false},
+		{"ticks", func(b []byte) { binary.BigEndian.PutUint16(b[28:30], 10000) }, binary.BigEndian, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr := testHeader(tt.order, fixedHeader, 0)
+			tt.edit(hdr)
+			if ok := validHeader(hdr); ok != tt.valid {
+				t.Errorf("expected valid %v, got %v", tt.valid, ok)
+			}
+			if validHeader(hdr[:fixedHeader-1]) {
+				t.Error("expected a short header to be invalid")
+			}
+		})
+	}
+}
+
+func TestRecordLength(t *testing.T) {
+	// a blockette 100 ahead of the blockette 1000
+	chained := testHeader(binary.BigEndian, 512, 0)
+	chained[39] = 2
+	binary.BigEndian.PutUint16(chained[46:48], fixedHeader)
+	binary.BigEndian.PutUint16(chained[fixedHeader:], 100)
+	binary.BigEndian.PutUint16(chained[fixedHeader+2:], fixedHeader+12)
+	binary.BigEndian.PutUint16(chained[fixedHeader+12:], 1000)
+	chained[fixedHeader+18] = 12
+
+	// blockettes that refer back to each other
+	looped := testHeader(binary.BigEndian, 512, 0)
+	binary.BigEndian.PutUint16(looped[46:48], fixedHeader)
+	binary.BigEndian.PutUint16(looped[fixedHeader:], 100)
+	binary.BigEndian.PutUint16(looped[fixedHeader+2:], fixedHeader)
+
+	tests := []struct {
+		name   string
+		hdr    []byte
+		length int
+	}{
+		{"big endian", testHeader(binary.BigEndian, 4096, 12), 4096},
+		{"little endian", testHeader(binary.LittleEndian, 512, 9), 512},
+		{"smallest", testHeader(binary.BigEndian, 128, 7), 128},
+		{"too small", testHeader(binary.BigEndian, 512, 6), 0},
+		{"too large", testHeader(binary.BigEndian, 512, 21), 0},
+		{"chained", chained, 4096},
+		{"looped", looped, 0},
+		{"none", testHeader(binary.BigEndian, 512, 0), 0},
+		{"short", testHeader(binary.BigEndian, 512, 12)[:fixedHeader-1], 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr := tt.hdr
+			if len(hdr) > headerPeek {
+				hdr = hdr[:headerPeek]
+			}
+			if n := recordLength(hdr); n != tt.length {
+				t.Errorf("expected length %d, got %d", tt.length, n)
+			}
+		})
+	}
+}
+
+func TestProbeLength(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		// the length expected from probing, or zero if it can't be found
+		length int
+	}{
+		{"followed", append(testHeader(binary.BigEndian, 1024, 10), testHeader(binary.BigEndian, 512, 9)...), 1024},
+		{"last", testHeader(binary.BigEndian, 4096, 12), 4096},
+		{"smallest", append(testHeader(binary.LittleEndian, 128, 7), testHeader(binary.LittleEndian, 128, 7)...), 128},
+		{"miniseed 3", append(testHeader(binary.BigEndian, 256, 8), ms3Build("FDSN:NZ_WEL_20_H_N_Z", time.Now(), 100.0, ms3Int32, 0, nil, nil)...), 256},
+		{"truncated", testHeader(binary.BigEndian, 4096, 12)[:1000], 0},
+		{"trailing garbage", append(testHeader(binary.BigEndian, 512, 9), bytes.Repeat([]byte{0xff}, 600)...), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the length given by the blockette 1000 is the one found by probing without it
+			if n := recordLength(tt.data[:headerPeek]); tt.length > 0 && n != tt.length {
+				t.Errorf("expected blockette length %d, got %d", tt.length, n)
+			}
+
+			data := append([]byte{}, tt.data...)
+			data[39] = 0
+			binary.BigEndian.PutUint16(data[46:48], 0)
+			if n := recordLength(data[:headerPeek]); n != 0 {
+				t.Fatalf("expected no blockette length, got %d", n)
+			}
+			if n := probeLength(&memoryReader{data: data}); n != tt.length {
+				t.Errorf("expected probed length %d, got %d", tt.length, n)
+			}
+		})
+	}
+}
+
+func TestResync(t *testing.T) {
+	header := testHeader(binary.BigEndian, 512, 9)
+	ms3 := ms3Build("FDSN:NZ_WEL_20_H_N_Z", time.Now(), 100.0, ms3Int32, 0, nil, nil)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		skipped int
+		left    int
+	}{
+		{"aligned", header, 0, 512},
+		{"garbage", append(bytes.Repeat([]byte{0xff}, 100), header...), 100, 512},
+		{"partial header", append(append([]byte{}, header[10:40]...), header...), 30, 512},
+		{"miniseed 3", append([]byte("garbage"), ms3...), 7, len(ms3)},
+		{"trailing", bytes.Repeat([]byte{0xff}, 100), 100 - fixedHeader + 1, fixedHeader - 1},
+		{"short", bytes.Repeat([]byte{0xff}, 20), 0, 20},
+		{"empty", nil, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &memoryReader{data: tt.data}
+			skipped, err := resync(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if skipped != tt.skipped {
+				t.Errorf("expected %d bytes skipped, got %d", tt.skipped, skipped)
+			}
+			if len(in.data) != tt.left {
+				t.Errorf("expected %d bytes left, got %d", tt.left, len(in.data))
+			}
+		})
+	}
+}
+
+func TestScanTruncated(t *testing.T) {
+	// only corrupt data and a truncated last record, so neither is passed on to be decoded
+	data := append(bytes.Repeat([]byte{0xff}, 100), testHeader(binary.BigEndian, 4096, 12)[:1000]...)
+
+	handler := func(msimpact.Record) error {
+		t.Error("unexpected record")
+		return nil
+	}
+	if err := scanRecords("truncated.mseed", &memoryReader{data: data}, 0, nil, handler); err != nil {
+		t.Fatal(err)
+	}
+}
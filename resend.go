@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+)
+
+// resend passes the messages from dead letter files back to the outputs, those an output failed to deliver go
+// only to that output, otherwise to every output. It returns the messages each output failed to deliver again.
+func resend(sinks *fanout, files []string, report *summary) map[string]int {
+	for _, file := range files {
+		report.Files++
+		err := readDeadLetters(file, func(entry deadLetterEntry) error {
+			report.Messages++
+			if entry.Output == "" {
+				return sinks.Send(entry.Stream, entry.Message)
+			}
+			if err := sinks.SendTo(entry.Output, entry.Stream, entry.Message); err != nil {
+				slog.Warn("unable to resend message", "file", file, "stream", entry.Stream, "error", err)
+				report.Errors++
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("unable to read dead letter file: %s", err)
+		}
+	}
+
+	if err := sinks.Close(); err != nil {
+		slog.Error("unable to close outputs", "error", err)
+	}
+	return sinks.Failed()
+}
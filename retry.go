@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// longest wait between send attempts
+const maxRetryDelay = 30 * time.Second
+
+// retrySink retries failed sends with an exponential backoff and jitter, giving up on permanent errors,
+// once the attempts are exhausted, or once the elapsed time would be exceeded.
+type retrySink struct {
+	msimpact.Sink
+
+	attempts int
+	elapsed  time.Duration
+	delay    time.Duration
+}
+
+func newRetrySink(s msimpact.Sink, attempts int, elapsed, delay time.Duration) *retrySink {
+	return &retrySink{
+		Sink:     s,
+		attempts: attempts,
+		elapsed:  elapsed,
+		delay:    delay,
+	}
+}
+
+func (r *retrySink) Send(key string, msg []byte) error {
+	start, delay := time.Now(), r.delay
+	for attempt := 1; ; attempt++ {
+		err := r.Sink.Send(key, msg)
+		if err == nil || isPermanent(err) {
+			return err
+		}
+
+		// full jitter, somewhere up to the current delay
+		wait := time.Duration(rand.Int63n(int64(delay) + 1))
+		if (r.attempts > 0 && attempt >= r.attempts) || (r.elapsed > 0 && time.Since(start)+wait > r.elapsed) {
+			return fmt.Errorf("giving up after %d attempts: %s", attempt, err)
+		}
+
+		slog.Warn("send problem, retrying", "stream", key, "attempt", attempt, "delay", wait, "error", err)
+		time.Sleep(wait)
+
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// isPermanent checks whether an error will not be fixed by trying again, i.e. a client
+// error that is not a request throttle, or the request was cancelled.
+func isPermanent(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+
+	if e, ok := err.(*webhookError); ok {
+		return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests
+	}
+
+	var api smithy.APIError
+	if !errors.As(err, &api) {
+		return false
+	}
+
+	switch api.ErrorCode() {
+	case "Throttling", "ThrottlingException", "RequestThrottled", "RequestExpired", "ProvisionedThroughputExceededException",
+		"ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired":
+		return false
+	}
+
+	var resp *awshttp.ResponseError
+	if errors.As(err, &resp) && resp.HTTPStatusCode() == http.StatusTooManyRequests {
+		return false
+	}
+
+	return api.ErrorFault() == smithy.FaultClient
+}
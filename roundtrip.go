@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"os"
+	"strconv"
+	"time"
+)
+
+// how long to long poll the queue for each receive
+const roundtripWait = 5 * time.Second
+
+// roundtrip sends a unique test message to the queue and then waits for it to be received back,
+// any other messages seen are made visible again for their real consumers.
+func roundtrip(ctx context.Context, client *sqs.Client, queue string, timeout time.Duration) (time.Duration, error) {
+	host, _ := os.Hostname()
+	token := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	body, err := json.Marshal(struct {
+		Roundtrip string
+		Host      string
+		Time      time.Time
+	}{
+		Roundtrip: token,
+		Host:      host,
+		Time:      time.Now().UTC(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout+roundtripWait)
+	defer cancel()
+
+	start := time.Now()
+	input := sqs.SendMessageInput{QueueUrl: aws.String(queue), MessageBody: aws.String(string(body))}
+	if isFifoQueue(queue) {
+		input.MessageGroupId, input.MessageDeduplicationId = aws.String("roundtrip"), aws.String(token)
+	}
+	if _, err := client.SendMessage(ctx, &input); err != nil {
+		return 0, err
+	}
+
+	for time.Since(start) < timeout {
+		resp, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queue),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     int32(roundtripWait.Seconds()),
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range resp.Messages {
+			if aws.ToString(m.Body) != string(body) {
+				if _, err := client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: aws.String(queue), ReceiptHandle: m.ReceiptHandle, VisibilityTimeout: 0}); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			latency := time.Since(start)
+			if _, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(queue), ReceiptHandle: m.ReceiptHandle}); err != nil {
+				return latency, err
+			}
+			return latency, nil
+		}
+	}
+
+	return 0, fmt.Errorf("test message not received within %s", timeout)
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// run assembles the processing pipeline and reads each input in turn, the run results are
+// gathered in the report.
+type run struct {
+	s      *settings
+	report *summary
+
+	// where the stream config came from, workers each parse their own copy
+	source *configSource
+	raw    []byte
+
+	options   msimpact.Options
+	processor *msimpact.StreamProcessor
+	shift     *timeShift
+	pipeline  msimpact.Pipeline
+
+	// configured stream names, and patterns, for requesting real-time or historic data
+	streams []string
+
+	// the input currently being read, for logging, and any network input for health checks
+	current, live string
+
+	// closed once input should stop, reason is why
+	expired chan struct{}
+	reason  string
+	once    sync.Once
+
+	// config reload requests, and periodic checks for config changes
+	hangup  chan os.Signal
+	refresh <-chan time.Time
+
+	pace     *pacer
+	replayed *progress
+}
+
+// newRun builds the stream processor from the config, and the pipeline delivering its messages, gap
+// messages are sent to the output directly rather than to any delivery window.
+func newRun(s *settings, report *summary, source *configSource, raw []byte, set *msimpact.Config, output, delivery msimpact.Sink, shift *timeShift) (*run, error) {
+	r := run{
+		s:       s,
+		report:  report,
+		source:  source,
+		raw:     raw,
+		shift:   shift,
+		expired: make(chan struct{}),
+		hangup:  make(chan os.Signal, 1),
+	}
+
+	policy, err := parseFlushPolicy(s.flushRules)
+	if err != nil {
+		return nil, err
+	}
+	if s.minMMI > 0 {
+		if policy.Threshold > 0 {
+			return nil, fmt.Errorf("the minimum intensity can be given by either -min-mmi or -flush min=<mmi>, not both")
+		}
+		policy.Threshold = int32(s.minMMI)
+	}
+
+	var intensityScales []string
+	if s.scales != "" {
+		intensityScales = strings.Split(s.scales, ",")
+	}
+
+	// initial stream setup
+	r.options = msimpact.Options{
+		Probation:  s.probation,
+		Level:      (int32)(s.level),
+		WarnLevel:  (int32)(s.warnLevel),
+		InitialMMI: (int32)(s.initialMMI),
+		AllClear:   s.allClear,
+		Baseline:   (int32)(s.baseline),
+		Replay:     s.replay,
+		Heartbeat:  s.heartbeat,
+		Policy:     policy,
+		Scales:     intensityScales,
+		Duplicates: s.duplicates,
+		Version:    version,
+		Clock:      clock,
+		Discontinuity: func(d msimpact.Discontinuity) {
+			slog.Warn("data "+d.Type(), "stream", d.Stream, "expected", d.Expected, "offset", d.Offset)
+			metricDiscontinuities.WithLabelValues(d.Type()).Inc()
+			report.Streams.Discontinuity(d.Stream, d.Type() == msimpact.Overlap)
+			stats.Count("discontinuities."+d.Type(), 1)
+			if !s.gapMessages {
+				return
+			}
+			b, err := gapMessage(d)
+			if err != nil {
+				slog.Error("unable to encode gap message", "stream", d.Stream, "error", err)
+				return
+			}
+			if err := output.Send(d.Stream, b); err != nil {
+				slog.Error("unable to send gap message", "stream", d.Stream, "error", err)
+			}
+		},
+	}
+	if r.processor, err = msimpact.NewStreamProcessor(r.options, set); err != nil {
+		return nil, err
+	}
+
+	r.pipeline = msimpact.Pipeline{
+		Processor: shift.Processor(r.processor),
+		Sink:      delivery,
+		Problem:   r.problem,
+	}
+	if bench != nil {
+		r.pipeline.Observe = bench.Observe
+	}
+
+	if s.configRefresh > 0 {
+		r.refresh = time.NewTicker(s.configRefresh).C
+	}
+	if s.speed > 0.0 {
+		r.pace = newPacer(s.speed)
+	}
+	r.streams = s.selection.filter(r.processor.Streams())
+
+	return &r, nil
+}
+
+// problem logs, and counts, records that could not be processed.
+func (r *run) problem(msr msimpact.Record, err error) {
+	if e, ok := err.(*msimpact.MissingStreamError); ok {
+		slog.Warn("unable to find stream config", "stream", e.Stream, "file", r.current)
+		r.report.Missing = append(r.report.Missing, e.Stream)
+		metricSkipped.WithLabelValues("missing").Inc()
+		stats.Count("records.skipped.missing", 1)
+		return
+	}
+	if e, ok := err.(*msimpact.DuplicateRecordError); ok {
+		slog.Debug("skipping duplicate record", "stream", e.Stream, "file", r.current, "time", e.Start)
+		r.report.Duplicates++
+		metricSkipped.WithLabelValues("duplicate").Inc()
+		stats.Count("records.skipped.duplicate", 1)
+		return
+	}
+	slog.Warn("processing problem", "stream", msr.SrcName(0), "file", r.current, "time", msr.Starttime(), "error", err)
+	r.report.Errors++
+	metricSkipped.WithLabelValues("error").Inc()
+	stats.Count("records.skipped.error", 1)
+}
+
+// reload rereads the stream configuration, keeping the state of any unchanged streams.
+func (r *run) reload(force bool) error {
+	raw, err := r.source.Fetch(force)
+	if err != nil || raw == nil {
+		return err
+	}
+	set, err := parseConfig(r.source.Name(), raw)
+	if err != nil {
+		return err
+	}
+	return r.processor.Reload(set)
+}
+
+// halt stops processing input, the first reason given is kept.
+func (r *run) halt(why string) {
+	r.once.Do(func() {
+		r.reason = why
+		close(r.expired)
+	})
+}
+
+// stopped notes why input was stopped, only called once expired has been closed.
+func (r *run) stopped() {
+	switch r.reason {
+	case "runtime":
+		if !r.report.TimedOut {
+			slog.Info("maximum runtime reached, stopping", "runtime", r.s.maxRuntime)
+		}
+		r.report.TimedOut = true
+	case "interrupt":
+		r.report.Interrupted = true
+	}
+}
+
+// done checks whether input has been stopped.
+func (r *run) done() bool {
+	return r.report.TimedOut || r.report.Interrupted
+}
+
+// wanted skips streams not selected on the command line.
+func (r *run) wanted(msr msimpact.Record) bool {
+	if r.s.selection.selected(msr.SrcName(0)) {
+		return true
+	}
+	r.report.Rejected++
+	metricSkipped.WithLabelValues("rejected").Inc()
+	stats.Count("records.skipped.rejected", 1)
+	return false
+}
+
+// tally counts each record read, and how far through any files.
+func (r *run) tally(msr msimpact.Record) {
+	r.report.Records++
+	r.report.Streams.Record(msr.SrcName(0))
+	r.replayed.Record()
+	metricRecords.Inc()
+	stats.Count("records", 1)
+	lastRecords.Seen(msr.SrcName(0))
+	status.Processed(r.live)
+
+	// how stale is the incoming data
+	latency := recordLatency(msr, time.Now())
+	metricStreamLatency.WithLabelValues(msr.SrcName(0)).Set(latency.Seconds())
+	stats.Timing("latency", latency)
+	if r.s.maxLatency > 0 && latency > r.s.maxLatency {
+		slog.Warn("stale record", "stream", msr.SrcName(0), "latency", latency.Truncate(time.Millisecond))
+	} else {
+		slog.Debug("record latency", "stream", msr.SrcName(0), "latency", latency.Truncate(time.Millisecond))
+	}
+}
+
+// watch checks for config changes before each record, and skips and counts records.
+func (r *run) watch(handler func(msimpact.Record) error) func(msimpact.Record) error {
+	return func(msr msimpact.Record) error {
+		select {
+		case <-r.hangup:
+			slog.Info("reloading stream config", "config", r.s.config)
+			if err := r.reload(true); err != nil {
+				slog.Error("unable to reload stream config", "config", r.s.config, "error", err)
+			}
+		case <-r.refresh:
+			if err := r.reload(false); err != nil {
+				slog.Error("unable to refresh stream config", "config", r.s.config, "error", err)
+			}
+		default:
+		}
+
+		if !r.wanted(msr) {
+			return nil
+		}
+		r.tally(msr)
+
+		return handler(msr)
+	}
+}
+
+// paced gives replayed records realistic timing.
+func (r *run) paced(handler func(msimpact.Record) error) func(msimpact.Record) error {
+	return func(msr msimpact.Record) error {
+		if !r.pace.wait(msr.Starttime(), r.expired) {
+			r.stopped()
+			return errStop
+		}
+		return handler(msr)
+	}
+}
+
+// limit stops reading once the runtime limit is reached, or on an interrupt.
+func (r *run) limit(handler func(msimpact.Record) error) func(msimpact.Record) error {
+	return func(msr msimpact.Record) error {
+		select {
+		case <-r.expired:
+			r.stopped()
+			return errStop
+		default:
+		}
+		return handler(msr)
+	}
+}
+
+// inWindow skips file records outside of any time window.
+func (r *run) inWindow(window timeWindow, msr msimpact.Record) bool {
+	if window.contains(msr) {
+		return true
+	}
+	r.report.OutsideWindow++
+	metricSkipped.WithLabelValues("window").Inc()
+	stats.Count("records.skipped.window", 1)
+	return false
+}
+
+// readFiles processes each file in turn, or shared amongst workers, keeping track of how far through them the run is.
+func (r *run) readFiles(in *fileInputs, files []string, msr *mseed.MSRecord) error {
+	filesRead := make(chan struct{})
+	defer func() {
+		close(filesRead)
+		if r.replayed != nil {
+			r.replayed.Log()
+		}
+	}()
+
+	if r.s.progressInterval > 0 && len(files) > 0 {
+		r.replayed = newProgress(files)
+		go r.replayed.Run(r.s.progressInterval, filesRead)
+	}
+
+	if r.s.workers > 1 && len(files) > 1 {
+		return r.readShards(in, files)
+	}
+
+	for _, input := range files {
+		slog.Debug("processing miniseed file", "file", input)
+		r.current = input
+
+		r.report.Files++
+
+		err := r.pipeline.Run(msimpact.SourceFunc(func(handler func(msimpact.Record) error) error {
+			var records int
+			return readRecords(input, in.size, msr, r.limit(func(msr msimpact.Record) error {
+				if !r.inWindow(in.window, msr) {
+					return nil
+				}
+				if r.s.maxRecords > 0 && records >= r.s.maxRecords {
+					return errStop
+				}
+				records++
+
+				return r.watch(r.paced(handler))(msr)
+			}))
+		}))
+		if err != nil && !skippedInput(err) {
+			return err
+		}
+		r.replayed.Done(input)
+
+		if r.done() {
+			break
+		}
+	}
+
+	return nil
+}
+
+// readShards shares the files amongst workers, each with its own copy of the stream config, config
+// changes are only picked up once all the files have been read.
+func (r *run) readShards(in *fileInputs, files []string) error {
+	var mu sync.Mutex
+	var pipelines []*msimpact.Pipeline
+	var shards []*msimpact.StreamProcessor
+	for i := 0; i < r.s.workers; i++ {
+		p := r.processor
+		if i > 0 {
+			set, err := parseConfig(r.source.Name(), r.raw)
+			if err != nil {
+				return err
+			}
+			if p, err = msimpact.NewStreamProcessor(r.options, set); err != nil {
+				return err
+			}
+			shards = append(shards, p)
+		}
+		pipelines = append(pipelines, sharedPipeline(&r.pipeline, r.shift.Processor(p), &mu))
+	}
+
+	r.report.Files += len(files)
+	err := shardRecords(files, r.s.workers, pipelines, func(input string, msr *mseed.MSRecord, handler func(msimpact.Record) error) error {
+		slog.Debug("processing miniseed file", "file", input)
+
+		var records int
+		err := readRecords(input, in.size, msr, func(msr msimpact.Record) error {
+			mu.Lock()
+			var skip bool
+			err := r.limit(func(msr msimpact.Record) error {
+				if skip = !r.wanted(msr) || !r.inWindow(in.window, msr); skip {
+					return nil
+				}
+				if r.s.maxRecords > 0 && records >= r.s.maxRecords {
+					return errStop
+				}
+				records++
+				r.tally(msr)
+				return nil
+			})(msr)
+			mu.Unlock()
+			if err != nil || skip {
+				return err
+			}
+			if !r.pace.wait(msr.Starttime(), r.expired) {
+				mu.Lock()
+				defer mu.Unlock()
+				r.stopped()
+				return errStop
+			}
+			return handler(msr)
+		})
+		if err != nil && !skippedInput(err) {
+			return err
+		}
+		r.replayed.Done(input)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if r.done() {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return err
+	}
+
+	// later input carries on from the state of every shard
+	for _, p := range shards {
+		if err := r.processor.Restore(p.State()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFDSN processes historical data from a web service.
+func (r *run) readFDSN(in *fileInputs, msr *mseed.MSRecord) error {
+	start, err := time.Parse(time.RFC3339, r.s.fdsnStart)
+	if err != nil {
+		return fmt.Errorf("unable to decode fdsn start time %q: %s", r.s.fdsnStart, err)
+	}
+	end := time.Now()
+	if r.s.fdsnEnd != "" {
+		if end, err = time.Parse(time.RFC3339, r.s.fdsnEnd); err != nil {
+			return fmt.Errorf("unable to decode fdsn end time %q: %s", r.s.fdsnEnd, err)
+		}
+	}
+
+	slog.Debug("requesting streams", "service", r.s.fdsn, "streams", len(r.streams))
+	r.current = r.s.fdsn
+	body, err := fetchDataselect(r.s.fdsn, r.streams, start, end, r.s.fdsnTimeout)
+	if err != nil || body == nil {
+		return err
+	}
+	defer body.Close()
+
+	err = r.pipeline.Run(msimpact.SourceFunc(func(handler func(msimpact.Record) error) error {
+		return readArchive(r.s.fdsn, body, in.size, msr, r.limit(r.watch(r.paced(handler))))
+	}))
+	if err != nil && err != errStop && !skippedInput(err) {
+		return err
+	}
+	return nil
+}
+
+// realtime is the pipeline for real-time inputs, each stream is processed in its own goroutine
+// so a slow stream does not hold up the others.
+func (r *run) realtime() *msimpact.Pipeline {
+	p := &r.pipeline
+	if r.s.streamQueue > 0 {
+		p = sharedPipeline(&r.pipeline, r.shift.Processor(r.processor), &sync.Mutex{})
+		p.Queue = r.s.streamQueue
+	}
+	p.Reorder = r.s.reorder
+	return p
+}
+
+// client is a real-time input, providing records until stopped.
+type client interface {
+	Run(msr *mseed.MSRecord, stop <-chan struct{}, handler func(msimpact.Record) error) error
+}
+
+// readRealtime processes the records of a real-time input until the run is stopped.
+func (r *run) readRealtime(p *msimpact.Pipeline, c client, current, live string, msr *mseed.MSRecord) error {
+	r.current, r.live = current, live
+	err := p.Run(msimpact.SourceFunc(func(handler func(msimpact.Record) error) error {
+		return c.Run(msr, r.expired, r.watch(handler))
+	}))
+	if err != nil {
+		return err
+	}
+	select {
+	case <-r.expired:
+		r.stopped()
+	default:
+	}
+	return nil
+}
+
+// read processes the files, then any fdsn request, then any real-time inputs, stopping early if the run is halted.
+func (r *run) read(in *fileInputs, files, follow []string, msr *mseed.MSRecord) error {
+	if err := r.readFiles(in, files, msr); err != nil {
+		return err
+	}
+
+	if r.s.fdsn != "" && !r.done() {
+		if err := r.readFDSN(in, msr); err != nil {
+			return err
+		}
+	}
+
+	realtime := r.realtime()
+
+	// records appended to growing files
+	if r.s.follow && !r.done() {
+		c := newTailer(follow, r.s.followInterval, in.size, r.s.followStart)
+		if err := r.readRealtime(realtime, c, strings.Join(follow, ","), "", msr); err != nil {
+			return err
+		}
+	}
+
+	// continuous real-time processing
+	if r.s.seedlink != "" && !r.done() {
+		c := newSeedlinkClient(r.s.seedlink, r.s.seedlinkTimeout, r.streams)
+		if err := r.readRealtime(realtime, c, r.s.seedlink, "seedlink", msr); err != nil {
+			return err
+		}
+	}
+
+	// continuous processing from a ringserver
+	if r.s.datalink != "" && !r.done() {
+		c := newDatalinkClient(r.s.datalink, r.s.datalinkTimeout, r.streams)
+		if err := r.readRealtime(realtime, c, r.s.datalink, "datalink", msr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
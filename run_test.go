@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"github.com/ozym/impact"
+	"github.com/ozym/msimpact/msimpact"
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"testing"
+	"time"
+)
+
+// testRun builds a run for a single configured stream, delivering to a test sink.
+func testRun(t *testing.T, args ...string) (*run, *summary, *msimpacttest.Sink, error) {
+	t.Helper()
+
+	var s settings
+	fs := flag.NewFlagSet("msimpact", flag.ContinueOnError)
+	s.register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	config := msimpact.Config{
+		Streams: map[string]*impact.Stream{
+			"NZ_WEL_20_HNZ": {Name: "Wellington", Latitude: -41.3, Longitude: 174.8, Q: 0.98, Rate: 100.0, Gain: 1.0e6},
+		},
+		Settings: make(map[string]msimpact.StreamConfig),
+		Entries:  make(map[string][]byte),
+	}
+
+	var report summary
+	sink := &msimpacttest.Sink{}
+	r, err := newRun(&s, &report, newConfigSource("impact.json", nil), nil, &config, sink, sink, nil)
+	return r, &report, sink, err
+}
+
+func TestRunRecords(t *testing.T) {
+	r, report, _, err := testRun(t, "-reject", "AU_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.streams) != 1 || r.streams[0] != "NZ_WEL_20_HNZ" {
+		t.Errorf("unexpected configured streams: %v", r.streams)
+	}
+
+	start := time.Date(2016, time.November, 13, 11, 0, 0, 0, time.UTC)
+	var records msimpacttest.Source
+	for i := 0; i < 10; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		records = append(records, msimpacttest.NewRecord("NZ_WEL_20_HNZ", at, 100.0, make([]int32, 100)))
+		if i < 2 {
+			records = append(records, msimpacttest.NewRecord("NZ_FOO_20_HNZ", at, 100.0, make([]int32, 100)))
+		}
+		if i < 3 {
+			records = append(records, msimpacttest.NewRecord("AU_WEL_20_HNZ", at, 100.0, make([]int32, 100)))
+		}
+	}
+
+	source := msimpact.SourceFunc(func(handler func(msimpact.Record) error) error {
+		return records.Records(r.limit(r.watch(handler)))
+	})
+	if err := r.pipeline.Run(source); err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 12 || report.Rejected != 3 {
+		t.Errorf("expected 12 records and 3 rejected, got %d and %d", report.Records, report.Rejected)
+	}
+	if len(report.Missing) == 0 || report.Missing[0] != "NZ_FOO_20_HNZ" {
+		t.Errorf("expected a missing stream, got %v", report.Missing)
+	}
+
+	// once halted no more records are read
+	r.halt("interrupt")
+	r.halt("runtime")
+	if err := r.pipeline.Run(source); err != errStop {
+		t.Errorf("expected the run to stop, got %v", err)
+	}
+	if !report.Interrupted || report.TimedOut || !r.done() {
+		t.Errorf("expected the run to be interrupted, got %+v", report)
+	}
+	if report.Records != 12 {
+		t.Errorf("expected no more records, got %d", report.Records)
+	}
+}
+
+func TestRunWindow(t *testing.T) {
+	r, report, _, err := testRun(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2016, time.November, 13, 11, 0, 0, 0, time.UTC)
+	window := timeWindow{start: start, end: start.Add(time.Minute)}
+	for _, at := range []time.Time{start.Add(-time.Hour), start, start.Add(30 * time.Second), start.Add(time.Hour)} {
+		r.inWindow(window, msimpacttest.NewRecord("NZ_WEL_20_HNZ", at, 100.0, make([]int32, 100)))
+	}
+	if report.OutsideWindow != 2 {
+		t.Errorf("expected 2 records outside the window, got %d", report.OutsideWindow)
+	}
+}
+
+func TestRunSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  bool
+	}{
+		{"defaults", nil, false},
+		{"minimum", []string{"-min-mmi", "3"}, false},
+		{"flush", []string{"-flush", "increase,min=3"}, false},
+		{"both minimums", []string{"-min-mmi", "3", "-flush", "min=4"}, true},
+		{"unknown flush", []string{"-flush", "sometimes"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := testRun(t, tt.args...)
+			switch {
+			case tt.err && err == nil:
+				t.Error("expected an error")
+			case !tt.err && err != nil:
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seedlink packets are a short header followed by a fixed size miniseed record
+const (
+	seedlinkHeader = 8
+	seedlinkRecord = 512
+)
+
+// seedlinkClient subscribes to a seedlink server and passes each received record to a handler,
+// the connection is re-established on any failure, resuming from the last seen packet.
+type seedlinkClient struct {
+	addr    string
+	timeout time.Duration
+
+	// selectors keyed by "NET STA"
+	stations map[string][]string
+
+	// last received sequence number keyed by "NET STA"
+	seq map[string]int64
+}
+
+// newSeedlinkClient builds the station subscriptions from a list of stream names in the form NN_SSS_LL_CCC.
+func newSeedlinkClient(addr string, timeout time.Duration, streams []string) *seedlinkClient {
+	client := seedlinkClient{
+		addr:     addr,
+		timeout:  timeout,
+		stations: make(map[string][]string),
+		seq:      make(map[string]int64),
+	}
+
+	for _, s := range streams {
+		parts := strings.Split(s, "_")
+		if len(parts) != 4 {
+			continue
+		}
+		key := parts[0] + " " + parts[1]
+		client.stations[key] = append(client.stations[key], parts[2]+parts[3]+".D")
+	}
+
+	return &client
+}
+
+// Run receives records until the stop channel is closed.
+func (c *seedlinkClient) Run(msr *mseed.MSRecord, stop <-chan struct{}, handler func(msimpact.Record) error) error {
+	return reconnect("seedlink", stop, func() error {
+		return c.session(msr, stop, handler)
+	})
+}
+
+func (c *seedlinkClient) session(msr *mseed.MSRecord, stop <-chan struct{}, handler func(msimpact.Record) error) error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// unblock any reads once stopped
+	defer closeOnStop(stop, conn)()
+
+	in := bufio.NewReader(conn)
+
+	command := func(cmd string, reply bool) error {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+		if _, err := fmt.Fprintf(conn, "%s\r\n", cmd); err != nil {
+			return err
+		}
+		if !reply {
+			return nil
+		}
+		resp, err := in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if r := strings.TrimSpace(resp); r != "OK" {
+			return fmt.Errorf("seedlink command %q failed: %s", cmd, r)
+		}
+		return nil
+	}
+
+	var keys []string
+	for k := range c.stations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		parts := strings.Fields(k)
+		if err := command("STATION "+parts[1]+" "+parts[0], true); err != nil {
+			return err
+		}
+		for _, s := range c.stations[k] {
+			if err := command("SELECT "+s, true); err != nil {
+				return err
+			}
+		}
+		data := "DATA"
+		if seq, ok := c.seq[k]; ok {
+			data = fmt.Sprintf("DATA %06X", (seq+1)&0xffffff)
+		}
+		if err := command(data, true); err != nil {
+			return err
+		}
+	}
+	if err := command("END", false); err != nil {
+		return err
+	}
+
+	pkt := make([]byte, seedlinkHeader+seedlinkRecord)
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.timeout))
+		if _, err := io.ReadFull(in, pkt); err != nil {
+			return err
+		}
+		if string(pkt[0:2]) != "SL" {
+			return fmt.Errorf("invalid seedlink packet header: %q", pkt[0:seedlinkHeader])
+		}
+
+		// decode mseed block, real-time streams carry on regardless of the number of bad records
+		if err := unpack(msr, pkt[seedlinkHeader:]); err != nil {
+			undecodable.Skipped(c.addr, 0, err)
+			continue
+		}
+
+		key := strings.TrimRight(msr.Network(), "\u0000 ") + " " + strings.TrimRight(msr.Station(), "\u0000 ")
+		if seq, err := strconv.ParseInt(string(pkt[2:seedlinkHeader]), 16, 64); err == nil {
+			c.seq[key] = seq
+		}
+
+		if err := handler(msr); err != nil {
+			return err
+		}
+	}
+}
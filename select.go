@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// streamPatterns collects repeated, or comma separated, stream name patterns, either wildcards,
+// e.g. NZ_WEL_*, or regular expressions between slashes, e.g. /^NZ_.*_HN[ZNE]$/, which may hold commas.
+type streamPatterns struct {
+	patterns  []string
+	wildcards []string
+	regexps   []*regexp.Regexp
+}
+
+func (s *streamPatterns) String() string {
+	return strings.Join(s.patterns, ",")
+}
+
+func (s *streamPatterns) Set(value string) error {
+	patterns, err := splitPatterns(value)
+	if err != nil {
+		return err
+	}
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return fmt.Errorf("invalid stream expression %q: %s", p, err)
+			}
+			s.regexps = append(s.regexps, re)
+		} else {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid stream pattern %q: %s", p, err)
+			}
+			s.wildcards = append(s.wildcards, p)
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	return nil
+}
+
+// splitPatterns splits a list of patterns on commas, other than those inside a regular expression
+// between slashes, an escaped slash does not end an expression.
+func splitPatterns(value string) ([]string, error) {
+	var patterns []string
+	for rest := value; ; {
+		if rest = strings.TrimLeft(rest, " \t"); strings.HasPrefix(rest, "/") {
+			end := 1
+			for ; end < len(rest) && rest[end] != '/'; end++ {
+				if rest[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(rest) {
+				return nil, fmt.Errorf("unterminated stream expression %q", rest)
+			}
+			patterns = append(patterns, rest[:end+1])
+			if rest = strings.TrimSpace(rest[end+1:]); rest == "" {
+				return patterns, nil
+			}
+			if !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("unexpected %q after stream expression %q", rest, patterns[len(patterns)-1])
+			}
+			rest = rest[1:]
+			continue
+		}
+
+		p, next, more := strings.Cut(rest, ",")
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+		if !more {
+			return patterns, nil
+		}
+		rest = next
+	}
+}
+
+// empty checks whether any patterns have been given.
+func (s *streamPatterns) empty() bool {
+	return len(s.patterns) == 0
+}
+
+// matches checks whether a stream name matches any of the patterns.
+func (s *streamPatterns) matches(name string) bool {
+	for _, re := range s.regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	for _, p := range s.wildcards {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// streamSelection limits processing to matching streams which have not been rejected.
+type streamSelection struct {
+	match, reject streamPatterns
+}
+
+// selected checks whether a stream should be processed.
+func (s *streamSelection) selected(name string) bool {
+	if !s.match.empty() && !s.match.matches(name) {
+		return false
+	}
+	return !s.reject.matches(name)
+}
+
+// filter reduces a list of configured streams to those selected, wildcard entries are kept as the
+// streams they match are only known once their records arrive.
+func (s *streamSelection) filter(streams []string) []string {
+	var keep []string
+	for _, name := range streams {
+		if msimpact.IsWildcard(name) || s.selected(name) {
+			keep = append(keep, name)
+		}
+	}
+	return keep
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestStreamPatterns(t *testing.T) {
+	tests := []struct {
+		value    string
+		patterns int
+		match    []string
+		reject   []string
+		err      bool
+	}{
+		{value: "NZ_WEL_*", patterns: 1, match: []string{"NZ_WEL_20_HNZ"}, reject: []string{"NZ_WAZ_20_HNZ"}},
+		{value: "/HN[12]{1,2}/", patterns: 1, match: []string{"NZ_WEL_20_HN12"}, reject: []string{"NZ_WEL_20_HNZ"}},
+		{value: "NZ_WEL_*, /^NZ_.*_HN[ZNE]$/", patterns: 2, match: []string{"NZ_WEL_20_HN1", "NZ_WAZ_20_HNE"}, reject: []string{"NZ_WAZ_20_HN1"}},
+		{value: "/_HN[ZE]{1,2}$/,NZ_TEST_*", patterns: 2, match: []string{"NZ_WAZ_20_HNZ", "NZ_TEST_20_HN1"}, reject: []string{"NZ_WAZ_20_HN1"}},
+		{value: `/NZ\/WEL/`, patterns: 1, match: []string{"NZ/WEL"}, reject: []string{"NZ_WEL"}},
+		{value: "NZ_WEL_*,,", patterns: 1},
+		{value: "/HN", err: true},
+		{value: "NZ_WEL_*,/HN[12]{1,2}", err: true},
+		{value: "/HN[/", err: true},
+		{value: "/HN/x", err: true},
+		{value: "NZ_[WEL", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var s streamPatterns
+			err := s.Set(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got patterns %v", s.patterns)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.patterns) != tt.patterns {
+				t.Errorf("expected %d patterns, got %d: %v", tt.patterns, len(s.patterns), s.patterns)
+			}
+			for _, name := range tt.match {
+				if !s.matches(name) {
+					t.Errorf("expected %s to match %q", name, tt.value)
+				}
+			}
+			for _, name := range tt.reject {
+				if s.matches(name) {
+					t.Errorf("expected %s not to match %q", name, tt.value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ozym/impact"
+	"github.com/ozym/msimpact/msimpact"
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"io"
+	"math"
+	"time"
+)
+
+// the synthetic streams used by the self test, one stays quiet apart from a small offset, the other is shaken part way through
+const (
+	selftestQuiet  = "XX_QUIET_10_HNZ"
+	selftestShaken = "XX_SHAKE_10_HNZ"
+)
+
+// self test waveform settings, amplitudes are in physical units, i.e. before the gain is applied
+const (
+	selftestRate     = 100.0
+	selftestGain     = 1.0e6
+	selftestDuration = 180
+	selftestQuietAmp = 1.0e-5
+	selftestShakeAmp = 1.0
+)
+
+// selftestWaveform generates one second of samples, a 2 Hz sine of the given amplitude plus an offset.
+func selftestWaveform(second int, amplitude, offset float64) []int32 {
+	samples := make([]int32, int(selftestRate))
+	for i := range samples {
+		t := float64(second) + float64(i)/selftestRate
+		samples[i] = int32(math.Round(selftestGain * (amplitude*math.Sin(2.0*math.Pi*2.0*t) + offset)))
+	}
+	return samples
+}
+
+// selftest runs synthetic records for two streams through the full pipeline and checks the expected messages
+// are produced, the results of each check are written out and an error returned if any failed.
+func selftest(w io.Writer) error {
+	start := time.Date(2016, time.November, 13, 11, 0, 0, 0, time.UTC)
+
+	config := msimpact.Config{
+		Streams: map[string]*impact.Stream{
+			selftestQuiet:  {Name: "Self Test Quiet", Latitude: -41.0, Longitude: 174.5, Q: 0.98, Rate: selftestRate, Gain: selftestGain},
+			selftestShaken: {Name: "Self Test Shaken", Latitude: -41.5, Longitude: 174.0, Q: 0.98, Rate: selftestRate, Gain: selftestGain},
+		},
+		Settings: make(map[string]msimpact.StreamConfig),
+		Entries:  make(map[string][]byte),
+	}
+
+	processor, err := msimpact.NewStreamProcessor(msimpact.Options{
+		Probation:  time.Minute,
+		Level:      10,
+		InitialMMI: 0,
+		AllClear:   true,
+		Baseline:   2,
+		Clock:      msimpacttest.NewClock(start),
+	}, &config)
+	if err != nil {
+		return err
+	}
+
+	// the shaking starts after a minute and lasts for half a minute, the quiet stream has a step half way through
+	var records msimpacttest.Source
+	for s := 0; s < selftestDuration; s++ {
+		at := start.Add(time.Duration(s) * time.Second)
+
+		offset := 0.0
+		if s >= selftestDuration/2 {
+			offset = 10 * selftestQuietAmp
+		}
+		records = append(records, msimpacttest.NewRecord(selftestQuiet, at, selftestRate, selftestWaveform(s, selftestQuietAmp, offset)))
+
+		amplitude := selftestQuietAmp
+		if s >= 60 && s < 90 {
+			amplitude = selftestShakeAmp
+		}
+		records = append(records, msimpacttest.NewRecord(selftestShaken, at, selftestRate, selftestWaveform(s, amplitude, 0.0)))
+	}
+
+	var problems []error
+	sink := &msimpacttest.Sink{}
+	pipeline := msimpact.Pipeline{
+		Processor: processor,
+		Sink:      sink,
+		Problem: func(msr msimpact.Record, err error) {
+			problems = append(problems, fmt.Errorf("%s at %s: %s", msr.SrcName(0), msr.Starttime().Format(time.RFC3339), err))
+		},
+	}
+	if err := pipeline.Run(records); err != nil {
+		return err
+	}
+
+	messages, err := sink.Messages()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	check := func(ok bool, format string, args ...interface{}) {
+		result := "ok"
+		if !ok {
+			result, failed = "FAIL", failed+1
+		}
+		fmt.Fprintf(w, "%-4s %s\n", result, fmt.Sprintf(format, args...))
+	}
+
+	check(len(problems) == 0, "%d records processed, with %d problems", len(records), len(problems))
+	for _, p := range problems {
+		fmt.Fprintf(w, "     %s\n", p)
+	}
+
+	var quiet, shaken, cleared int32
+	for _, m := range messages {
+		switch {
+		case m.Stream == selftestQuiet && m.MMI > quiet:
+			quiet = m.MMI
+		case m.Stream == selftestShaken && m.Type == msimpact.AllClear:
+			cleared++
+		case m.Stream == selftestShaken && m.MMI > shaken:
+			shaken = m.MMI
+		}
+	}
+	check(shaken >= 5, "shaken stream reached MMI %d, expected at least 5", shaken)
+	check(cleared > 0, "shaken stream sent %d all-clear messages, expected at least one", cleared)
+	check(quiet <= 2, "quiet stream reached MMI %d, expected no more than 2", quiet)
+
+	var encoded int
+	for _, s := range sink.Sent() {
+		if _, err := encodeProtobuf(s.Key, s.Message); err != nil {
+			check(false, "unable to encode %s message as protobuf: %s", s.Key, err)
+			continue
+		}
+		if _, err := encodeGeoJSON(s.Key, s.Message); err != nil {
+			check(false, "unable to encode %s message as geojson: %s", s.Key, err)
+			continue
+		}
+		encoded++
+	}
+	check(encoded == len(messages), "%d of %d messages encoded as protobuf and geojson", encoded, len(messages))
+
+	if failed > 0 {
+		return fmt.Errorf("self test failed %d checks", failed)
+	}
+	return nil
+}
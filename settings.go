@@ -0,0 +1,390 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+// settings are the values of the command line flags, shared by every command.
+type settings struct {
+	// runtime settings
+	verbose      bool
+	logFormat    string
+	logLevel     string
+	dryrun       bool
+	selfTest     bool
+	benchmarking bool
+	replay       bool
+	replayShift  string
+	speed        float64
+
+	// input file handling
+	reclen      string
+	sortOrder   string
+	gapMessages bool
+	duplicates  int
+	workers     int
+	streamQueue int
+	reorder     int
+
+	// growing files
+	follow         bool
+	followInterval time.Duration
+	followStart    bool
+
+	// incremental processing
+	since      string
+	checkpoint string
+	selection  streamSelection
+	startTime  string
+	endTime    string
+
+	// stream state across restarts
+	stateFile     string
+	stateInterval time.Duration
+	stateAge      time.Duration
+
+	// undeliverable messages
+	maxSize         int
+	rateLimit       float64
+	rateBurst       int
+	streamRateLimit float64
+	streamRateBurst int
+	deadLetterFile  string
+
+	// ordered output
+	ordered       bool
+	orderedMemory int
+	orderedDir    string
+
+	// startup behaviour
+	initialMMI int
+
+	// event lifecycle
+	allClear   bool
+	baseline   int
+	scales     string
+	flushRules string
+	minMMI     int
+	maxWindow  time.Duration
+	heartbeat  time.Duration
+
+	// diagnostics
+	showVersion bool
+	showSinks   bool
+	dumpHeaders bool
+	dumpFormat  string
+
+	// run reporting
+	summaryJSON string
+
+	// monitoring
+	httpAddr     string
+	apiAddr      string
+	debugAddr    string
+	statsdAddr   string
+	statsdPrefix string
+	statsdTags   string
+	maxLatency   time.Duration
+	healthAge    time.Duration
+
+	// scheduled runs
+	maxRuntime      time.Duration
+	shutdownTimeout time.Duration
+
+	// long replays
+	progressInterval time.Duration
+
+	// quick checks
+	maxErrors  int
+	maxRecords int
+
+	// real-time input
+	seedlink        string
+	seedlinkTimeout time.Duration
+	datalink        string
+	datalinkTimeout time.Duration
+
+	// web service input
+	fdsn        string
+	fdsnStart   string
+	fdsnEnd     string
+	fdsnTimeout time.Duration
+
+	// streaming channel information
+	config        string
+	configRefresh time.Duration
+	configRegion  string
+
+	// amazon queue details
+	region            string
+	queue             string
+	key               string
+	secret            string
+	roleARN           string
+	externalID        string
+	queueOwner        string
+	fifoDedup         string
+	sqsEndpoint       string
+	queueCreate       bool
+	queueAttributes   string
+	failoverQueue     string
+	failoverRegion    string
+	failoverAttempts  int
+	failoverThreshold int
+	failbackInterval  time.Duration
+	retryAttempts     int
+	retryElapsed      time.Duration
+	retryDelay        time.Duration
+	spoolDir          string
+	spoolBytes        int64
+	spoolAge          time.Duration
+	spoolInterval     time.Duration
+	batchSize         int
+	batchInterval     time.Duration
+	roundtripTest     bool
+	roundtripTimeout  time.Duration
+
+	// amazon topic output
+	topic string
+
+	// amazon data stream output
+	kinesisStream string
+
+	// kafka output
+	kafkaBrokers string
+	kafkaTopic   string
+	kafkaKey     string
+	kafkaAcks    string
+
+	// nats output
+	natsURL       string
+	natsSubject   string
+	natsJetStream bool
+
+	// mqtt output
+	mqttBroker string
+	mqttTopic  string
+	mqttQoS    int
+	mqttCA     string
+	mqttCert   string
+	mqttKey    string
+
+	// webhook output
+	webhookURL     string
+	webhookHeaders headerList
+	webhookTimeout time.Duration
+	webhookBatch   int
+
+	// local file output
+	outFile     string
+	outMaxBytes int64
+	outDaily    bool
+
+	// per output filtering
+	sinkMMI     sinkOptions
+	sinkStreams sinkOptions
+	format      string
+	sinkFormat  sinkOptions
+
+	// message signing
+	signKeyFile string
+
+	// common alerting protocol messages
+	capSender string
+	capMMI    int
+	capRadius float64
+
+	// local socket output
+	unixSocket string
+	unixListen bool
+
+	// websocket output
+	serveWS string
+
+	// grpc output
+	serveGRPC string
+
+	// noisy channel detection
+	probation time.Duration
+	level     int
+	warnLevel int
+}
+
+// register defines the command line flags, each command is then limited to those of its groups.
+func (s *settings) register(fs *flag.FlagSet) {
+	fs.BoolVar(&s.verbose, "verbose", false, "make noise, printing each message and logging at the debug level")
+	fs.StringVar(&s.logFormat, "log-format", "console", "log format, either console or json")
+	fs.StringVar(&s.logLevel, "log-level", "info", "lowest level to log: debug, info, warn or error")
+	fs.BoolVar(&s.dryrun, "dry-run", false, "don't actually send the messages")
+	fs.BoolVar(&s.selfTest, "selftest", false, "run synthetic records through the pipeline, checking the expected messages are produced, then exit")
+	fs.BoolVar(&s.benchmarking, "bench", false, "process the input without sending messages, reporting the throughput, time spent in each stage, and peak memory")
+	fs.BoolVar(&s.replay, "replay", false, "send current time rather than recorded time")
+	fs.StringVar(&s.replayShift, "replay-shift", "", "shift message times, keeping their spacing, by a duration, or so the first record is at an RFC3339 time or now")
+	fs.Float64Var(&s.speed, "speed", 0.0, "pace file records by their recorded times at this multiple of real time, e.g. 1 or 10, zero for as fast as possible")
+
+	fs.StringVar(&s.reclen, "reclen", "auto", "miniseed record length in bytes, or auto to use the blockette 1000 of each record, or to probe for the next record")
+	fs.StringVar(&s.sortOrder, "sort", "name", "order of files found in directories or glob patterns: name, time (of first record), or none")
+	fs.BoolVar(&s.gapMessages, "gap-messages", false, "send a message, with a Type of gap or overlap, for each discontinuity found in a stream, these are always logged")
+	fs.IntVar(&s.duplicates, "duplicates", 16, "skip records with the same start time as one of this many recent records of the stream, zero to disable")
+	fs.IntVar(&s.workers, "workers", 1, "number of files to read concurrently, records are still processed in turn for each stream")
+	fs.IntVar(&s.streamQueue, "stream-queue", 64, "records waiting for each stream when processing real-time input, each stream has its own goroutine, zero to process all streams in turn")
+
+	fs.IntVar(&s.reorder, "reorder", 0, "hold back up to this many records of each real-time stream to process them in start time order, zero to disable")
+
+	fs.BoolVar(&s.follow, "follow", false, "keep running, processing records as they are appended to the given files, directories or glob patterns")
+	fs.DurationVar(&s.followInterval, "follow-interval", time.Second, "how often to check followed files for new records")
+	fs.BoolVar(&s.followStart, "follow-from-start", false, "process the existing contents of followed files found at startup, rather than only new records")
+
+	fs.StringVar(&s.since, "since", "", "only process files modified since this duration ago or RFC3339 time")
+	fs.StringVar(&s.checkpoint, "checkpoint", "", "record the run start time in this file, used as the default for -since")
+	fs.Var(&s.selection.match, "match", "only process streams matching a wildcard, or /regexp/, pattern, comma separated and may be repeated")
+	fs.Var(&s.selection.reject, "reject", "skip streams matching a wildcard, or /regexp/, pattern, comma separated and may be repeated")
+	fs.StringVar(&s.startTime, "starttime", "", "skip file records that end before this RFC3339 time or date")
+	fs.StringVar(&s.endTime, "endtime", "", "skip file records that start at or after this RFC3339 time or date")
+
+	fs.StringVar(&s.stateFile, "state-file", "", "periodically save the last intensity of each stream to this file, and restore it at startup")
+	fs.DurationVar(&s.stateInterval, "state-interval", time.Minute, "how often to save the stream state")
+	fs.DurationVar(&s.stateAge, "state-max-age", time.Hour, "ignore a state file saved longer ago than this, zero for no limit")
+
+	fs.IntVar(&s.maxSize, "max-message-size", 262144, "drop encoded messages larger than this many bytes, zero for no limit")
+	fs.Float64Var(&s.rateLimit, "rate-limit", 0, "most messages sent per second overall, excess messages are held with only the latest of each stream kept, zero for no limit")
+	fs.IntVar(&s.rateBurst, "rate-burst", 50, "messages that can be sent at once before -rate-limit applies")
+	fs.Float64Var(&s.streamRateLimit, "stream-rate-limit", 0, "most messages sent per second for each stream, e.g. 0.1 for one every 10s, zero for no limit")
+	fs.IntVar(&s.streamRateBurst, "stream-rate-burst", 5, "messages each stream can send at once before -stream-rate-limit applies")
+	fs.StringVar(&s.deadLetterFile, "dead-letter", "", "append undeliverable messages to this file")
+
+	fs.BoolVar(&s.ordered, "ordered", false, "buffer messages and send them in time order at the end of the run")
+	fs.IntVar(&s.orderedMemory, "ordered-memory", 64*1024*1024, "bytes of ordered messages to hold in memory before spilling to disk, zero for no limit")
+	fs.StringVar(&s.orderedDir, "ordered-dir", "", "directory to use for spilled ordered messages, defaults to the system temporary directory")
+
+	fs.IntVar(&s.initialMMI, "initial-mmi", -1, "intensity assumed for each stream at startup, the first message is only sent on a change from it, negative to disable")
+
+	fs.BoolVar(&s.allClear, "all-clear", false, "send an all-clear message when a stream returns to the baseline intensity")
+	fs.IntVar(&s.baseline, "baseline", 0, "the baseline intensity used for all-clear messages")
+	fs.StringVar(&s.scales, "scales", "", "comma separated alternative intensity scales to include in messages: jma, ems98")
+	fs.StringVar(&s.flushRules, "flush", "change", "which intensity changes to send: change, increase, reset=<interval>, every=<interval>, min=<mmi>, or a comma separated combination")
+	fs.IntVar(&s.minMMI, "min-mmi", 0, "don't send messages below this intensity, the same as -flush min=<mmi>, a stream's min_mmi overrides this")
+	fs.DurationVar(&s.maxWindow, "max-window", 0, "only send the largest intensity message of each station in each window of this length, e.g. 10s, zero to send every message")
+	fs.DurationVar(&s.heartbeat, "heartbeat", 0, "resend the current intensity of each active stream this often, flagged as a heartbeat, zero to only send changes")
+
+	fs.BoolVar(&s.showVersion, "version", false, "print the version and build information, then exit")
+	fs.BoolVar(&s.showSinks, "list-sinks", false, "list the available outputs and their flags, then exit")
+	fs.BoolVar(&s.dumpHeaders, "dump-headers", false, "print the decoded header of each record without processing, then exit")
+	fs.StringVar(&s.dumpFormat, "dump-format", "table", "format for dumped headers, either table or json")
+
+	fs.StringVar(&s.summaryJSON, "summary-json", "", "write a JSON summary of the run to this file when it finishes, use - for stdout or stderr for standard error")
+
+	fs.StringVar(&s.httpAddr, "http-addr", "", "serve prometheus metrics on /metrics, and health checks on /healthz and /readyz, at this address, e.g. :9090")
+	fs.StringVar(&s.apiAddr, "api-addr", "", "serve the current state of each stream as JSON on /streams, and a status page on /, at this address, e.g. :8081")
+	fs.StringVar(&s.debugAddr, "debug-addr", "", "serve pprof and expvar diagnostics at this address, e.g. localhost:6060")
+	fs.StringVar(&s.statsdAddr, "statsd", "", "push counters and timings to a StatsD agent at this address, e.g. localhost:8125")
+	fs.StringVar(&s.statsdPrefix, "statsd-prefix", "msimpact.", "prefix added to each StatsD metric name")
+	fs.StringVar(&s.statsdTags, "statsd-tags", "", "optional comma separated datadog style tags added to each StatsD metric, e.g. env:prod,site:wel")
+	fs.DurationVar(&s.maxLatency, "max-latency", 0, "warn about records that ended longer than this before they were read, zero to disable")
+	fs.DurationVar(&s.healthAge, "health-max-age", 10*time.Minute, "report unhealthy if no record has been processed for this long, zero for no limit")
+
+	fs.DurationVar(&s.maxRuntime, "max-runtime", 0, "stop reading input and exit once this long has passed, zero for no limit")
+	fs.DurationVar(&s.shutdownTimeout, "shutdown-timeout", 30*time.Second, "on an interrupt, how long to wait for outstanding messages to be sent before giving up")
+
+	fs.DurationVar(&s.progressInterval, "progress", 0, "log how far through the input files a run is this often, e.g. 1m, zero to disable")
+
+	fs.IntVar(&s.maxErrors, "max-errors", 0, "give up reading a file, archive member or fdsn request once more than this many of its records could not be decoded, zero for no limit")
+	fs.IntVar(&s.maxRecords, "max-records-per-file", 0, "stop processing each file after this many records, zero for no limit")
+
+	fs.StringVar(&s.seedlink, "seedlink", "", "receive records for the configured streams from a seedlink server (host:port)")
+	fs.DurationVar(&s.seedlinkTimeout, "seedlink-timeout", 2*time.Minute, "seedlink network timeout")
+	fs.StringVar(&s.datalink, "datalink", "", "receive records for the configured streams from a datalink ringserver (host:port)")
+	fs.DurationVar(&s.datalinkTimeout, "datalink-timeout", 2*time.Minute, "datalink network timeout")
+
+	fs.StringVar(&s.fdsn, "fdsn", "", "fetch records for the configured streams from an fdsn dataselect query url")
+	fs.StringVar(&s.fdsnStart, "start", "", "start of the fdsn request window (RFC3339)")
+	fs.StringVar(&s.fdsnEnd, "end", "", "end of the fdsn request window (RFC3339), defaults to now")
+	fs.DurationVar(&s.fdsnTimeout, "fdsn-timeout", 10*time.Minute, "fdsn request timeout")
+
+	fs.StringVar(&s.config, "config", "impact.json", "provide a streams config file or url (s3:// or http(s)://), either JSON, YAML (.yaml, .yml) or TOML (.toml)")
+	fs.DurationVar(&s.configRefresh, "config-refresh", 0, "how often to check the streams config for changes, zero to disable")
+	fs.StringVar(&s.configRegion, "config-region", "", "AWS region of an s3 streams config, defaults to the queue region")
+
+	fs.StringVar(&s.region, "region", "", "provide AWS region")
+	fs.StringVar(&s.queue, "queue", "", "send messages to the SQS queue")
+	fs.StringVar(&s.key, "key", "", "AWS access key id, overrides env and credentials file (default profile)")
+	fs.StringVar(&s.secret, "secret", "", "AWS secret key id, overrides env and credentials file (default profile)")
+	fs.StringVar(&s.roleARN, "role-arn", "", "assume this IAM role, possibly in another account, for all amazon requests")
+	fs.StringVar(&s.externalID, "external-id", "", "external id required by the assumed role, if any")
+	fs.StringVar(&s.queueOwner, "queue-owner", "", "account id owning a queue given by name, if not the current account")
+	fs.StringVar(&s.fifoDedup, "fifo-dedup", "time", "how duplicate messages are recognised by a fifo queue: time (stream name and message time) or content")
+	fs.StringVar(&s.sqsEndpoint, "sqs-endpoint", "", "SQS endpoint url, e.g. a VPC interface endpoint or http://localhost:4566 for LocalStack, rather than the regional AWS endpoint")
+	fs.BoolVar(&s.queueCreate, "create-queue", false, "create the SQS queue if it does not exist, e.g. for test environments")
+	fs.StringVar(&s.queueAttributes, "queue-attributes", "", "attributes of a created queue, e.g. MessageRetentionPeriod=345600,VisibilityTimeout=30")
+	fs.StringVar(&s.failoverQueue, "failover-queue", "", "secondary SQS queue, e.g. in another region, used while the primary queue is failing")
+	fs.StringVar(&s.failoverRegion, "failover-region", "", "AWS region of the secondary queue, if not given by its url")
+	fs.IntVar(&s.failoverAttempts, "failover-attempts", 3, "how many times to try sending a message to the primary queue before sending it to the secondary")
+	fs.IntVar(&s.failoverThreshold, "failover-threshold", 3, "messages in a row the primary queue fails to deliver before failing over to the secondary")
+	fs.DurationVar(&s.failbackInterval, "failback-interval", time.Minute, "how often to try the primary queue again while failed over")
+	fs.IntVar(&s.retryAttempts, "retry-attempts", 10, "how many times to try sending a message, zero for no limit")
+	fs.DurationVar(&s.retryElapsed, "retry-elapsed", 5*time.Minute, "longest time to spend retrying a message, zero for no limit")
+	fs.DurationVar(&s.retryDelay, "retry-delay", 250*time.Millisecond, "initial delay between send retries, doubled after each failure")
+	fs.StringVar(&s.spoolDir, "spool", "", "directory to hold messages that could not be sent, for sending later")
+	fs.Int64Var(&s.spoolBytes, "spool-max-bytes", 100*1024*1024, "discard the oldest spooled messages beyond this many bytes, zero for no limit")
+	fs.DurationVar(&s.spoolAge, "spool-max-age", 24*time.Hour, "discard spooled messages older than this, zero for no limit")
+	fs.DurationVar(&s.spoolInterval, "spool-interval", 30*time.Second, "how often to try sending spooled messages")
+	fs.IntVar(&s.batchSize, "batch", 0, "send up to this many messages per SQS message as a compressed batch, zero to disable")
+	fs.DurationVar(&s.batchInterval, "batch-interval", 5*time.Second, "send a partial -batch or -webhook-batch once its first message is this old, zero to wait for a full batch")
+	fs.BoolVar(&s.roundtripTest, "roundtrip-test", false, "send and receive back a test message via the queue, then exit")
+	fs.DurationVar(&s.roundtripTimeout, "roundtrip-timeout", time.Minute, "how long to wait for the roundtrip test message")
+
+	fs.StringVar(&s.topic, "sns", "", "publish messages to the SNS topic arn")
+
+	fs.StringVar(&s.kinesisStream, "kinesis", "", "put messages onto the kinesis data stream, partitioned by stream name")
+
+	fs.StringVar(&s.kafkaBrokers, "kafka", "", "produce messages to this comma separated list of kafka brokers")
+	fs.StringVar(&s.kafkaTopic, "kafka-topic", "impact", "kafka topic")
+	fs.StringVar(&s.kafkaKey, "kafka-key", "station", "kafka message key: station, stream or none")
+	fs.StringVar(&s.kafkaAcks, "kafka-acks", "all", "kafka acknowledgements required: all, local or none")
+
+	fs.StringVar(&s.natsURL, "nats", "", "publish messages to this nats server url")
+	fs.StringVar(&s.natsSubject, "nats-subject", "impact.{network}.{station}", "nats subject template")
+	fs.BoolVar(&s.natsJetStream, "nats-jetstream", false, "publish via jetstream for persistence")
+
+	fs.StringVar(&s.mqttBroker, "mqtt", "", "publish messages to this mqtt broker, e.g. tcp://localhost:1883 or ssl://broker:8883")
+	fs.StringVar(&s.mqttTopic, "mqtt-topic", "impact/{network}/{station}", "mqtt topic template")
+	fs.IntVar(&s.mqttQoS, "mqtt-qos", 1, "mqtt quality of service: 0, 1 or 2")
+	fs.StringVar(&s.mqttCA, "mqtt-ca", "", "mqtt broker ca certificate file")
+	fs.StringVar(&s.mqttCert, "mqtt-cert", "", "mqtt client certificate file")
+	fs.StringVar(&s.mqttKey, "mqtt-key", "", "mqtt client key file")
+
+	fs.StringVar(&s.webhookURL, "webhook", "", "post messages as JSON to this http(s) endpoint")
+	fs.Var(&s.webhookHeaders, "webhook-header", "extra \"Name: value\" request header, e.g. for authorization, may be repeated")
+	fs.DurationVar(&s.webhookTimeout, "webhook-timeout", 30*time.Second, "webhook request timeout")
+	fs.IntVar(&s.webhookBatch, "webhook-batch", 0, "post up to this many messages at once as a JSON array, zero to disable")
+
+	fs.StringVar(&s.outFile, "out", "", "append every message as a JSON line to this file, except with -dry-run")
+	fs.Int64Var(&s.outMaxBytes, "out-max-bytes", 0, "rotate the output file once it would grow past this size, zero to disable")
+	fs.BoolVar(&s.outDaily, "out-daily", false, "rotate the output file at the start of each day (UTC)")
+
+	s.sinkMMI = make(sinkOptions)
+	fs.Var(s.sinkMMI, "sink-mmi", "only send messages at or above an MMI to an output, e.g. kafka=4, may be repeated")
+	s.sinkStreams = make(sinkOptions)
+	fs.Var(s.sinkStreams, "sink-streams", "only send messages from matching streams to an output, e.g. file=NZ_WEL_*,NZ_SNZO_*, may be repeated")
+	fs.StringVar(&s.format, "format", "json", "message encoding for each output: json, geojson, cap, or protobuf (see msimpact.proto), base64 encoded for text only outputs")
+	s.sinkFormat = make(sinkOptions)
+	fs.Var(s.sinkFormat, "sink-format", "message encoding for an output, overriding -format, e.g. kafka=protobuf, may be repeated")
+
+	fs.StringVar(&s.signKeyFile, "sign-key-file", "", "sign each message with an HMAC-SHA256 using the shared secret in this file")
+
+	fs.StringVar(&s.capSender, "cap-sender", "msimpact", "sender identifier used in CAP alerts")
+	fs.IntVar(&s.capMMI, "cap-mmi", 5, "only send CAP alerts for messages at or above this MMI")
+	fs.Float64Var(&s.capRadius, "cap-radius", 10.0, "radius (km) of the alert area around the station in CAP alerts")
+
+	fs.StringVar(&s.unixSocket, "unix-socket", "", "send messages as NDJSON to a unix domain socket")
+	fs.BoolVar(&s.unixListen, "unix-listen", false, "listen on the unix socket for consumers rather than connecting to one")
+
+	fs.StringVar(&s.serveWS, "serve-ws", "", "broadcast every message to websocket clients connecting to this address, e.g. :8080")
+
+	fs.StringVar(&s.serveGRPC, "serve-grpc", "", "stream messages to grpc Subscribe calls on this address, e.g. :9090")
+
+	fs.DurationVar(&s.probation, "probation", 10.0*time.Minute, "noise probation window")
+	fs.IntVar(&s.level, "level", 2, "noise threshold level")
+	fs.IntVar(&s.warnLevel, "warn-level", 0, "noise warning level, below -level, for flagging possibly noisy messages, zero to disable")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestSettingsFlags(t *testing.T) {
+	var s settings
+	fs := flag.NewFlagSet("msimpact", flag.ContinueOnError)
+	s.register(fs)
+
+	// every flag belongs to a group, so each command can be built from them
+	grouped := make(map[string]bool)
+	for g, names := range flagGroups {
+		for _, n := range names {
+			if fs.Lookup(n) == nil {
+				t.Errorf("group %s refers to an unknown flag: %s", g, n)
+			}
+			grouped[n] = true
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if !grouped[f.Name] {
+			t.Errorf("flag %s is not in any group", f.Name)
+		}
+	})
+
+	if err := fs.Parse([]string{"-queue", "impact", "-sink-mmi", "kafka=4", "-match", "NZ_*", "a.mseed"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.queue != "impact" || s.sinkMMI["kafka"] != "4" || !s.selection.selected("NZ_WEL_20_HNZ") || s.selection.selected("AU_WEL_20_HNZ") {
+		t.Errorf("unexpected settings: %+v", s)
+	}
+	if s.reclen != "auto" || s.format != "json" || s.initialMMI != -1 {
+		t.Errorf("unexpected defaults, reclen %s, format %s and initial mmi %d", s.reclen, s.format, s.initialMMI)
+	}
+}
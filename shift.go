@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/ozym/msimpact/msimpact"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeShift moves message times by a fixed offset, keeping the timing between messages, the offset is
+// either given directly or found from the first record so that it appears to start at a given time.
+type timeShift struct {
+	offset time.Duration
+
+	// move the first record to this time, or to when it is processed if now is set
+	start time.Time
+	now   bool
+
+	once sync.Once
+}
+
+// parseTimeShift decodes a shift given as a duration, an RFC3339 start time, or "now", an empty string gives no shift.
+func parseTimeShift(s string) (*timeShift, error) {
+	switch s = strings.TrimSpace(s); {
+	case s == "":
+		return nil, nil
+	case s == "now":
+		return &timeShift{now: true}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return &timeShift{offset: d}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &timeShift{start: t}, nil
+}
+
+// Processor wraps a processor so its messages are shifted, processors sharing a shift share the offset.
+func (t *timeShift) Processor(p msimpact.Processor) msimpact.Processor {
+	if t == nil {
+		return p
+	}
+	return &shiftedProcessor{Processor: p, shift: t}
+}
+
+// first fixes any offset still to be found from the first record seen.
+func (t *timeShift) first(msr msimpact.Record) {
+	t.once.Do(func() {
+		switch {
+		case t.now:
+			t.offset = clock.Now().Sub(msr.Starttime())
+		case !t.start.IsZero():
+			t.offset = t.start.Sub(msr.Starttime())
+		}
+	})
+}
+
+type shiftedProcessor struct {
+	msimpact.Processor
+	shift *timeShift
+}
+
+func (s *shiftedProcessor) Process(msr msimpact.Record) (*msimpact.Message, error) {
+	s.shift.first(msr)
+	msg, err := s.Processor.Process(msr)
+	if msg != nil {
+		msg.Time = msg.Time.Add(s.shift.offset)
+	}
+	return msg, err
+}
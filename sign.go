@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"os"
+	"strings"
+)
+
+// the message attribute, or JSON field, holding a message signature
+const signatureName = "Signature"
+
+// the http header holding a webhook message signature
+const signatureHeader = "X-Msimpact-Signature"
+
+// messageSigner signs messages with a shared secret, so consumers can check where they came from.
+type messageSigner struct {
+	key []byte
+}
+
+// readSigningKey loads a shared secret from a file, surrounding white space is ignored.
+func readSigningKey(path string) (*messageSigner, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty signing key: %s", path)
+	}
+	return &messageSigner{key: key}, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of a message, or an empty string if no key has been given.
+func (s *messageSigner) Sign(msg []byte) string {
+	if s == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(msg)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signSink adds signatures to messages for outputs that can't carry them alongside the message, these
+// must be JSON objects and are given a trailing Signature field of the message as it was before the field was added.
+func signSink(name, format string, s msimpact.Sink, signer *messageSigner) (msimpact.Sink, error) {
+	if signer == nil {
+		return s, nil
+	}
+	for _, info := range sinkRegistry {
+		if info.Name != name {
+			continue
+		}
+		if info.Signed {
+			return s, nil
+		}
+		switch format {
+		case "", formatJSON, formatGeoJSON:
+			return &signedSink{Sink: s, signer: signer}, nil
+		default:
+			return nil, fmt.Errorf("unable to sign %s messages sent to the %s output", format, name)
+		}
+	}
+	return nil, fmt.Errorf("unknown output: %s", name)
+}
+
+// signedSink appends a signature field to each JSON message.
+type signedSink struct {
+	msimpact.Sink
+	signer *messageSigner
+}
+
+func (s *signedSink) Send(key string, msg []byte) error {
+	body := bytes.TrimSpace(msg)
+	if !bytes.HasSuffix(body, []byte("}")) {
+		return fmt.Errorf("unable to sign message, not a JSON object")
+	}
+
+	var b strings.Builder
+	b.Write(body[:len(body)-1])
+	if !bytes.HasSuffix(bytes.TrimSpace(body[:len(body)-1]), []byte("{")) {
+		b.WriteString(",")
+	}
+	fmt.Fprintf(&b, "%q:%q}", signatureName, s.signer.Sign(body))
+
+	return s.Sink.Send(key, []byte(b.String()))
+}
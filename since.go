@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// parseSince decodes either a duration before now, or an RFC3339 timestamp.
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(since))
+}
+
+// readCheckpoint recovers the time of the last run, a missing file gives a zero time.
+func readCheckpoint(path string) (time.Time, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return parseSince(string(b), time.Now())
+}
+
+// writeCheckpoint stores the time a run started, for use in the next run.
+func writeCheckpoint(path string, at time.Time) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(at.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// modifiedSince checks whether a file has been changed after the given time.
+func modifiedSince(path string, since time.Time) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return !info.ModTime().Before(since), nil
+}
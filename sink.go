@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sinkInfo describes an available output and the flags used to configure it.
+type sinkInfo struct {
+	Name        string
+	Description string
+	Flags       []string
+
+	// whether non JSON messages, protobuf or CAP, can be sent, and whether protobuf as raw bytes rather than base64 text
+	Protobuf bool
+	Binary   bool
+
+	// whether message signatures are sent alongside, rather than in, the message
+	Signed bool
+}
+
+// sinkRegistry is the list of available outputs, flag usage is taken from the flag definitions.
+var sinkRegistry = []sinkInfo{
+	{
+		Name:        "sqs",
+		Description: "send each message to an amazon SQS queue",
+		Flags:       []string{"queue", "queue-owner", "region", "key", "secret", "role-arn", "external-id", "sqs-endpoint", "fifo-dedup", "create-queue", "queue-attributes", "batch", "failover-queue", "failover-region", "failover-attempts", "failover-threshold", "failback-interval", "retry-attempts", "retry-elapsed", "retry-delay", "spool", "spool-max-bytes", "spool-max-age", "spool-interval"},
+		Protobuf:    true,
+		Signed:      true,
+	},
+	{
+		Name:        "sns",
+		Description: "publish each message to an amazon SNS topic",
+		Flags:       []string{"sns", "key", "secret", "role-arn", "external-id", "retry-attempts", "retry-elapsed", "retry-delay"},
+		Protobuf:    true,
+		Signed:      true,
+	},
+	{
+		Name:        "kinesis",
+		Description: "put each message onto an amazon kinesis data stream, partitioned by stream name",
+		Flags:       []string{"kinesis", "region", "key", "secret", "role-arn", "external-id", "retry-attempts", "retry-elapsed", "retry-delay"},
+		Protobuf:    true,
+		Binary:      true,
+	},
+	{
+		Name:        "kafka",
+		Description: "produce each message onto a kafka topic",
+		Flags:       []string{"kafka", "kafka-topic", "kafka-key", "kafka-acks"},
+		Protobuf:    true,
+		Binary:      true,
+	},
+	{
+		Name:        "nats",
+		Description: "publish each message onto a templated nats subject",
+		Flags:       []string{"nats", "nats-subject", "nats-jetstream"},
+		Protobuf:    true,
+		Binary:      true,
+	},
+	{
+		Name:        "mqtt",
+		Description: "publish each message onto a templated mqtt topic",
+		Flags:       []string{"mqtt", "mqtt-topic", "mqtt-qos", "mqtt-ca", "mqtt-cert", "mqtt-key"},
+		Protobuf:    true,
+		Binary:      true,
+	},
+	{
+		Name:        "webhook",
+		Description: "post each message, or batches of messages, as JSON to an http(s) endpoint",
+		Flags:       []string{"webhook", "webhook-header", "webhook-timeout", "webhook-batch", "retry-attempts", "retry-elapsed", "retry-delay"},
+		Signed:      true,
+	},
+	{
+		Name:        "file",
+		Description: "append each message as a JSON line to a local, optionally rotated, file",
+		Flags:       []string{"out", "out-max-bytes", "out-daily"},
+		Protobuf:    true,
+	},
+	{
+		Name:        "unix",
+		Description: "write NDJSON messages to a unix domain socket",
+		Flags:       []string{"unix-socket", "unix-listen"},
+		Protobuf:    true,
+	},
+	{
+		Name:        "websocket",
+		Description: "broadcast each JSON message to connected websocket clients, e.g. live dashboards",
+		Flags:       []string{"serve-ws"},
+	},
+	{
+		Name:        "grpc",
+		Description: "stream protobuf Impact messages to grpc Subscribe calls, filtered by network, station and intensity",
+		Flags:       []string{"serve-grpc"},
+	},
+}
+
+// listSinks describes each registered output along with the current flag settings.
+func listSinks(w io.Writer, flags *flag.FlagSet) error {
+	for _, s := range sinkRegistry {
+		fmt.Fprintf(w, "%s: %s\n", s.Name, s.Description)
+		for _, n := range s.Flags {
+			f := flags.Lookup(n)
+			if f == nil {
+				return fmt.Errorf("sink %s refers to an unknown flag: %s", s.Name, n)
+			}
+			if f.DefValue != "" {
+				fmt.Fprintf(w, "  -%s\t%s (default %q)\n", f.Name, f.Usage, f.DefValue)
+			} else {
+				fmt.Fprintf(w, "  -%s\t%s\n", f.Name, f.Usage)
+			}
+		}
+	}
+	return nil
+}
+
+// sqsSender is the part of the SQS client used to send messages, allowing a fake queue to be used instead.
+type sqsSender interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// sqsSink sends each message to an amazon SQS queue, given by its url.
+type sqsSink struct {
+	ctx    context.Context
+	client sqsSender
+	queue  string
+
+	// fifo queues are grouped by station, duplicates are recognised by either "content" or "time"
+	fifo  bool
+	dedup string
+
+	// adds a signature message attribute, if set
+	signer *messageSigner
+}
+
+// isFifoQueue checks whether a queue name or url refers to a fifo queue.
+func isFifoQueue(queue string) bool {
+	return strings.HasSuffix(queue, ".fifo")
+}
+
+// deduplicationID identifies a message for a fifo queue, either by a hash of its content or by the
+// stream name and message time, so that replayed data is not delivered twice.
+func deduplicationID(method, key string, msg []byte) string {
+	if method == "time" {
+		var m struct {
+			Time time.Time
+		}
+		if err := json.Unmarshal(msg, &m); err == nil && !m.Time.IsZero() {
+			return key + "-" + strconv.FormatInt(m.Time.UnixNano(), 10)
+		}
+	}
+	sum := sha256.Sum256(msg)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *sqsSink) Send(key string, msg []byte) error {
+	input := sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.queue),
+		MessageBody: aws.String(string(msg)),
+	}
+	if s.fifo {
+		input.MessageGroupId = aws.String(stationKey(key))
+		input.MessageDeduplicationId = aws.String(deduplicationID(s.dedup, key, msg))
+	}
+	if s.signer != nil {
+		input.MessageAttributes = map[string]types.MessageAttributeValue{
+			signatureName: {DataType: aws.String("String"), StringValue: aws.String(s.signer.Sign(msg))},
+		}
+	}
+	_, err := s.client.SendMessage(s.ctx, &input)
+	return err
+}
+
+func (s *sqsSink) Close() error {
+	return nil
+}
+
+// streamParts splits a stream name key into network, station, location and channel codes.
+func streamParts(key string) (string, string, string, string) {
+	parts := append(strings.SplitN(key, "_", 4), "", "", "", "")
+	return parts[0], parts[1], parts[2], parts[3]
+}
+
+// stationKey returns the NN.SSS station code of a stream name key.
+func stationKey(key string) string {
+	n, s, _, _ := streamParts(key)
+	return n + "." + s
+}
+
+// expandKey replaces {network}, {station}, {location} and {channel} in a template with the codes of a stream name key.
+func expandKey(template, key string) string {
+	n, s, l, c := streamParts(key)
+	return strings.NewReplacer("{network}", n, "{station}", s, "{location}", l, "{channel}", c).Replace(template)
+}
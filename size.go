@@ -0,0 +1,31 @@
+package main
+
+// smallest message size bucket
+const sizeBucket = 256
+
+// sizeHistogram counts encoded message sizes in power of two buckets,
+// each keyed by its upper bound in bytes.
+type sizeHistogram struct {
+	Buckets map[int]int
+	Count   int
+	Total   int
+	Max     int
+}
+
+func (h *sizeHistogram) Add(size int) {
+	if h.Buckets == nil {
+		h.Buckets = make(map[int]int)
+	}
+
+	bound := sizeBucket
+	for bound < size {
+		bound *= 2
+	}
+	h.Buckets[bound]++
+
+	h.Count++
+	h.Total += size
+	if size > h.Max {
+		h.Max = size
+	}
+}
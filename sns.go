@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"strings"
+)
+
+// snsSink publishes each message to an amazon SNS topic.
+type snsSink struct {
+	ctx    context.Context
+	client *sns.Client
+	topic  string
+
+	// adds a signature message attribute, if set
+	signer *messageSigner
+}
+
+func (s *snsSink) Send(key string, msg []byte) error {
+	input := sns.PublishInput{
+		Message:  aws.String(string(msg)),
+		TopicArn: aws.String(s.topic),
+	}
+	if s.signer != nil {
+		input.MessageAttributes = map[string]types.MessageAttributeValue{
+			signatureName: {DataType: aws.String("String"), StringValue: aws.String(s.signer.Sign(msg))},
+		}
+	}
+	_, err := s.client.Publish(s.ctx, &input)
+	return err
+}
+
+func (s *snsSink) Close() error {
+	return nil
+}
+
+// topicRegion extracts the region from a topic arn, i.e. arn:aws:sns:<region>:<account>:<name>.
+func topicRegion(arn string) (string, bool) {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 6 || parts[0] != "arn" || parts[2] != "sns" || parts[3] == "" {
+		return "", false
+	}
+	return parts[3], true
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spooled message file suffix
+const spoolSuffix = ".msg"
+
+// spoolSink stores messages that could not be sent in a local directory and periodically tries to
+// send them again, oldest first. While there are spooled messages any new messages are also spooled,
+// to keep them in order. The spool is bounded by both its total size and the age of the messages.
+type spoolSink struct {
+	msimpact.Sink
+
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	// where messages that cannot be delivered are kept, named after the output
+	output string
+	dead   *deadLetter
+
+	// the spooled messages, oldest first, and their total size, so the directory is only listed at startup
+	entries []spoolEntry
+	total   int64
+	seq     int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// serialises draining, which sends without holding the spool lock
+	draining sync.Mutex
+
+	sync.Mutex
+}
+
+// spoolEntry is a single spooled message file.
+type spoolEntry struct {
+	name string
+	size int64
+	at   time.Time
+}
+
+func newSpoolSink(s msimpact.Sink, dir string, maxBytes int64, maxAge, interval time.Duration, output string, dead *deadLetter) (*spoolSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	spool := spoolSink{
+		Sink:     s,
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		output:   output,
+		dead:     dead,
+		stop:     make(chan struct{}),
+	}
+
+	// any messages left over from a previous run
+	if err := spool.load(); err != nil {
+		return nil, err
+	}
+
+	spool.wg.Add(1)
+	go func() {
+		defer spool.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-spool.stop:
+				return
+			case <-ticker.C:
+				if err := spool.drain(); err != nil {
+					slog.Error("spool drain problem", "spool", spool.dir, "error", err)
+				}
+			}
+		}
+	}()
+
+	return &spool, nil
+}
+
+// load lists the spooled messages, oldest first.
+func (s *spoolSink) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.Type().IsRegular() || !strings.HasSuffix(f.Name(), spoolSuffix) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return err
+		}
+		s.entries = append(s.entries, spoolEntry{name: f.Name(), size: info.Size(), at: info.ModTime()})
+		s.total += info.Size()
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+	return nil
+}
+
+func (s *spoolSink) Send(key string, msg []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.entries) == 0 {
+		err := s.Sink.Send(key, msg)
+		if err == nil || isPermanent(err) {
+			return err
+		}
+		slog.Warn("spooling undelivered message", "stream", key, "error", err)
+	}
+
+	return s.store(key, msg)
+}
+
+// store writes a message to the spool, the first line of each file holds the message key.
+func (s *spoolSink) store(key string, msg []byte) error {
+	s.seq++
+	now := time.Now()
+	name := fmt.Sprintf("%020d-%06d%s", now.UnixNano(), s.seq%1000000, spoolSuffix)
+
+	b := append([]byte(key+"\n"), msg...)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, spoolEntry{name: name, size: int64(len(b)), at: now})
+	s.total += int64(len(b))
+
+	return s.enforce()
+}
+
+// enforce removes the oldest messages once the spool is too large, or holds messages that are too old.
+func (s *spoolSink) enforce() error {
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		tooBig := s.maxBytes > 0 && s.total > s.maxBytes
+		tooOld := s.maxAge > 0 && time.Since(e.at) > s.maxAge
+		if !tooBig && !tooOld {
+			break
+		}
+		slog.Warn("discarding spooled message", "file", e.name)
+		if err := s.remove(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove deletes the oldest spooled message.
+func (s *spoolSink) remove() error {
+	e := s.entries[0]
+	if err := os.Remove(filepath.Join(s.dir, e.name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.entries, s.total = s.entries[1:], s.total-e.size
+	return nil
+}
+
+// drain tries to send the spooled messages, stopping on the first failure. The spool is only locked
+// to take the oldest message and to remove it once sent, so new messages can be spooled meanwhile.
+func (s *spoolSink) drain() error {
+	s.draining.Lock()
+	defer s.draining.Unlock()
+
+	for {
+		e, ok, err := s.oldest()
+		if err != nil || !ok {
+			return err
+		}
+		b, err := os.ReadFile(filepath.Join(s.dir, e.name))
+		if err != nil {
+			return err
+		}
+		key, msg := "", b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			key, msg = string(b[:i]), b[i+1:]
+		}
+		if err := s.Sink.Send(key, msg); err != nil {
+			if !isPermanent(err) {
+				return nil
+			}
+			slog.Error("discarding undeliverable spooled message", "file", e.name, "error", err)
+			if err := s.dead.Failed(s.output, key, msg, err); err != nil {
+				slog.Error("unable to write dead letter", "output", s.output, "stream", key, "error", err)
+			}
+		}
+		if err := s.sent(e); err != nil {
+			return err
+		}
+	}
+}
+
+// oldest returns the oldest spooled message once any too large or too old have been discarded.
+func (s *spoolSink) oldest() (spoolEntry, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.enforce(); err != nil {
+		return spoolEntry{}, false, err
+	}
+	if len(s.entries) == 0 {
+		return spoolEntry{}, false, nil
+	}
+	return s.entries[0], true, nil
+}
+
+// sent removes a message which has been drained, unless it was discarded while being sent.
+func (s *spoolSink) sent(e spoolEntry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.entries) == 0 || s.entries[0].name != e.name {
+		return nil
+	}
+	return s.remove()
+}
+
+// Close makes a final attempt to send any spooled messages, any left will be sent on the next run.
+func (s *spoolSink) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+
+	if err := s.drain(); err != nil {
+		slog.Error("spool drain problem", "spool", s.dir, "error", err)
+	}
+	if len(s.entries) > 0 {
+		slog.Info("leaving messages in spool", "spool", s.dir, "count", len(s.entries))
+	}
+
+	return s.Sink.Close()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		sent     []string
+	}{
+		{"unbounded", 0, []string{`{"MMI":1}`, `{"MMI":2}`, `{"MMI":3}`, `{"MMI":4}`}},
+		{"bounded", 50, []string{`{"MMI":3}`, `{"MMI":4}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// nothing is delivered, so each message is spooled
+			failing := &msimpacttest.Sink{Err: errors.New("unavailable")}
+			spool, err := newSpoolSink(failing, dir, tt.maxBytes, 0, time.Hour, "sqs", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i <= 4; i++ {
+				if err := spool.Send("NZ_WEL_20_HNZ", []byte(fmt.Sprintf(`{"MMI":%d}`, i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := spool.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// the spool is picked up again on the next run, and sent in order
+			sink := &msimpacttest.Sink{}
+			spool, err = newSpoolSink(sink, dir, tt.maxBytes, 0, time.Hour, "sqs", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(spool.entries); n != len(tt.sent) {
+				t.Errorf("expected %d spooled messages, found %d", len(tt.sent), n)
+			}
+			if err := spool.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := sink.Sent()
+			if len(sent) != len(tt.sent) {
+				t.Fatalf("expected %d messages, got %d", len(tt.sent), len(sent))
+			}
+			for i, s := range sent {
+				if s.Key != "NZ_WEL_20_HNZ" || string(s.Message) != tt.sent[i] {
+					t.Errorf("unexpected message %d, %s: %s", i, s.Key, s.Message)
+				}
+			}
+			if spool.total != 0 || len(spool.entries) != 0 {
+				t.Errorf("expected an empty spool, found %d messages of %d bytes", len(spool.entries), spool.total)
+			}
+		})
+	}
+}
+
+func TestSpoolUndeliverable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	dead, err := openDeadLetter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err := newSpoolSink(&msimpacttest.Sink{Err: errors.New("unavailable")}, t.TempDir(), 0, 0, time.Hour, "sqs", dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := spool.Send("NZ_WEL_20_HNZ", []byte(fmt.Sprintf(`{"MMI":%d}`, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the spooled messages can now never be delivered
+	spool.Sink = &msimpacttest.Sink{Err: context.Canceled}
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dead.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(spool.entries) != 0 {
+		t.Errorf("expected an empty spool, found %d messages", len(spool.entries))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []deadLetterEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry deadLetterEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Output != "sqs" || e.Stream != "NZ_WEL_20_HNZ" || string(e.Message) != fmt.Sprintf(`{"MMI":%d}`, i+1) {
+			t.Errorf("unexpected dead letter %d: %+v", i, e)
+		}
+	}
+}
+
+// stallingSink holds up the first message sent until released.
+type stallingSink struct {
+	msimpacttest.Sink
+
+	once     sync.Once
+	stalled  chan struct{}
+	released chan struct{}
+}
+
+func (s *stallingSink) Send(key string, msg []byte) error {
+	s.once.Do(func() {
+		close(s.stalled)
+		<-s.released
+	})
+	return s.Sink.Send(key, msg)
+}
+
+func TestSpoolDrainUnlocked(t *testing.T) {
+	spool, err := newSpoolSink(&msimpacttest.Sink{Err: errors.New("unavailable")}, t.TempDir(), 0, 0, time.Hour, "sqs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Send("NZ_WEL_20_HNZ", []byte(`{"MMI":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &stallingSink{stalled: make(chan struct{}), released: make(chan struct{})}
+	spool.Sink = sink
+
+	drained := make(chan error)
+	go func() {
+		drained <- spool.drain()
+	}()
+	<-sink.stalled
+
+	// new messages are spooled while the oldest is still being sent
+	spooled := make(chan error)
+	go func() {
+		spooled <- spool.Send("NZ_WEL_20_HNZ", []byte(`{"MMI":2}`))
+	}()
+	select {
+	case err := <-spooled:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("spooling blocked by a drain")
+	}
+
+	close(sink.released)
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := sink.Sent()
+	if len(sent) != 2 || string(sent[0].Message) != `{"MMI":1}` || string(sent[1].Message) != `{"MMI":2}` {
+		t.Errorf("expected both messages in order, got %v", sent)
+	}
+}
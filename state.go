@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ozym/msimpact/msimpact"
+	"os"
+	"time"
+)
+
+// savedState is the content of a state file.
+type savedState struct {
+	Saved   time.Time
+	Streams map[string]msimpact.StreamState
+}
+
+// readState recovers saved stream state, a missing file, or one saved longer ago than the
+// maximum age, zero for no limit, gives no state.
+func readState(path string, maxAge time.Duration) (map[string]msimpact.StreamState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var s savedState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if maxAge > 0 && time.Since(s.Saved) > maxAge {
+		return nil, nil
+	}
+	return s.Streams, nil
+}
+
+// writeState stores the stream state, replacing any previous file in one step.
+func writeState(path string, streams map[string]msimpact.StreamState) error {
+	b, err := json.MarshalIndent(savedState{Saved: time.Now().UTC(), Streams: streams}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsd pushes counters and timings to a StatsD (or Datadog) agent over UDP, the zero value
+// discards everything so it can be used whether or not an agent has been given.
+type statsd struct {
+	conn   net.Conn
+	prefix string
+	// any datadog style tags added to each metric, e.g. "|#env:prod,site:wel"
+	tags string
+}
+
+var stats = &statsd{}
+
+// dialStatsd prepares to send metrics to a StatsD agent, nothing is sent until a metric is recorded.
+func dialStatsd(addr, prefix, tags string) (*statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := statsd{
+		conn:   conn,
+		prefix: prefix,
+	}
+	if tags != "" {
+		s.tags = "|#" + strings.TrimPrefix(tags, "#")
+	}
+	return &s, nil
+}
+
+// send writes a single metric, any problems are ignored as metrics are best effort.
+func (s *statsd) send(name, value, kind string) {
+	if s.conn == nil {
+		return
+	}
+	fmt.Fprintf(s.conn, "%s%s:%s|%s%s", s.prefix, name, value, kind, s.tags)
+}
+
+// Count adds to a counter.
+func (s *statsd) Count(name string, n int) {
+	s.send(name, fmt.Sprint(n), "c")
+}
+
+// Timing records a duration in milliseconds.
+func (s *statsd) Timing(name string, d time.Duration) {
+	s.send(name, fmt.Sprintf("%.3f", d.Seconds()*1000.0), "ms")
+}
+
+func (s *statsd) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
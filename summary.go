@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// summary records the overall results of a run.
+type summary struct {
+	Started  time.Time
+	Finished time.Time
+
+	Files        int
+	SkippedFiles int
+	Records      int
+	Messages     int
+	Errors       int
+	Duplicates   int
+
+	// bytes skipped over while looking for the next record after corrupt data
+	CorruptBytes int64 `json:",omitempty"`
+
+	// records that could not be decoded
+	Undecodable int64 `json:",omitempty"`
+
+	// records skipped by the -starttime and -endtime window, or the -match and -reject patterns
+	OutsideWindow int `json:",omitempty"`
+	Rejected      int `json:",omitempty"`
+
+	Missing []string
+
+	// the results for each stream, and intensity messages generated by MMI
+	Streams   streamSummaries
+	Intensity map[int32]int `json:",omitempty"`
+
+	// messages were not sent
+	DryRun bool `json:",omitempty"`
+
+	// encoded message sizes, and those too large to send
+	Sizes    sizeHistogram
+	Oversize int
+
+	// messages dropped by the -rate-limit or -stream-rate-limit
+	RateLimited int `json:",omitempty"`
+
+	// messages each output delivered, and was unable to deliver
+	Sent   map[string]int `json:",omitempty"`
+	Failed map[string]int `json:",omitempty"`
+
+	// throughput and timings, with -bench
+	Benchmark *benchmarkResult `json:",omitempty"`
+
+	// the run was stopped early by the runtime limit, or an interrupt
+	TimedOut    bool
+	Interrupted bool `json:",omitempty"`
+}
+
+// Print writes a human readable version of the summary.
+func (s *summary) Print(w io.Writer) {
+	fmt.Fprintf(w, "processed %d files (%d skipped) in %s\n", s.Files, s.SkippedFiles, s.Finished.Sub(s.Started))
+	fmt.Fprintf(w, "decoded %d records with %d errors, generated %d messages\n", s.Records, s.Errors, s.Messages)
+	if s.DryRun {
+		fmt.Fprintf(w, "dry run, no messages were sent\n")
+	}
+	if len(s.Intensity) > 0 {
+		var levels []int
+		for mmi := range s.Intensity {
+			levels = append(levels, int(mmi))
+		}
+		sort.Ints(levels)
+		fmt.Fprintf(w, "intensity messages by MMI:")
+		for _, mmi := range levels {
+			fmt.Fprintf(w, " %d:%d", mmi, s.Intensity[int32(mmi)])
+		}
+		fmt.Fprintln(w)
+	}
+	if streams := s.Streams.sorted(); len(streams) > 0 {
+		fmt.Fprintf(w, "records read from %d streams:\n", len(streams))
+		for _, r := range streams {
+			fmt.Fprintf(w, "  %s\t%d records, %d gaps, %d overlaps, %d messages, max MMI %d\n", r.Stream, r.Records, r.Gaps, r.Overlaps, r.Messages, r.MaxMMI)
+		}
+	}
+	if s.Duplicates > 0 {
+		fmt.Fprintf(w, "skipped %d duplicate records\n", s.Duplicates)
+	}
+	if s.Undecodable > 0 {
+		fmt.Fprintf(w, "skipped %d undecodable records\n", s.Undecodable)
+	}
+	if s.CorruptBytes > 0 {
+		fmt.Fprintf(w, "skipped %d bytes of corrupt data\n", s.CorruptBytes)
+	}
+	if s.Rejected > 0 {
+		fmt.Fprintf(w, "skipped %d records from streams not selected\n", s.Rejected)
+	}
+	if s.OutsideWindow > 0 {
+		fmt.Fprintf(w, "skipped %d records outside the time window\n", s.OutsideWindow)
+	}
+	if s.RateLimited > 0 {
+		fmt.Fprintf(w, "dropped %d rate limited messages\n", s.RateLimited)
+	}
+	if s.Sizes.Count > 0 {
+		fmt.Fprintf(w, "message sizes average %d bytes, largest %d bytes, %d oversize\n", s.Sizes.Total/s.Sizes.Count, s.Sizes.Max, s.Oversize)
+	}
+	for name, n := range s.Sent {
+		fmt.Fprintf(w, "%s output delivered %d messages\n", name, n)
+	}
+	for name, n := range s.Failed {
+		fmt.Fprintf(w, "%s output failed to deliver %d messages\n", name, n)
+	}
+	if s.TimedOut {
+		fmt.Fprintf(w, "stopped early on reaching the maximum runtime\n")
+	}
+	if s.Interrupted {
+		fmt.Fprintf(w, "stopped early on an interrupt\n")
+	}
+	if len(s.Missing) > 0 {
+		fmt.Fprintf(w, "%d streams missing from config: %v\n", len(s.Missing), s.Missing)
+	}
+}
+
+// streamResult summarises the records and messages of a single stream.
+type streamResult struct {
+	Stream   string
+	Records  int
+	Gaps     int `json:",omitempty"`
+	Overlaps int `json:",omitempty"`
+	Messages int
+	MaxMMI   int32
+}
+
+// streamSummaries collects the results of each stream, it is safe for concurrent use.
+type streamSummaries struct {
+	results map[string]*streamResult
+	sync.Mutex
+}
+
+// result returns the entry for a stream, adding it if needed, it should be called with the lock held.
+func (s *streamSummaries) result(name string) *streamResult {
+	if s.results == nil {
+		s.results = make(map[string]*streamResult)
+	}
+	r, ok := s.results[name]
+	if !ok {
+		r = &streamResult{Stream: name}
+		s.results[name] = r
+	}
+	return r
+}
+
+// Record counts a record read from a stream.
+func (s *streamSummaries) Record(name string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.result(name).Records++
+}
+
+// Discontinuity counts a gap, or an overlap, between records of a stream.
+func (s *streamSummaries) Discontinuity(name string, overlap bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if r := s.result(name); overlap {
+		r.Overlaps++
+	} else {
+		r.Gaps++
+	}
+}
+
+// Get returns the results so far of a single stream.
+func (s *streamSummaries) Get(name string) (streamResult, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	r, ok := s.results[name]
+	if !ok {
+		return streamResult{Stream: name}, false
+	}
+	return *r, true
+}
+
+// Message counts an intensity message generated for a stream.
+func (s *streamSummaries) Message(name string, mmi int32) {
+	s.Lock()
+	defer s.Unlock()
+
+	r := s.result(name)
+	if r.Messages == 0 || mmi > r.MaxMMI {
+		r.MaxMMI = mmi
+	}
+	r.Messages++
+}
+
+// sorted returns the results in stream order.
+func (s *streamSummaries) sorted() []streamResult {
+	s.Lock()
+	defer s.Unlock()
+
+	var results []streamResult
+	for _, r := range s.results {
+		results = append(results, *r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Stream < results[j].Stream
+	})
+	return results
+}
+
+// MarshalJSON encodes the results as a list in stream order.
+func (s *streamSummaries) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.sorted())
+}
+
+// WriteJSON stores the summary as a single JSON object, a path of "-" indicates stdout, and "stderr" standard error.
+func (s *summary) WriteJSON(path string) error {
+	sort.Strings(s.Missing)
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	switch path {
+	case "-":
+		_, err := os.Stdout.Write(b)
+		return err
+	case "stderr":
+		_, err := os.Stderr.Write(b)
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSummaryJSON(t *testing.T) {
+	b, err := json.Marshal(summary{Files: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"Sent", "Failed", "Intensity", "Benchmark"} {
+		if strings.Contains(string(b), `"`+field+`"`) {
+			t.Errorf("expected an empty %s to be left out: %s", field, b)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// tailer follows growing miniseed files, as written by an acquisition system, processing complete
+// records as they are appended. The patterns are expanded on each poll so new files are picked up.
+type tailer struct {
+	patterns []string
+	interval time.Duration
+	reclen   int
+
+	// read any files found on the first poll from the start, rather than only new records
+	fromStart bool
+
+	files map[string]*tailed
+}
+
+// tailed is the read position of a single file, and its undecodable records, a file with too many is no longer read.
+type tailed struct {
+	info     os.FileInfo
+	offset   int64
+	failures *inputErrors
+	skipped  bool
+}
+
+func newTailer(patterns []string, interval time.Duration, reclen int, fromStart bool) *tailer {
+	return &tailer{
+		patterns:  patterns,
+		interval:  interval,
+		reclen:    reclen,
+		fromStart: fromStart,
+		files:     make(map[string]*tailed),
+	}
+}
+
+// Run polls the files until the stop channel is closed, or the handler returns an error.
+func (t *tailer) Run(msr *mseed.MSRecord, stop <-chan struct{}, handler func(msimpact.Record) error) error {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		if err := t.poll(msr, first, handler); err != nil {
+			if err == errStop {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads any new records from each file matching the patterns.
+func (t *tailer) poll(msr *mseed.MSRecord, first bool, handler func(msimpact.Record) error) error {
+	seen := make(map[string]bool)
+	for _, p := range t.patterns {
+		paths, err := expandInput(p)
+		if err != nil {
+			// the file may not have been created yet
+			slog.Debug("unable to find files to follow", "pattern", p, "error", err)
+			continue
+		}
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			info, err := os.Stat(path)
+			if err != nil {
+				slog.Warn("unable to check followed file", "file", path, "error", err)
+				continue
+			}
+
+			f, ok := t.files[path]
+			switch {
+			case !ok:
+				f = &tailed{info: info, failures: undecodable.Input(path)}
+				if first && !t.fromStart {
+					f.offset = info.Size()
+				}
+				t.files[path] = f
+				slog.Debug("following miniseed file", "file", path, "offset", f.offset)
+			case !os.SameFile(f.info, info) || info.Size() < f.offset:
+				slog.Info("followed file replaced or truncated, reading from the start", "file", path)
+				f.offset, f.failures, f.skipped = 0, undecodable.Input(path), false
+			}
+			f.info = info
+
+			if f.skipped || info.Size() <= f.offset {
+				continue
+			}
+			if err := t.read(path, f, msr, handler); err != nil {
+				if !skippedInput(err) {
+					return err
+				}
+				f.skipped = true
+			}
+		}
+	}
+
+	// forget files that have gone away
+	for path := range t.files {
+		if !seen[path] {
+			delete(t.files, path)
+		}
+	}
+
+	return nil
+}
+
+// read decodes all the complete records after the current offset, a partially written
+// trailing record is left to be read on a later poll.
+func (t *tailer) read(path string, f *tailed, msr *mseed.MSRecord, handler func(msimpact.Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		slog.Warn("unable to open followed file", "file", path, "error", err)
+		return nil
+	}
+	defer file.Close()
+
+	buf := make([]byte, f.info.Size()-f.offset)
+	n, err := file.ReadAt(buf, f.offset)
+	if err != nil && err != io.EOF {
+		slog.Warn("unable to read followed file", "file", path, "error", err)
+		return nil
+	}
+	buf = buf[:n]
+
+	for len(buf) > 0 {
+		var size int
+		switch {
+		case isMS3(buf):
+			size = ms3Length(buf)
+		case t.reclen > 0:
+			size = t.reclen
+		default:
+			if size = recordLength(buf); size == 0 {
+				size = blockSize
+			}
+		}
+		if size <= 0 || size > len(buf) {
+			return nil
+		}
+
+		blk := buf[:size]
+		if isMS3(blk) {
+			r, err := parseMS3(blk)
+			if err != nil {
+				if err := f.failures.Failed(f.offset, err); err != nil {
+					return err
+				}
+			} else if err := handler(r); err != nil {
+				return err
+			}
+		} else {
+			if err := unpack(msr, blk); err != nil {
+				if err := f.failures.Failed(f.offset, err); err != nil {
+					return err
+				}
+			} else if err := handler(msr); err != nil {
+				return err
+			}
+		}
+
+		buf, f.offset = buf[size:], f.offset+int64(size)
+	}
+
+	return nil
+}
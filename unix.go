@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// how long to wait between reconnection attempts
+const unixRedial = time.Second
+
+// how long a write may take before a listening client is dropped, or a connection is redialled
+const unixWriteTimeout = 5 * time.Second
+
+// unixSink writes newline delimited JSON messages to a unix domain socket,
+// either by connecting to a listening consumer or by listening for consumers.
+type unixSink struct {
+	path string
+
+	// listening mode
+	listener net.Listener
+	clients  map[net.Conn]bool
+
+	// connecting mode
+	conn net.Conn
+	last time.Time
+
+	sync.Mutex
+}
+
+func newUnixSink(path string, listen bool) (*unixSink, error) {
+	u := unixSink{
+		path:    path,
+		clients: make(map[net.Conn]bool),
+	}
+
+	if !listen {
+		// an initial failure is not fatal, the consumer may start later
+		if err := u.dial(); err != nil {
+			slog.Warn("unable to connect to unix socket", "path", path, "error", err)
+		}
+		return &u, nil
+	}
+
+	// clear out any stale socket
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	u.listener = l
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			u.Lock()
+			u.clients[c] = true
+			u.Unlock()
+		}
+	}()
+
+	return &u, nil
+}
+
+func (u *unixSink) dial() error {
+	u.last = time.Now()
+	c, err := net.Dial("unix", u.path)
+	if err != nil {
+		return err
+	}
+	u.conn = c
+	return nil
+}
+
+func (u *unixSink) Send(key string, msg []byte) error {
+	u.Lock()
+	defer u.Unlock()
+
+	line := append(append([]byte{}, msg...), '\n')
+
+	if u.listener != nil {
+		// a client that stops reading is dropped rather than holding up the others
+		for c := range u.clients {
+			if err := u.write(c, line); err != nil {
+				slog.Warn("dropping unix socket client", "path", u.path, "error", err)
+				c.Close()
+				delete(u.clients, c)
+			}
+		}
+		return nil
+	}
+
+	if u.conn == nil {
+		if time.Since(u.last) < unixRedial {
+			return errors.New("not connected to unix socket " + u.path)
+		}
+		if err := u.dial(); err != nil {
+			return fmt.Errorf("unable to reconnect to unix socket: %w", err)
+		}
+	}
+	if err := u.write(u.conn, line); err != nil {
+		slog.Warn("unix socket write problem, reconnecting", "path", u.path, "error", err)
+		u.conn.Close()
+		u.conn = nil
+		// try once more straight away before giving up on the message
+		if err := u.dial(); err != nil {
+			return fmt.Errorf("unable to reconnect to unix socket: %w", err)
+		}
+		if err := u.write(u.conn, line); err != nil {
+			u.conn.Close()
+			u.conn = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write sends a line, giving up if the other end is not reading.
+func (u *unixSink) write(c net.Conn, line []byte) error {
+	if err := c.SetWriteDeadline(time.Now().Add(unixWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := c.Write(line)
+	return err
+}
+
+func (u *unixSink) Close() error {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.listener != nil {
+		for c := range u.clients {
+			c.Close()
+		}
+		u.listener.Close()
+		return os.Remove(u.path)
+	}
+	if u.conn != nil {
+		return u.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// build information, usually set with e.g. -ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.buildDate=..."
+var (
+	version   = "devel"
+	commit    = ""
+	buildDate = ""
+)
+
+// the linked libraries whose versions are reported, the ozym/mseed package wraps libmseed
+var versionModules = []string{
+	"github.com/ozym/impact",
+	"github.com/ozym/mseed",
+}
+
+// buildInfo fills in any commit or build date not set at link time from the embedded vcs details,
+// and returns the versions of the reported modules.
+func buildInfo() (string, string, map[string]string) {
+	rev, date, modules := commit, buildDate, make(map[string]string)
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return rev, date, modules
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && rev == "":
+			rev = s.Value
+		case s.Key == "vcs.time" && date == "":
+			date = s.Value
+		}
+	}
+	for _, dep := range info.Deps {
+		for _, m := range versionModules {
+			if dep.Path == m {
+				modules[m] = dep.Version
+			}
+		}
+	}
+
+	return rev, date, modules
+}
+
+// printVersion writes the version and build information.
+func printVersion(w io.Writer) {
+	rev, date, modules := buildInfo()
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	fmt.Fprintf(w, "msimpact %s (commit %s, built %s, %s)\n", version, rev, date, runtime.Version())
+	for _, m := range versionModules {
+		v, ok := modules[m]
+		if !ok {
+			v = "unknown"
+		}
+		fmt.Fprintf(w, "  %s %s\n", m, v)
+	}
+}
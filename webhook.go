@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// headerList collects repeated "Name: value" flags.
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("expected a \"Name: value\" header, got %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// webhookError is returned when the endpoint does not accept a message.
+type webhookError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("webhook returned %s", e.Status)
+}
+
+// webhookSink posts each message as JSON to an http(s) endpoint.
+type webhookSink struct {
+	client  *http.Client
+	url     string
+	headers http.Header
+
+	// adds a signature header, if set
+	signer *messageSigner
+}
+
+func newWebhookSink(url string, headers []string, timeout time.Duration) *webhookSink {
+	h := make(http.Header)
+	for _, v := range headers {
+		parts := strings.SplitN(v, ":", 2)
+		h.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return &webhookSink{
+		client:  &http.Client{Timeout: timeout},
+		url:     url,
+		headers: h,
+	}
+}
+
+func (w *webhookSink) Send(key string, msg []byte) error {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "msimpact")
+	if w.signer != nil {
+		req.Header.Set(signatureHeader, "sha256="+w.signer.Sign(msg))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// allow the connection to be reused
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &webhookError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	return nil
+}
+
+func (w *webhookSink) Close() error {
+	return nil
+}
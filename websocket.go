@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// messages waiting for each websocket client before it is considered too slow and dropped
+const websocketQueue = 256
+
+// how long a websocket client has to accept each message
+const websocketTimeout = 10 * time.Second
+
+// websocketSink broadcasts each message to every connected websocket client, e.g. a browser dashboard,
+// clients that can't keep up are disconnected rather than holding up the other outputs.
+type websocketSink struct {
+	server  *http.Server
+	clients map[chan []byte]bool
+
+	sync.Mutex
+}
+
+func newWebsocketSink(addr string) (*websocketSink, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := websocketSink{
+		clients: make(map[chan []byte]bool),
+	}
+
+	upgrader := websocket.Upgrader{
+		// dashboards may be served from elsewhere
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			slog.Debug("unable to upgrade websocket connection", "remote", r.RemoteAddr, "error", err)
+			return
+		}
+		ws.serve(conn, r.RemoteAddr)
+	})
+	ws.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := ws.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			slog.Error("websocket server stopped", "addr", addr, "error", err)
+		}
+	}()
+
+	return &ws, nil
+}
+
+// serve writes messages to a single client until it goes away or is too slow.
+func (ws *websocketSink) serve(conn *websocket.Conn, remote string) {
+	defer conn.Close()
+
+	queue := make(chan []byte, websocketQueue)
+	ws.Lock()
+	ws.clients[queue] = true
+	ws.Unlock()
+	slog.Debug("websocket client connected", "remote", remote)
+
+	// reading picks up the client closing the connection
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(4096)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		ws.Lock()
+		delete(ws.clients, queue)
+		ws.Unlock()
+		slog.Debug("websocket client disconnected", "remote", remote)
+	}()
+
+	for {
+		select {
+		case msg, ok := <-queue:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(websocketTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+func (ws *websocketSink) Send(key string, msg []byte) error {
+	ws.Lock()
+	defer ws.Unlock()
+
+	for queue := range ws.clients {
+		select {
+		case queue <- msg:
+		default:
+			slog.Warn("websocket client too slow, disconnecting")
+			delete(ws.clients, queue)
+			close(queue)
+		}
+	}
+	return nil
+}
+
+func (ws *websocketSink) Close() error {
+	ws.Lock()
+	for queue := range ws.clients {
+		delete(ws.clients, queue)
+		close(queue)
+	}
+	ws.Unlock()
+
+	return ws.server.Close()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/ozym/msimpact/msimpact"
+	"strings"
+	"time"
+)
+
+// timeWindow limits file replay to records overlapping a period, a zero start or end leaves it open.
+type timeWindow struct {
+	start, end time.Time
+}
+
+// parseWindowTime decodes an RFC3339 time, or a UTC date, an empty string gives a zero time.
+func parseWindowTime(s string) (time.Time, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// contains checks whether any of the record's samples fall within the window.
+func (w timeWindow) contains(msr msimpact.Record) bool {
+	start := msr.Starttime()
+	if !w.end.IsZero() && !start.Before(w.end) {
+		return false
+	}
+	if w.start.IsZero() {
+		return true
+	}
+	end := start
+	if rate := msr.Samprate(); rate > 0.0 && msr.Samplecnt() > 0 {
+		end = start.Add(time.Duration(float64(msr.Samplecnt()-1) / rate * float64(time.Second)))
+	}
+	return !end.Before(w.start)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"hash/fnv"
+	"sync"
+)
+
+// records waiting for each shard
+const shardQueue = 256
+
+// sharedPipeline builds a pipeline for another processor, or for concurrent use, which shares the base
+// pipeline's sink, which must be safe for concurrent use, and problem handler, guarded by mu.
+func sharedPipeline(base *msimpact.Pipeline, processor msimpact.Processor, mu *sync.Mutex) *msimpact.Pipeline {
+	return &msimpact.Pipeline{
+		Processor: processor,
+		Sink:      base.Sink,
+		Problem: func(msr msimpact.Record, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			base.Problem(msr, err)
+		},
+		Observe: base.Observe,
+	}
+}
+
+// shard chooses which of n pipelines processes a record, by its network and station, so every stream of a
+// station, such as the components of a horizontal pair, is processed by the same pipeline.
+func shard(r msimpact.Record, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(r.Network() + "." + r.Station()))
+	return int(h.Sum32() % uint32(n))
+}
+
+// shardRecords reads the files using a pool of workers, each record is copied and passed to one of the
+// pipelines chosen by its station, so each stream is only ever processed by the same pipeline. The read
+// function is called concurrently, the first error, including errStop, ends the run and is returned.
+func shardRecords(files []string, workers int, pipelines []*msimpact.Pipeline, read func(file string, msr *mseed.MSRecord, handler func(msimpact.Record) error) error) error {
+	var mu sync.Mutex
+	var first error
+	done := make(chan struct{})
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if first == nil {
+			first = err
+			close(done)
+		}
+	}
+
+	// one processing goroutine per pipeline, draining its queue even after a problem
+	queues := make([]chan msimpact.Record, len(pipelines))
+	var processing sync.WaitGroup
+	for i, p := range pipelines {
+		queues[i] = make(chan msimpact.Record, shardQueue)
+		processing.Add(1)
+		go func(p *msimpact.Pipeline, queue <-chan msimpact.Record) {
+			defer processing.Done()
+			err := p.Run(msimpact.SourceFunc(func(handler func(msimpact.Record) error) error {
+				for r := range queue {
+					if err := handler(r); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+			if err != nil {
+				fail(err)
+				for range queue {
+				}
+			}
+		}(p, queues[i])
+	}
+
+	// the readers share out the files
+	pending := make(chan string)
+	var reading sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+
+			msr := mseed.NewMSRecord()
+			defer mseed.FreeMSRecord(msr)
+
+			for file := range pending {
+				err := read(file, msr, func(r msimpact.Record) error {
+					s := msimpact.Snapshot(r)
+					select {
+					case queues[shard(s, len(queues))] <- s:
+						return nil
+					case <-done:
+						return errStop
+					}
+				})
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, file := range files {
+		select {
+		case pending <- file:
+		case <-done:
+			break feed
+		}
+	}
+	close(pending)
+	reading.Wait()
+
+	for _, q := range queues {
+		close(q)
+	}
+	processing.Wait()
+
+	return first
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ozym/impact"
+	"github.com/ozym/mseed"
+	"github.com/ozym/msimpact/msimpact"
+	"github.com/ozym/msimpact/msimpact/msimpacttest"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardPairs(t *testing.T) {
+	const (
+		workers  = 4
+		stations = 8
+		rate     = 100.0
+		gain     = 1.0e6
+	)
+	start := time.Date(2016, time.November, 13, 11, 0, 0, 0, time.UTC)
+
+	// a horizontal pair at each station, one file for each component
+	config := func() *msimpact.Config {
+		c := msimpact.Config{Streams: make(map[string]*impact.Stream), Settings: make(map[string]msimpact.StreamConfig)}
+		for i := 0; i < stations; i++ {
+			for _, pair := range [][2]string{{"HNN", "HNE"}, {"HNE", "HNN"}} {
+				s := fmt.Sprintf("XX_ST%02d_10_%s", i, pair[0])
+				c.Streams[s] = &impact.Stream{Name: "Test", Latitude: -41.0, Longitude: 174.5, Q: 0.98, Rate: rate, Gain: gain}
+				c.Settings[s] = msimpact.StreamConfig{Horizontal: pair[1]}
+			}
+		}
+		return &c
+	}
+	records := make(map[string]msimpacttest.Source)
+	var files []string
+	for s := range config().Streams {
+		for i := 0; i < 10; i++ {
+			samples := make([]int32, int(rate))
+			for j := range samples {
+				samples[j] = int32(gain * 0.01 * math.Sin(2.0*math.Pi*float64(j)/rate))
+			}
+			records[s] = append(records[s], msimpacttest.NewRecord(s, start.Add(time.Duration(i)*time.Second), rate, samples))
+		}
+		files = append(files, s)
+	}
+
+	sink := &msimpacttest.Sink{}
+	base := msimpact.Pipeline{
+		Sink: sink,
+		Problem: func(msr msimpact.Record, err error) {
+			t.Errorf("%s: %s", msr.SrcName(0), err)
+		},
+	}
+	var mu sync.Mutex
+	var pipelines []*msimpact.Pipeline
+	for i := 0; i < workers; i++ {
+		p, err := msimpact.NewStreamProcessor(msimpact.Options{InitialMMI: -1}, config())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipelines = append(pipelines, sharedPipeline(&base, p, &mu))
+	}
+
+	err := shardRecords(files, workers, pipelines, func(file string, msr *mseed.MSRecord, handler func(msimpact.Record) error) error {
+		return records[file].Records(handler)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := sink.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	combined := make(map[string]bool)
+	for _, m := range messages {
+		if !strings.HasSuffix(m.Stream, "_HNH") {
+			t.Errorf("expected only combined messages, got %s", m.Stream)
+		}
+		combined[m.Stream] = true
+	}
+	if len(combined) != stations {
+		t.Errorf("expected combined messages from %d stations, got %d", stations, len(combined))
+	}
+}